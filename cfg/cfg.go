@@ -0,0 +1,10 @@
+// Package cfg has the default configuration files compiled into the binary,
+// which are also the content of the kstat configmap in the manifests. The
+// files at the top are of the node profile, and the other profiles are in the
+// directories, e.g. pod/metrics.yaml.
+package cfg
+
+import "embed"
+
+//go:embed *.yaml *.tmpl pod storage network namespace
+var FS embed.FS
@@ -0,0 +1,8 @@
+// Package deploy has the templates of the manifests compiled into the
+// binary, rendered by pkg/manifest
+package deploy
+
+import "embed"
+
+//go:embed *.yaml
+var FS embed.FS
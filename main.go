@@ -1,14 +1,25 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
+	"code.cloudfoundry.org/bytefmt"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 
 	"github.com/yasker/kstat/pkg/client"
+	"github.com/yasker/kstat/pkg/config"
+	"github.com/yasker/kstat/pkg/filter"
+	"github.com/yasker/kstat/pkg/kube"
+	"github.com/yasker/kstat/pkg/manifest"
 	"github.com/yasker/kstat/pkg/server"
+	"github.com/yasker/kstat/pkg/types"
 	"github.com/yasker/kstat/pkg/version"
 )
 
@@ -18,11 +29,60 @@ const (
 	FlagMetricConfigFile = "metrics-config"
 
 	FlagServer             = "server"
+	FlagContext            = "context"
+	FlagKubeconfig         = "kubeconfig"
+	FlagKubeContext        = "kube-context"
+	FlagNamespace          = "namespace"
+	FlagClientConfigFile   = "client-config"
+	FlagProfile            = "profile"
 	FlagMetricFormatFile   = "metrics-format"
 	FlagHeaderTemplateFile = "header-template"
 	FlagOutputTemplateFile = "output-template"
 	FlagShowDevices        = "show-devices"
 	FlagTop                = "top"
+	FlagSortBy             = "sort-by"
+	FlagSortOrder          = "sort-order"
+	FlagLimit              = "limit"
+	FlagWhere              = "where"
+	FlagInstanceRegex      = "instance-regex"
+	FlagDeviceRegex        = "device-regex"
+	FlagLabelSelector      = "selector"
+	FlagHideIdle           = "hide-idle"
+	FlagTopDevices         = "top-devices"
+	FlagInstance           = "instance"
+	FlagDelta              = "delta"
+	FlagSmooth             = "smooth"
+	FlagSmoothFunc         = "smooth-func"
+	FlagHeatmap            = "heatmap"
+	FlagGroups             = "groups"
+	FlagCPU                = "cpu"
+	FlagMem                = "mem"
+	FlagDisk               = "disk"
+	FlagNet                = "net"
+
+	FlagCSVFile           = "output-csv"
+	FlagCSVRotateSize     = "csv-rotate-size"
+	FlagCSVRotateInterval = "csv-rotate-interval"
+
+	FlagRecordFile  = "file"
+	FlagReplaySpeed = "speed"
+
+	FlagFrom = "from"
+	FlagTo   = "to"
+	FlagStep = "step"
+
+	FlagSince = "since"
+
+	FlagImage      = "image"
+	FlagValuesFile = "values"
+	FlagSet        = "set"
+	FlagDryRun     = "dry-run"
+
+	FlagDeleteNamespace = "delete-namespace"
+)
+
+const (
+	DefaultServerAddress = "localhost:9159"
 )
 
 func ServerCmd() cli.Command {
@@ -41,8 +101,7 @@ func ServerCmd() cli.Command {
 			},
 			cli.StringFlag{
 				Name:  FlagMetricConfigFile,
-				Usage: "Specify the metric config yaml",
-				Value: "cfg/metrics.yaml",
+				Usage: "Specify the metric config yaml of the " + types.DefaultProfile + " profile, the other profiles are <profile>/metrics.yaml (default: metrics.yaml in " + configSearchPath() + ", or the built-in one)",
 			},
 		},
 		Action: func(c *cli.Context) {
@@ -53,42 +112,406 @@ func ServerCmd() cli.Command {
 	}
 }
 
+// displayFlags are shared by all the commands showing the stat
+func displayFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.BoolFlag{
+			Name:  FlagShowDevices,
+			Usage: "If show devices in the output",
+		},
+		cli.BoolFlag{
+			Name:  FlagTop,
+			Usage: "Show in `top` style",
+		},
+		cli.StringFlag{
+			Name:  FlagSortBy,
+			Usage: "Sort the instances and devices by the metric, e.g. cpu_user",
+		},
+		cli.StringFlag{
+			Name:  FlagSortOrder,
+			Usage: "Sort order, asc or desc",
+			Value: client.SortOrderDescending,
+		},
+		cli.IntFlag{
+			Name:  FlagLimit,
+			Usage: "Only show the first N instances",
+		},
+		cli.StringFlag{
+			Name:  FlagWhere,
+			Usage: "Only show the instances and devices match the expression, e.g. 'cpu_idle < 20 || disk_write > 50M'",
+		},
+		cli.StringFlag{
+			Name:  FlagInstanceRegex,
+			Usage: "Only show the instances with the name matches the regex",
+		},
+		cli.StringFlag{
+			Name:  FlagDeviceRegex,
+			Usage: "Only show the devices with the name matches the regex, e.g. 'disk: sd.*'",
+		},
+		cli.StringFlag{
+			Name:  FlagLabelSelector + ", l",
+			Usage: "Only show the devices with the labels match the comma separated selector, and the instances with such devices, e.g. 'namespace=kube-system,pod=~coredns.*'",
+		},
+		cli.BoolFlag{
+			Name:  FlagHideIdle + ", active-only",
+			Usage: "Hide the instances and devices with all the metrics at the idle level defined in the metric format",
+		},
+		cli.IntFlag{
+			Name:  FlagTopDevices,
+			Usage: "Only show the K busiest devices of each kind for every instance",
+		},
+		cli.StringFlag{
+			Name:  FlagInstance,
+			Usage: "Show the detail view of the instance, with every metric, device, recent history and labels",
+		},
+		cli.BoolFlag{
+			Name:  FlagDelta,
+			Usage: "Show the change of every metric since the previous sample instead of the value",
+		},
+		cli.IntFlag{
+			Name:  FlagSmooth,
+			Usage: "Show the rolling average or maximum of every metric over the last N samples",
+		},
+		cli.StringFlag{
+			Name:  FlagSmoothFunc,
+			Usage: "Function to smooth the samples, avg or max",
+			Value: client.SmoothFuncAvg,
+		},
+		cli.StringFlag{
+			Name:  FlagHeatmap,
+			Usage: "Show the heatmap of the per-device metric, with a row per instance and a cell per device, e.g. cpu_user",
+		},
+		cli.StringFlag{
+			Name:  FlagGroups,
+			Usage: "Only show the comma separated groups of the metrics in the order, e.g. cpu,disk, instead of the templates",
+		},
+		cli.BoolFlag{
+			Name:  FlagCPU + ", c",
+			Usage: "Show the cpu group, same as --groups cpu",
+		},
+		cli.BoolFlag{
+			Name:  FlagMem + ", m",
+			Usage: "Show the mem group, same as --groups mem",
+		},
+		cli.BoolFlag{
+			Name:  FlagDisk + ", d",
+			Usage: "Show the disk group, same as --groups disk",
+		},
+		cli.BoolFlag{
+			Name:  FlagNet + ", n",
+			Usage: "Show the net group, same as --groups net",
+		},
+	}
+}
+
+// kubeFlags find the kstat server in the Kubernetes cluster, if the server is
+// not specified
+func kubeFlags() []cli.Flag {
+	return append(kubeconfigFlags(), namespaceFlag())
+}
+
+func profileFlag() cli.Flag {
+	return cli.StringFlag{
+		Name:  FlagProfile,
+		Usage: "Show the metrics of the profile, one of " + strings.Join(config.Profiles(), ", ") + ", with the metrics format and the templates of the profile",
+		Value: types.DefaultProfile,
+	}
+}
+
+func kubeconfigFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:  FlagKubeconfig,
+			Usage: "Specify the kubeconfig file to access the Kubernetes cluster, instead of $KUBECONFIG or ~/.kube/config",
+		},
+		cli.StringFlag{
+			Name:  FlagKubeContext,
+			Usage: "Specify the context in the kubeconfig, instead of the current context",
+		},
+	}
+}
+
+func namespaceFlag() cli.Flag {
+	return cli.StringFlag{
+		Name:  FlagNamespace,
+		Usage: "Specify the namespace of kstat",
+		Value: kube.DefaultNamespace,
+	}
+}
+
+// valuesFlags set the values of the manifests
+func valuesFlags() []cli.Flag {
+	return []cli.Flag{
+		namespaceFlag(),
+		cli.StringFlag{
+			Name:  FlagImage,
+			Usage: "Specify the kstat image (default: " + manifest.DefaultImage() + ")",
+		},
+		cli.StringFlag{
+			Name:  FlagValuesFile,
+			Usage: "Specify the yaml file of the values of the manifests",
+		},
+		cli.StringSliceFlag{
+			Name:  FlagSet,
+			Usage: "Set the value of the manifests by the path, e.g. prometheus.retention_size=1GB or prometheus.resources.limits.memory=1Gi",
+		},
+	}
+}
+
+// installFlags are shared by the commands changing the installation
+func installFlags() []cli.Flag {
+	flags := append(kubeconfigFlags(), valuesFlags()...)
+	return append(flags,
+		cli.BoolFlag{
+			Name:  FlagDryRun,
+			Usage: "Only show the changes without applying them",
+		},
+	)
+}
+
+func statFlags() []cli.Flag {
+	flags := append(displayFlags(), kubeFlags()...)
+	return append(flags,
+		cli.StringSliceFlag{
+			Name:  FlagServer,
+			Usage: "Specify the kstat server, or a comma separated list of servers to fail over between. Repeat as name=server to merge the clusters, e.g. prod-a=host1:9159 (default: the kstat service of the kubeconfig, or " + DefaultServerAddress + " without kubeconfig)",
+		},
+		cli.StringFlag{
+			Name:  FlagClientConfigFile,
+			Usage: "Specify the client config yaml with the named contexts of the clusters",
+		},
+		cli.StringSliceFlag{
+			Name:  FlagContext,
+			Usage: "Merge the clusters of the named contexts in the client config, all of them if not specified",
+		},
+		profileFlag(),
+		cli.StringFlag{
+			Name:  FlagMetricFormatFile,
+			Usage: "Specify the metric format yaml (default: metrics-format.yaml of the profile in " + configSearchPath() + ", or the built-in one)",
+		},
+		cli.StringFlag{
+			Name:  FlagHeaderTemplateFile,
+			Usage: "Specify the header template file, or a built-in one by the name, e.g. header-bar.tmpl (default: header.tmpl of the profile in " + configSearchPath() + ", or the built-in one)",
+		},
+		cli.StringFlag{
+			Name:  FlagOutputTemplateFile,
+			Usage: "Specify the output template file, or a built-in one by the name, e.g. output-bar.tmpl (default: output.tmpl of the profile in " + configSearchPath() + ", or the built-in one)",
+		},
+		cli.StringFlag{
+			Name:  FlagCSVFile,
+			Usage: "Also append every sample to the CSV file",
+		},
+		cli.StringFlag{
+			Name:  FlagCSVRotateSize,
+			Usage: "Rotate the CSV file when it reaches the size, e.g. 100M",
+		},
+		cli.DurationFlag{
+			Name:  FlagCSVRotateInterval,
+			Usage: "Rotate the CSV file after the interval, e.g. 1h",
+		},
+		cli.StringFlag{
+			Name:  FlagFrom,
+			Usage: "Play back the past window from the time in Prometheus instead of the live metrics, e.g. \"2006-01-02 15:04\", \"15:04\" or \"now-1h\"",
+		},
+		cli.StringFlag{
+			Name:  FlagTo,
+			Usage: "The end of the past window to play back",
+			Value: "now",
+		},
+		cli.DurationFlag{
+			Name:  FlagStep,
+			Usage: "The interval between the snapshots of the past window",
+			Value: types.PollInterval,
+		},
+		speedFlag(),
+	)
+}
+
+func speedFlag() cli.Flag {
+	return cli.Float64Flag{
+		Name:  FlagReplaySpeed,
+		Usage: "Play back at the speed relative to the original pace, 0 means as fast as possible",
+		Value: 1,
+	}
+}
+
 func StatCmd() cli.Command {
 	return cli.Command{
-		Name: "stat",
-		Flags: []cli.Flag{
+		Name:  "stat",
+		Flags: statFlags(),
+		Action: func(c *cli.Context) {
+			if err := stat(c); err != nil {
+				logrus.Fatalf("Error running stat: %v", err)
+			}
+		},
+	}
+}
+
+func PodsCmd() cli.Command {
+	return cli.Command{
+		Name:  "pods",
+		Usage: "Show the pods of every node, the stat of the pod profile with the devices",
+		Flags: statFlags(),
+		Action: func(c *cli.Context) {
+			if err := statProfile(c, types.PodProfile, true); err != nil {
+				logrus.Fatalf("Error running pods: %v", err)
+			}
+		},
+	}
+}
+
+func NamespacesCmd() cli.Command {
+	return cli.Command{
+		Name:  "namespaces",
+		Usage: "Show the usage of every namespace next to its requests and limits, the stat of the namespace profile",
+		Flags: statFlags(),
+		Action: func(c *cli.Context) {
+			if err := statProfile(c, types.NamespaceProfile, false); err != nil {
+				logrus.Fatalf("Error running namespaces: %v", err)
+			}
+		},
+	}
+}
+
+func RecordCmd() cli.Command {
+	return cli.Command{
+		Name:  "record",
+		Usage: "Show the stat and record the session to a file",
+		Flags: append(statFlags(),
+			cli.StringFlag{
+				Name:  FlagRecordFile,
+				Usage: "Specify the file to record the session to",
+				Value: "session.kstat",
+			},
+		),
+		Action: func(c *cli.Context) {
+			if err := stat(c); err != nil {
+				logrus.Fatalf("Error recording: %v", err)
+			}
+		},
+	}
+}
+
+func ReplayCmd() cli.Command {
+	return cli.Command{
+		Name:      "replay",
+		Usage:     "Replay a recorded session",
+		ArgsUsage: "<record file>",
+		Flags:     append(displayFlags(), speedFlag()),
+		Action: func(c *cli.Context) {
+			if err := replay(c); err != nil {
+				logrus.Fatalf("Error replaying: %v", err)
+			}
+		},
+	}
+}
+
+func GraphCmd() cli.Command {
+	return cli.Command{
+		Name:      "graph",
+		Usage:     "Draw a metric over time as a chart in the terminal",
+		ArgsUsage: "<metric>",
+		Flags: append(kubeFlags(),
 			cli.StringFlag{
 				Name:  FlagServer,
-				Usage: "Specify the kstat server",
-				Value: "localhost:9159",
+				Usage: "Specify the kstat server, or a comma separated list of servers to fail over between (default: the kstat service of the kubeconfig, or " + DefaultServerAddress + " without kubeconfig)",
 			},
+			profileFlag(),
 			cli.StringFlag{
 				Name:  FlagMetricFormatFile,
-				Usage: "Specify the metric format yaml",
-				Value: "cfg/metrics-format.yaml",
+				Usage: "Specify the metric format yaml (default: metrics-format.yaml of the profile in " + configSearchPath() + ", or the built-in one)",
 			},
 			cli.StringFlag{
-				Name:  FlagHeaderTemplateFile,
-				Usage: "Specify the header template file",
-				Value: "cfg/header.tmpl",
+				Name:  FlagInstance,
+				Usage: "Only draw the instances with the name matches the regex",
 			},
 			cli.StringFlag{
-				Name:  FlagOutputTemplateFile,
-				Usage: "Specify the output template file",
-				Value: "cfg/output.tmpl",
+				Name:  FlagDeviceRegex,
+				Usage: "Only draw the devices with the name matches the regex, e.g. 'disk: sd.*'",
 			},
 			cli.BoolFlag{
 				Name:  FlagShowDevices,
-				Usage: "If show devices in the output",
+				Usage: "Draw one line per device instead of per instance",
 			},
-			cli.BoolFlag{
-				Name:  FlagTop,
-				Usage: "Show in `top` style",
+			cli.DurationFlag{
+				Name:  FlagSince,
+				Usage: "Draw the time window until now",
+				Value: client.DefaultGraphSince,
 			},
+		),
+		Action: func(c *cli.Context) {
+			if err := graph(c); err != nil {
+				logrus.Fatalf("Error drawing graph: %v", err)
+			}
 		},
+	}
+}
+
+func ManifestCmd() cli.Command {
+	return cli.Command{
+		Name:  "manifest",
+		Usage: "Print the manifests of kstat rendered with the values",
+		Flags: valuesFlags(),
 		Action: func(c *cli.Context) {
-			if err := stat(c); err != nil {
-				logrus.Fatalf("Error running stat: %v", err)
+			if err := printManifest(c); err != nil {
+				logrus.Fatalf("Error rendering manifests: %v", err)
+			}
+		},
+	}
+}
+
+func ConfigCmd() cli.Command {
+	return cli.Command{
+		Name:      "config",
+		Usage:     "Print the effective configuration file merged with the overlays, or list the files and where they are from",
+		ArgsUsage: "[file]",
+		Action: func(c *cli.Context) {
+			if err := printConfig(c); err != nil {
+				logrus.Fatalf("Error printing the configuration: %v", err)
+			}
+		},
+	}
+}
+
+func InstallCmd() cli.Command {
+	return cli.Command{
+		Name:  "install",
+		Usage: "Install kstat into the Kubernetes cluster",
+		Flags: installFlags(),
+		Action: func(c *cli.Context) {
+			if err := install(c, false); err != nil {
+				logrus.Fatalf("Error installing: %v", err)
+			}
+		},
+	}
+}
+
+func UpgradeCmd() cli.Command {
+	return cli.Command{
+		Name:  "upgrade",
+		Usage: "Upgrade kstat in the Kubernetes cluster to the manifests of this version and the values",
+		Flags: installFlags(),
+		Action: func(c *cli.Context) {
+			if err := install(c, true); err != nil {
+				logrus.Fatalf("Error upgrading: %v", err)
+			}
+		},
+	}
+}
+
+func UninstallCmd() cli.Command {
+	return cli.Command{
+		Name:  "uninstall",
+		Usage: "Uninstall kstat from the Kubernetes cluster",
+		Flags: append(installFlags(),
+			cli.BoolFlag{
+				Name:  FlagDeleteNamespace,
+				Usage: "Also delete the namespace if it's not created by kstat",
+			},
+		),
+		Action: func(c *cli.Context) {
+			if err := uninstall(c); err != nil {
+				logrus.Fatalf("Error uninstalling: %v", err)
 			}
 		},
 	}
@@ -99,10 +522,26 @@ func main() {
 	app.Name = "kstat"
 	app.Version = version.FriendlyVersion()
 	app.Usage = "dstat for Kubernetes"
-	app.Flags = []cli.Flag{}
+	// run stat without the command, e.g. as the kubectl plugin "kubectl kstat"
+	app.Flags = statFlags()
+	app.Action = func(c *cli.Context) {
+		if err := stat(c); err != nil {
+			logrus.Fatalf("Error running stat: %v", err)
+		}
+	}
 	app.Commands = []cli.Command{
 		ServerCmd(),
 		StatCmd(),
+		PodsCmd(),
+		NamespacesCmd(),
+		RecordCmd(),
+		ReplayCmd(),
+		GraphCmd(),
+		ManifestCmd(),
+		InstallCmd(),
+		UpgradeCmd(),
+		UninstallCmd(),
+		ConfigCmd(),
 	}
 
 	if err := app.Run(os.Args); err != nil {
@@ -126,17 +565,377 @@ func startServer(c *cli.Context) error {
 	return nil
 }
 
+// statProfile runs the stat of the profile unless another one is specified,
+// and always shows the devices if showDevices
+func statProfile(c *cli.Context, profile string, showDevices bool) error {
+	if !c.IsSet(FlagProfile) {
+		if err := c.Set(FlagProfile, profile); err != nil {
+			return err
+		}
+	}
+	if showDevices {
+		if err := c.Set(FlagShowDevices, "true"); err != nil {
+			return err
+		}
+	}
+	return stat(c)
+}
+
 func stat(c *cli.Context) error {
-	serverAddr := c.String(FlagServer)
+	serverAddr, clusters, err := servers(c)
+	if err != nil {
+		return err
+	}
+	var dialer client.Dialer
+	if serverAddr == "" && len(clusters) == 0 {
+		if serverAddr, dialer, err = kubeServer(c); err != nil {
+			return err
+		}
+	}
 	metricFormatFile := c.String(FlagMetricFormatFile)
 	headerTmplFile := c.String(FlagHeaderTemplateFile)
 	outputTmplFile := c.String(FlagOutputTemplateFile)
 
 	client := client.NewClient(serverAddr, metricFormatFile, headerTmplFile, outputTmplFile)
-	client.ShowDevices = c.Bool(FlagShowDevices)
-	client.ShowAsTop = c.Bool(FlagTop)
+	if err := setDisplayOptions(c, client); err != nil {
+		return err
+	}
+	client.Dialer = dialer
+	client.Profile = c.String(FlagProfile)
+	client.Clusters = clusters
+	client.RecordFile = c.String(FlagRecordFile)
+	client.CSVFile = c.String(FlagCSVFile)
+	client.CSVRotateInterval = c.Duration(FlagCSVRotateInterval)
+	if size := c.String(FlagCSVRotateSize); size != "" {
+		rotateSize, err := bytefmt.ToBytes(size)
+		if err != nil {
+			return errors.Wrapf(err, "invalid CSV rotate size %v", size)
+		}
+		client.CSVRotateSize = int64(rotateSize)
+	}
+	if c.String(FlagFrom) != "" {
+		return playback(c, client)
+	}
 	if err := client.Start(); err != nil {
 		return err
 	}
 	return nil
 }
+
+// servers returns the address of the single server, or the clusters to merge
+// if the servers are named or from the contexts of the client config. Both
+// are empty if no server is specified.
+func servers(c *cli.Context) (string, []*client.Cluster, error) {
+	clusters := []*client.Cluster{}
+	if c.String(FlagClientConfigFile) != "" {
+		contexts, err := client.LoadContexts(c.String(FlagClientConfigFile), c.StringSlice(FlagContext))
+		if err != nil {
+			return "", nil, err
+		}
+		clusters = append(clusters, contexts...)
+	} else if len(c.StringSlice(FlagContext)) != 0 {
+		return "", nil, fmt.Errorf("--%v requires --%v", FlagContext, FlagClientConfigFile)
+	}
+
+	servers := c.StringSlice(FlagServer)
+	if len(servers) == 1 && !strings.Contains(servers[0], "=") && len(clusters) == 0 {
+		return servers[0], nil, nil
+	}
+	for _, s := range servers {
+		cluster, err := client.ParseCluster(s)
+		if err != nil {
+			return "", nil, err
+		}
+		clusters = append(clusters, cluster)
+	}
+	return "", clusters, nil
+}
+
+// kubeServer returns the kstat service of the kubeconfig and the dialer to
+// connect to it through the port forward, or the default server if there is
+// no kubeconfig
+func kubeServer(c *cli.Context) (string, client.Dialer, error) {
+	cfg, err := loadKubeconfig(c)
+	if err == kube.ErrNoKubeconfig && c.String(FlagKubeconfig) == "" && c.String(FlagKubeContext) == "" {
+		return DefaultServerAddress, nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	ns := c.String(FlagNamespace)
+	kc := kube.NewClient(cfg)
+	// fail early if the service is not installed or not accessible, the
+	// dialer only reports the error as reconnecting
+	ctx, cancel := context.WithTimeout(context.Background(), types.GRPCServiceTimeout)
+	defer cancel()
+	if _, err := kc.GetService(ctx, ns, kube.DefaultService); err != nil {
+		if kube.IsNotFound(err) {
+			return "", nil, errors.Wrapf(err, "kstat is not installed in namespace %v of context %v", ns, cfg.Context)
+		}
+		return "", nil, err
+	}
+	addr := fmt.Sprintf("%v/%v/%v", cfg.Context, ns, kube.DefaultService)
+	return addr, kc.ServiceDialer(ns, kube.DefaultService), nil
+}
+
+// loadKubeconfig loads the context of the kubeconfig by the flags
+func loadKubeconfig(c *cli.Context) (*kube.Config, error) {
+	files := kube.DefaultKubeconfig()
+	if c.String(FlagKubeconfig) != "" {
+		files = []string{c.String(FlagKubeconfig)}
+	}
+	return kube.LoadConfig(files, c.String(FlagKubeContext))
+}
+
+func playback(c *cli.Context, sc *client.Client) error {
+	now := time.Now()
+	from, err := client.ParseTime(c.String(FlagFrom), now)
+	if err != nil {
+		return err
+	}
+	to, err := client.ParseTime(c.String(FlagTo), now)
+	if err != nil {
+		return err
+	}
+	return sc.Playback(from, to, c.Duration(FlagStep), c.Float64(FlagReplaySpeed))
+}
+
+func setDisplayOptions(c *cli.Context, sc *client.Client) error {
+	sc.ShowDevices = c.Bool(FlagShowDevices)
+	sc.ShowAsTop = c.Bool(FlagTop)
+	sc.SortBy = c.String(FlagSortBy)
+	sc.Limit = c.Int(FlagLimit)
+	sc.HideIdle = c.Bool(FlagHideIdle)
+	sc.TopDevices = c.Int(FlagTopDevices)
+	sc.DetailInstance = c.String(FlagInstance)
+	sc.ShowDelta = c.Bool(FlagDelta)
+	sc.Smooth = c.Int(FlagSmooth)
+	sc.Heatmap = c.String(FlagHeatmap)
+	sc.Groups = groups(c)
+	sc.SmoothFunc = c.String(FlagSmoothFunc)
+	if err := client.ValidateSmoothFunc(sc.SmoothFunc); err != nil {
+		return err
+	}
+	f, err := filter.NewFilter(c.String(FlagWhere), c.String(FlagInstanceRegex), c.String(FlagDeviceRegex), c.String(FlagLabelSelector))
+	if err != nil {
+		return err
+	}
+	sc.Filter = f
+	switch c.String(FlagSortOrder) {
+	case client.SortOrderAscending:
+		sc.SortDescending = false
+	case client.SortOrderDescending:
+		sc.SortDescending = true
+	default:
+		return fmt.Errorf("invalid sort order %v", c.String(FlagSortOrder))
+	}
+	return nil
+}
+
+// groups returns the groups from --groups followed by the ones selected by
+// the short flags, in the order of the default layout
+func groups(c *cli.Context) []string {
+	result := []string{}
+	selected := map[string]bool{}
+	add := func(g string) {
+		if g != "" && !selected[g] {
+			result = append(result, g)
+			selected[g] = true
+		}
+	}
+	if c.String(FlagGroups) != "" {
+		for _, g := range strings.Split(c.String(FlagGroups), ",") {
+			add(strings.TrimSpace(g))
+		}
+	}
+	for _, flag := range []string{FlagCPU, FlagMem, FlagDisk, FlagNet} {
+		if c.Bool(flag) {
+			add(flag)
+		}
+	}
+	return result
+}
+
+func replay(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("require exactly one record file")
+	}
+
+	client := client.NewClient("", "", "", "")
+	if err := setDisplayOptions(c, client); err != nil {
+		return err
+	}
+	if err := client.Replay(c.Args().First(), c.Float64(FlagReplaySpeed)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func graph(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("require exactly one metric")
+	}
+	if c.Duration(FlagSince) <= 0 {
+		return fmt.Errorf("invalid time window %v", c.Duration(FlagSince))
+	}
+
+	serverAddr := c.String(FlagServer)
+	var dialer client.Dialer
+	if serverAddr == "" {
+		var err error
+		if serverAddr, dialer, err = kubeServer(c); err != nil {
+			return err
+		}
+	}
+
+	client := client.NewClient(serverAddr, c.String(FlagMetricFormatFile), "", "")
+	client.Dialer = dialer
+	client.Profile = c.String(FlagProfile)
+	client.ShowDevices = c.Bool(FlagShowDevices)
+	f, err := filter.NewFilter("", c.String(FlagInstance), c.String(FlagDeviceRegex), "")
+	if err != nil {
+		return err
+	}
+	client.Filter = f
+	return client.Graph(c.Args().First(), c.Duration(FlagSince))
+}
+
+// manifestValues returns the default values of the manifests, overridden by
+// the values file, --set and the flags in the order
+func manifestValues(c *cli.Context) (*manifest.Values, error) {
+	values := manifest.DefaultValues()
+	if c.String(FlagValuesFile) != "" {
+		if err := values.LoadValuesFile(c.String(FlagValuesFile)); err != nil {
+			return nil, err
+		}
+	}
+	for _, set := range c.StringSlice(FlagSet) {
+		if err := values.Set(set); err != nil {
+			return nil, err
+		}
+	}
+	if c.IsSet(FlagNamespace) {
+		values.Namespace = c.String(FlagNamespace)
+	}
+	if c.String(FlagImage) != "" {
+		values.Image = c.String(FlagImage)
+	}
+	return values, nil
+}
+
+func printManifest(c *cli.Context) error {
+	values, err := manifestValues(c)
+	if err != nil {
+		return err
+	}
+	manifests, err := manifest.Render(values)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(manifests)
+	return err
+}
+
+// configSearchPath returns the search path of the configuration files for the
+// usage
+func configSearchPath() string {
+	return strings.Join(config.SearchPath(), ", ")
+}
+
+func printConfig(c *cli.Context) error {
+	if c.NArg() == 0 {
+		for _, name := range config.BuiltinFiles() {
+			_, sources, err := config.Load(name, "")
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%v: %v\n", name, strings.Join(sources, ", "))
+		}
+		return nil
+	}
+	data, sources, err := config.Load(c.Args().First(), "")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "# from %v\n", strings.Join(sources, ", "))
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// kubeObjects returns the client of the cluster, the values and the objects
+// rendered with the values
+func kubeObjects(c *cli.Context) (*kube.Client, *manifest.Values, []kube.Object, error) {
+	values, err := manifestValues(c)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	manifests, err := manifest.Render(values)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	objects, err := manifest.Objects(manifests)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cfg, err := loadKubeconfig(c)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return kube.NewClient(cfg), values, objects, nil
+}
+
+// install applies the manifests after showing the changes, upgrade requires
+// kstat to be installed and install requires it not to be
+func install(c *cli.Context, upgrade bool) error {
+	kc, values, objects, err := kubeObjects(c)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	installed, err := manifest.Installed(ctx, kc, values)
+	if err != nil {
+		return err
+	}
+	if installed && !upgrade {
+		return fmt.Errorf("kstat is already installed in namespace %v, use upgrade instead", values.Namespace)
+	}
+	if !installed && upgrade {
+		return fmt.Errorf("kstat is not installed in namespace %v, use install instead", values.Namespace)
+	}
+
+	changes, err := manifest.Plan(ctx, kc, objects)
+	if err != nil {
+		return err
+	}
+	manifest.PrintChanges(os.Stdout, changes)
+	if c.Bool(FlagDryRun) {
+		return nil
+	}
+	return manifest.Execute(ctx, kc, changes, os.Stdout)
+}
+
+// uninstall deletes the objects of the manifests if kstat is installed, the
+// namespace is kept unless created by kstat or --delete-namespace
+func uninstall(c *cli.Context) error {
+	kc, values, objects, err := kubeObjects(c)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	installed, err := manifest.Installed(ctx, kc, values)
+	if err != nil {
+		return err
+	}
+	if !installed {
+		return fmt.Errorf("kstat is not installed in namespace %v", values.Namespace)
+	}
+	changes, err := manifest.PlanUninstall(ctx, kc, objects, c.Bool(FlagDeleteNamespace))
+	if err != nil {
+		return err
+	}
+	manifest.PrintChanges(os.Stdout, changes)
+	if c.Bool(FlagDryRun) {
+		return nil
+	}
+	return manifest.Execute(ctx, kc, changes, os.Stdout)
+}
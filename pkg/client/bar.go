@@ -0,0 +1,169 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	aurora "github.com/logrusorgru/aurora/v3"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+const (
+	// BarWidth is the width of the bar including the brackets
+	BarWidth     = 20
+	BarKeySuffix = "_bar"
+	BarFill      = "|"
+)
+
+// barColors are the colors of the segments of the stacked bar, e.g. usr,
+// sys, wait and steal in the htop style
+var barColors = []func(interface{}) aurora.Value{
+	aurora.Green,
+	aurora.Red,
+	func(arg interface{}) aurora.Value { return aurora.Gray(12, arg) },
+	aurora.Cyan,
+	aurora.Blue,
+	aurora.Magenta,
+	aurora.Yellow,
+}
+
+func validateBar(cfg *MetricFormat) error {
+	if !cfg.Bar && cfg.Stack == "" {
+		return nil
+	}
+	if cfg.ValueType != types.ValueTypeCPU && cfg.ValueType != types.ValueTypePercent {
+		return fmt.Errorf("bar is only for the value type %v or %v", types.ValueTypeCPU, types.ValueTypePercent)
+	}
+	return nil
+}
+
+func barKey(name string) string {
+	return name + BarKeySuffix
+}
+
+// barMetrics returns the metrics of the bars by the template key, the
+// segments of a stacked bar are in the order of the metrics format config
+func barMetrics(metricFormatMap map[string]*MetricFormat) map[string][]*MetricFormat {
+	bars := map[string][]*MetricFormat{}
+	for _, cfg := range metricFormatMap {
+		if cfg.Bar {
+			bars[barKey(cfg.Name)] = []*MetricFormat{cfg}
+		}
+		if cfg.Stack != "" {
+			key := barKey(cfg.Stack)
+			bars[key] = append(bars[key], cfg)
+		}
+	}
+	for _, cfgs := range bars {
+		sort.Slice(cfgs, func(i, j int) bool { return cfgs[i].order < cfgs[j].order })
+	}
+	return bars
+}
+
+// barHeader returns the header of the bar in the width of the bar, the
+// stacked bar is named by the stack
+func (c *Client) barHeader(key string, cfgs []*MetricFormat) string {
+	label := strings.TrimSuffix(key, BarKeySuffix)
+	if len(cfgs) == 1 && key == barKey(cfgs[0].Name) {
+		label = cfgs[0].Shorthand
+		if cfgs[0].Name == c.SortBy {
+			label = sortHeader(label, c.sortIndicator(), BarWidth)
+		}
+	}
+	return fmt.Sprintf("%*s", BarWidth, label)
+}
+
+// addBars puts the bars of the instance, and the devices if showing the
+// devices, into the data for the output template
+func (c *Client) addBars(metrics map[string]*types.ClusterMetric, bars map[string][]*MetricFormat,
+	inst string, devices []string, mc map[string]map[string]string) {
+	for key, cfgs := range bars {
+		mc[MetricsOutputSummaryKey][key] = c.instanceBar(metrics, cfgs, inst, "")
+		if !c.ShowDevices {
+			continue
+		}
+		for _, dev := range devices {
+			if mc[dev] != nil {
+				mc[dev][key] = c.instanceBar(metrics, cfgs, inst, dev)
+			}
+		}
+	}
+}
+
+// instanceBar returns the bar of the metrics for the instance, or the device
+// if not empty
+func (c *Client) instanceBar(metrics map[string]*types.ClusterMetric, cfgs []*MetricFormat, inst, dev string) string {
+	values := []int64{}
+	found := false
+	for _, cfg := range cfgs {
+		value := int64(0)
+		if cm := metrics[cfg.Name]; cm != nil && cm.InstanceMetrics[inst] != nil {
+			im := cm.InstanceMetrics[inst]
+			if dev == "" {
+				value = c.columnValue(cfg, inst, dev, im.Summary(cfg.ValueType))
+				found = true
+			} else if v, exists := im.DeviceMetrics[dev]; exists {
+				value = c.columnValue(cfg, inst, dev, v)
+				found = true
+			}
+		}
+		values = append(values, value)
+	}
+	if !found {
+		if dev == "" {
+			return colorNA(fmt.Sprintf("%%%ds", BarWidth))
+		}
+		return strings.Repeat(" ", BarWidth)
+	}
+	return formatBar(values)
+}
+
+// formatBar returns the percentages as the colored segments of the bar, with
+// the total at the end, e.g. "[|||||     45%]"
+func formatBar(values []int64) string {
+	cells := BarWidth - 2
+	total := int64(0)
+	// colors has the index of the segment for each cell, or -1 if empty
+	colors := make([]int, cells)
+	filled := 0
+	for i, v := range values {
+		if v > 0 {
+			total += v
+		}
+		end := int((total*int64(cells) + 50) / 100)
+		if end > cells {
+			end = cells
+		}
+		for ; filled < end; filled++ {
+			colors[filled] = i
+		}
+	}
+	for i := filled; i < cells; i++ {
+		colors[i] = -1
+	}
+
+	text := fmt.Sprintf("%d%%", total)
+	if len(text) > cells {
+		text = ""
+	}
+	output := &strings.Builder{}
+	output.WriteString("[")
+	for i := 0; i < cells-len(text); {
+		// color the cells of the same segment together
+		j := i
+		for j < cells-len(text) && colors[j] == colors[i] {
+			j++
+		}
+		if colors[i] < 0 {
+			output.WriteString(strings.Repeat(" ", j-i))
+		} else {
+			output.WriteString(barColors[colors[i]%len(barColors)](strings.Repeat(BarFill, j-i)).String())
+		}
+		i = j
+	}
+	output.WriteString(aurora.Gray(15, text).String())
+	output.WriteString("]")
+	return output.String()
+}
@@ -3,17 +3,22 @@ package client
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"text/template"
 	"time"
 
+	aurora "github.com/logrusorgru/aurora/v3"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/net/context"
-	"google.golang.org/grpc"
+	"golang.org/x/crypto/ssh/terminal"
 	"gopkg.in/yaml.v2"
 
+	"github.com/yasker/kstat/pkg/config"
+	"github.com/yasker/kstat/pkg/filter"
 	pb "github.com/yasker/kstat/pkg/pb/v1"
+	"github.com/yasker/kstat/pkg/record"
 	"github.com/yasker/kstat/pkg/types"
 )
 
@@ -25,20 +30,96 @@ type MetricFormat struct {
 	Name      string `yaml:"name"`
 	ValueType string `yaml:"value_type"`
 	Shorthand string `yaml:"shorthand"`
+
+	Idle *IdleLevel `yaml:"idle"`
+	// Delta shows the change of the column instead of the value, either
+	// "diff" or "rate"
+	Delta string `yaml:"delta"`
+	// Windows are shown as the sub columns of the metric, which must match
+	// the windows of the metric on the server
+	Windows []string `yaml:"windows"`
+	// Thresholds are the ascending values between the colors of the
+	// heatmap, e.g. [25, 50, 75] or [1M, 10M, 100M]
+	Thresholds []string `yaml:"thresholds"`
+	// Bar renders the cpu or percent metric as a bar as well, which is
+	// .<name>_bar in the templates
+	Bar bool `yaml:"bar"`
+	// Stack puts the cpu or percent metric as a segment into the stacked
+	// bar of the name, which is .<stack>_bar in the templates. The segments
+	// are in the order of the metrics in the config.
+	Stack string `yaml:"stack"`
+	// Group is the group of the columns the metric belongs to, e.g. cpu,
+	// mem, disk, net or a custom one, to select by --groups
+	Group string `yaml:"group"`
+
+	thresholds []int64
+	// order is the position of the metric in the config
+	order int
 }
 
 type Client struct {
-	ServerAddress      string
+	ServerAddress string
+	Dialer        Dialer
+	// Profile is the named set of the metrics to show, with the metrics
+	// format and the templates of its own
+	Profile            string
 	MetricFormatFile   string
 	HeaderTemplateFile string
 	OutputTemplateFile string
 	ShowDevices        bool
 	ShowAsTop          bool
+	SortBy             string
+	SortDescending     bool
+	Limit              int
+	Filter             *filter.Filter
+	HideIdle           bool
+	TopDevices         int
+	DetailInstance     string
+	Heatmap            string
+	Groups             []string
+	Clusters           []*Cluster
+	ShowDelta          bool
+	Smooth             int
+	SmoothFunc         string
+	RecordFile         string
+	CSVFile            string
+	CSVRotateSize      int64
+	CSVRotateInterval  time.Duration
 
 	rwMutex         *sync.RWMutex
 	metricFormatMap map[string]*MetricFormat
 	headerTemplate  *template.Template
 	outputTemplate  *template.Template
+
+	// the raw configuration, kept for the session recording
+	metricFormatData   string
+	headerTemplateData string
+	outputTemplateData string
+
+	recorder *record.Writer
+	// recordedHeader is the configuration of the snapshots being recorded
+	recordedHeader *pb.RecordHeader
+	csvLogger      *csvLogger
+
+	conn *serverConn
+	// source describes where the snapshots come from
+	source string
+	// live is true if the snapshots are the latest metrics from the server
+	live bool
+	// lastResp is the last good snapshot, which is kept on display when
+	// the server is unreachable
+	lastResp     *pb.GetMetricsResponse
+	reconnecting bool
+	// prevMetrics is the snapshot before lastResp, for the delta mode
+	prevMetrics   map[string]*types.ClusterMetric
+	prevTimestamp int64
+	// refresh triggers an immediate poll, e.g. when the filter changed
+	refresh chan struct{}
+	history *history
+	// smoothPaused shows the raw values while --smooth is set
+	smoothPaused bool
+	// clusterErrors are the unreachable clusters of the last snapshot
+	clusterErrors map[string]error
 }
 
 func NewClient(serverAddr, metricFormatFile, headerTmplFile, outputTmplFile string) *Client {
@@ -49,90 +130,428 @@ func NewClient(serverAddr, metricFormatFile, headerTmplFile, outputTmplFile stri
 		OutputTemplateFile: outputTmplFile,
 
 		rwMutex: &sync.RWMutex{},
+		refresh: make(chan struct{}, 1),
+		history: newHistory(DefaultHistoryLength),
 	}
 }
 
+// metricsUpdate is a snapshot from the server or the recording, or the error
+// if failed to get one
+type metricsUpdate struct {
+	resp *pb.GetMetricsResponse
+	err  error
+	// clusterErrors are the clusters failed to get the metrics from, when
+	// merging the metrics of multiple clusters
+	clusterErrors map[string]error
+	// unchanged is true if the servers haven't updated the metrics since
+	// the last snapshot, e.g. polled again for the refresh after the filter
+	// changed
+	unchanged bool
+}
+
 func (c *Client) Start() error {
+	// fail early on the invalid configuration, e.g. an unknown group
+	if err := c.reloadTemplateFiles(); err != nil {
+		return err
+	}
+	ConfigCheckedAt = time.Now()
+
+	c.live = true
+	if len(c.Clusters) != 0 {
+		return c.run("clusters "+strings.Join(c.clusterNames(), ","), c.pollClusters)
+	}
+	return c.run("server "+c.ServerAddress, c.pollMetrics)
+}
+
+// run connects to the server, and displays the metrics from the producer
+func (c *Client) run(source string, produce func(updates chan<- *metricsUpdate)) error {
+	if len(c.Clusters) != 0 {
+		defer c.closeClusters()
+		if err := c.connectClusters(); err != nil {
+			return err
+		}
+	} else {
+		conn, err := newServerConn(parseServerAddresses(c.ServerAddress), c.Dialer, c.Profile)
+		if err != nil {
+			return err
+		}
+		c.conn = conn
+		defer c.conn.Close()
+	}
+
+	if c.RecordFile != "" {
+		if err := c.startRecording(); err != nil {
+			return err
+		}
+		defer c.recorder.Close()
+	}
+
+	if c.CSVFile != "" {
+		l, err := newCSVLogger(c.CSVFile, c.CSVRotateSize, c.CSVRotateInterval)
+		if err != nil {
+			return err
+		}
+		c.csvLogger = l
+		defer c.csvLogger.Close()
+	}
+
+	c.source = source
+	updates := make(chan *metricsUpdate)
+	go produce(updates)
+	return c.display(updates)
+}
+
+// checkConfig reloads the configuration files if not checked for a while
+func (c *Client) checkConfig() {
+	if time.Now().After(ConfigCheckedAt.Add(types.ConfigCheckInterval)) {
+		if err := c.reloadTemplateFiles(); err != nil {
+			logrus.Errorf("failed to reload the configuration files: %v", err)
+		}
+		ConfigCheckedAt = time.Now()
+	}
+}
+
+func (c *Client) pollMetrics(updates chan<- *metricsUpdate) {
+	var lastTimestamp int64
+	for {
+		c.checkConfig()
+
+		c.rwMutex.RLock()
+		f, withLabels := c.serverFilter(), c.needLabels()
+		c.rwMutex.RUnlock()
+
+		resp, err := c.conn.GetMetrics(f, withLabels)
+		u := &metricsUpdate{resp: resp, err: err}
+		if err == nil {
+			u.unchanged = resp.Timestamp == lastTimestamp
+			lastTimestamp = resp.Timestamp
+		}
+		updates <- u
+
+		select {
+		case <-time.After(types.PollInterval):
+		case <-c.refresh:
+		}
+	}
+}
+
+// setFilter replaces the filter and refreshes the metrics from the server
+func (c *Client) setFilter(f *filter.Filter) {
+	c.rwMutex.Lock()
+	c.Filter = f
+	c.rwMutex.Unlock()
+
+	c.refreshMetrics()
+}
+
+// setDetailInstance opens the detail view of the instance, or closes it if
+// empty, and refreshes the metrics for it from the server
+func (c *Client) setDetailInstance(inst string) {
+	c.rwMutex.Lock()
+	c.DetailInstance = inst
+	c.rwMutex.Unlock()
+
+	c.refreshMetrics()
+}
+
+// refreshMetrics triggers an immediate poll instead of waiting for the
+// interval
+func (c *Client) refreshMetrics() {
+	select {
+	case c.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// serverFilter returns the filter to send to the server, which only selects
+// the instance of the detail view if there is one, caller must hold the lock
+func (c *Client) serverFilter() *filter.Filter {
+	f := c.Filter
+	if len(c.Clusters) != 0 {
+		// the servers only know the instance names without the cluster
+		f = f.WithoutInstanceRegex()
+	} else if c.DetailInstance != "" {
+		f = f.WithInstance(c.DetailInstance)
+	}
+	if c.smoothing() {
+		// the client evaluates the where expression by the smoothed
+		// values, which needs the history of all the instances
+		f = f.WithoutWhere()
+	}
+	return f
+}
+
+// needLabels returns true if the labels of the instances and devices are
+// needed, which are only sent by the server if asked. The label selector is
+// applied again on the client, and the detail view and the replay of the
+// recording show them. Caller must hold the lock.
+func (c *Client) needLabels() bool {
+	return c.DetailInstance != "" || c.RecordFile != "" || (c.Filter != nil && c.Filter.LabelSelector != "")
+}
+
+// valueTypes returns the value types of the metrics, caller must hold the lock
+func (c *Client) valueTypes() map[string]string {
+	valueTypes := map[string]string{}
+	for name, cfg := range c.metricFormatMap {
+		valueTypes[name] = cfg.ValueType
+	}
+	return valueTypes
+}
+
+// metricNames returns the names of the metrics and the windows of them, in
+// the name order
+func metricNames(metricFormatMap map[string]*MetricFormat) []string {
+	names := []string{}
+	for name, cfg := range metricFormatMap {
+		names = append(names, name)
+		for _, w := range windowsExceptFirst(cfg) {
+			names = append(names, types.WindowMetricName(name, w))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// display renders the updates until there is no more update, or the user
+// quits from the top mode
+func (c *Client) display(updates <-chan *metricsUpdate) error {
+	c.history.resize(c.Smooth)
+
+	if c.ShowAsTop && terminal.IsTerminal(int(os.Stdin.Fd())) && terminal.IsTerminal(int(os.Stdout.Fd())) {
+		return c.runTop(updates)
+	}
+
 	lineCounter := new(int)
 	*lineCounter = 0
 
-	for {
-		if time.Now().After(ConfigCheckedAt.Add(types.ConfigCheckInterval)) {
-			if err := c.reloadTemplateFiles(); err != nil {
-				logrus.Errorf("failed to reload the configuration files: %v", err)
-			}
-			ConfigCheckedAt = time.Now()
+	for u := range updates {
+		if err := c.update(u); err != nil {
+			return err
+		}
+		if u.err != nil {
+			c.printReconnecting(lineCounter)
+			continue
 		}
+		c.reconnecting = false
 
-		metrics, err := c.GetMetrics()
-		if err != nil {
-			logrus.Errorf("Failed to get metrics from server %v", err)
-		} else {
-			if !c.ShowAsTop {
-				c.printMetrics(metrics, lineCounter)
-			} else {
-				fmt.Print("\033[H\033[2J")
-				c.printTop(metrics)
-			}
+		if c.ShowAsTop {
+			fmt.Print("\033[H\033[2J")
+			*lineCounter = 0
+		}
+		if c.DetailInstance != "" {
+			c.printDetail(PBToMetrics(u.resp), time.Unix(0, u.resp.Timestamp))
+			continue
 		}
+		if c.Heatmap != "" {
+			c.printHeatmap(PBToMetrics(u.resp), time.Unix(0, u.resp.Timestamp))
+			continue
+		}
+		c.printMetrics(PBToMetrics(u.resp), lineCounter)
+	}
+	return nil
+}
 
-		time.Sleep(types.PollInterval)
+// update records the new snapshot, and logs it to CSV if required
+func (c *Client) update(u *metricsUpdate) error {
+	if u.err != nil {
+		if isRejected(u.err) {
+			return u.err
+		}
+		logrus.Debugf("Failed to get metrics from server: %v", u.err)
+		return nil
+	}
+	if u.unchanged && c.lastResp != nil {
+		// not a new sample for the delta mode, the history or the logs,
+		// but the filter may have changed
+		c.lastResp = u.resp
+		c.clusterErrors = u.clusterErrors
+		return nil
+	}
+	if c.lastResp != nil {
+		c.prevMetrics = PBToMetrics(c.lastResp)
+		c.prevTimestamp = c.lastResp.Timestamp
+	}
+	c.lastResp = u.resp
+	c.clusterErrors = u.clusterErrors
+
+	c.rwMutex.RLock()
+	c.history.add(PBToMetrics(u.resp), c.valueTypes())
+	c.rwMutex.RUnlock()
+
+	if c.recorder != nil {
+		if err := c.recordHeaderIfChanged(); err != nil {
+			return errors.Wrapf(err, "failed to write to the record file %v", c.RecordFile)
+		}
+		if err := c.recorder.Write(u.resp); err != nil {
+			return errors.Wrapf(err, "failed to write to the record file %v", c.RecordFile)
+		}
+	}
+	if c.csvLogger != nil {
+		c.rwMutex.RLock()
+		err := c.csvLogger.Write(time.Unix(0, u.resp.Timestamp), PBToMetrics(u.resp), c.metricFormatMap)
+		c.rwMutex.RUnlock()
+		if err != nil {
+			logrus.Errorf("Failed to log metrics to CSV: %v", err)
+		}
 	}
 	return nil
 }
 
-func (c *Client) reloadTemplateFiles() error {
-	var err error
+func (c *Client) reconnectingStatus() string {
+	target := c.ServerAddress
+	if len(c.Clusters) != 0 {
+		target = strings.Join(c.clusterNames(), ",")
+	}
+	status := fmt.Sprintf("reconnecting to %v...", target)
+	if c.lastResp != nil {
+		status += fmt.Sprintf(" (last update %v ago)", c.staleness())
+	}
+	return status
+}
 
-	f, err := os.Open(c.MetricFormatFile)
-	if err != nil {
-		return errors.Wrapf(err, "cannot open the metrics format config file %v", c.MetricFormatFile)
+func (c *Client) staleness() time.Duration {
+	if c.lastResp == nil {
+		return 0
 	}
-	defer f.Close()
+	return time.Since(time.Unix(0, c.lastResp.Timestamp)).Truncate(time.Second)
+}
 
-	cfgs := []*MetricFormat{}
+// printReconnecting shows a status line instead of the error while the
+// connection is down, and keeps the last good snapshot on screen in the top
+// mode
+func (c *Client) printReconnecting(lineCounter *int) {
+	if c.ShowAsTop {
+		fmt.Print("\033[H\033[2J")
+		*lineCounter = 0
+		if c.lastResp != nil {
+			c.printMetrics(PBToMetrics(c.lastResp), lineCounter)
+		}
+		fmt.Println(aurora.BrightRed(c.reconnectingStatus()))
+	} else if !c.reconnecting {
+		// only report once for the dstat style to avoid scrolling the
+		// table away
+		fmt.Println(aurora.BrightRed(c.reconnectingStatus()))
+		*lineCounter++
+	}
+	c.reconnecting = true
+}
 
-	if err := yaml.NewDecoder(f).Decode(&cfgs); err != nil {
-		return errors.Wrapf(err, "cannot decode the metrics format config file %v", c.MetricFormatFile)
+func (c *Client) reloadTemplateFiles() error {
+	metricFormat, _, err := config.Load(config.ProfileFile(c.Profile, config.MetricsFormatFile), c.MetricFormatFile)
+	if err != nil {
+		return err
+	}
+	headerTmpl, _, err := config.Load(config.ProfileFile(c.Profile, config.HeaderTemplateFile), c.HeaderTemplateFile)
+	if err != nil {
+		return err
+	}
+	outputTmpl, _, err := config.Load(config.ProfileFile(c.Profile, config.OutputTemplateFile), c.OutputTemplateFile)
+	if err != nil {
+		return err
 	}
+	return c.loadTemplates(string(metricFormat), string(headerTmpl), string(outputTmpl))
+}
 
+func (c *Client) loadTemplates(metricFormat, headerTmpl, outputTmpl string) error {
 	c.rwMutex.Lock()
 	defer c.rwMutex.Unlock()
 
-	c.metricFormatMap = map[string]*MetricFormat{}
-	for _, m := range cfgs {
-		c.metricFormatMap[m.Name] = m
-	}
+	return c.applyTemplates(metricFormat, headerTmpl, outputTmpl, c.Groups)
+}
 
-	c.headerTemplate, err = template.ParseFiles(c.HeaderTemplateFile)
+// applyTemplates replaces the metrics format and the templates, with the
+// layout generated for the groups if any. Nothing is changed if any of them
+// is invalid. Caller must hold the lock.
+func (c *Client) applyTemplates(metricFormat, headerTmpl, outputTmpl string, groups []string) error {
+	metricFormatMap, err := parseMetricFormat(metricFormat)
 	if err != nil {
-		return errors.Wrapf(err, "cannot read or parse the header template file %v", c.HeaderTemplateFile)
+		return err
 	}
 
-	c.outputTemplate, err = template.ParseFiles(c.OutputTemplateFile)
-	if err != nil {
-		return errors.Wrapf(err, "cannot read or parse the output template file %v", c.OutputTemplateFile)
+	// the raw templates are kept for the recording even if the layout is
+	// generated for the groups
+	headerLayout, outputLayout := headerTmpl, outputTmpl
+	if len(groups) != 0 {
+		if err := validateGroups(metricFormatMap, groups); err != nil {
+			return err
+		}
+		headerLayout, outputLayout = groupTemplates(metricFormatMap, groups)
+	}
+
+	if err := validateSortBy(metricFormatMap, c.SortBy); err != nil {
+		return err
+	}
+	if err := c.Filter.Validate(metricNames(metricFormatMap)); err != nil {
+		return err
 	}
-	return nil
-}
 
-func (c *Client) GetMetrics() (map[string]*types.ClusterMetric, error) {
-	conn, err := grpc.Dial(c.ServerAddress, grpc.WithInsecure())
+	headerTemplate, err := template.New("header").Parse(headerLayout)
+	if err != nil {
+		return errors.Wrap(err, "cannot parse the header template")
+	}
+	outputTemplate, err := template.New("output").Parse(outputLayout)
 	if err != nil {
-		return nil, errors.Wrapf(err, "cannot connect to metric server %v", c.ServerAddress)
+		return errors.Wrap(err, "cannot parse the output template")
 	}
-	defer conn.Close()
-	metricsServiceClient := pb.NewMetricsServiceClient(conn)
 
-	ctx, cancel := context.WithTimeout(context.Background(), types.GRPCServiceTimeout)
-	defer cancel()
+	c.metricFormatMap = metricFormatMap
+	c.headerTemplate = headerTemplate
+	c.outputTemplate = outputTemplate
+	c.Groups = groups
 
-	resp, err := metricsServiceClient.GetMetrics(ctx, &pb.GetMetricsRequest{})
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to get metrics from %v", c.ServerAddress)
+	c.metricFormatData = metricFormat
+	c.headerTemplateData = headerTmpl
+	c.outputTemplateData = outputTmpl
+	return nil
+}
+
+// parseMetricFormat returns the metric format config by the metric name
+func parseMetricFormat(metricFormat string) (map[string]*MetricFormat, error) {
+	cfgs := []*MetricFormat{}
+
+	if err := yaml.Unmarshal([]byte(metricFormat), &cfgs); err != nil {
+		return nil, errors.Wrap(err, "cannot decode the metrics format config")
 	}
 
-	return PBToMetrics(resp), nil
+	metricFormatMap := map[string]*MetricFormat{}
+	for i, m := range cfgs {
+		// the values are formatted by the value type when rendered, where
+		// the errors cannot be reported
+		if !types.IsValueType(m.ValueType) {
+			return nil, fmt.Errorf("invalid metrics format config for %v: unknown value type %q", m.Name, m.ValueType)
+		}
+		if err := validateDeltaMode(m.Delta); err != nil {
+			return nil, errors.Wrapf(err, "invalid metrics format config for %v", m.Name)
+		}
+		if err := validateBar(m); err != nil {
+			return nil, errors.Wrapf(err, "invalid metrics format config for %v", m.Name)
+		}
+		m.order = i
+		thresholds, err := parseThresholds(m.Thresholds)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid metrics format config for %v", m.Name)
+		}
+		m.thresholds = thresholds
+		metricFormatMap[m.Name] = m
+		// the other windows are the metrics on their own, and can be used
+		// in the templates as e.g. .cpu_user_1m
+		for _, w := range windowsExceptFirst(m) {
+			wm := *m
+			wm.Name = types.WindowMetricName(m.Name, w)
+			wm.Shorthand = w
+			wm.Windows = nil
+			wm.Bar = false
+			wm.Stack = ""
+			wm.Group = ""
+			metricFormatMap[wm.Name] = &wm
+		}
+	}
+	for _, m := range cfgs {
+		if m.Stack != "" && metricFormatMap[m.Stack] != nil {
+			return nil, fmt.Errorf("invalid metrics format config for %v: stack %v is a metric", m.Name, m.Stack)
+		}
+	}
+	return metricFormatMap, nil
 }
 
 func PBToMetrics(resp *pb.GetMetricsResponse) map[string]*types.ClusterMetric {
@@ -151,6 +570,13 @@ func PBToMetrics(resp *pb.GetMetricsResponse) map[string]*types.ClusterMetric {
 			for kd, kv := range vi.DeviceMetrics {
 				im.DeviceMetrics[kd] = kv
 			}
+			im.Labels = vi.Labels
+			if vi.DeviceLabels != nil {
+				im.DeviceLabels = map[string]map[string]string{}
+				for kd, labels := range vi.DeviceLabels {
+					im.DeviceLabels[kd] = labels.Labels
+				}
+			}
 			cm.InstanceMetrics[ki] = im
 		}
 		result[k] = cm
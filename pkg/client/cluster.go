@@ -0,0 +1,287 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	aurora "github.com/logrusorgru/aurora/v3"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	pb "github.com/yasker/kstat/pkg/pb/v1"
+	"github.com/yasker/kstat/pkg/types"
+)
+
+const (
+	// ClusterSeparator joins the cluster and the instance into the instance
+	// name of the merged metrics, e.g. "prod-a/node-1". Kubernetes doesn't
+	// allow it in the node names.
+	ClusterSeparator = "/"
+	// ClusterTotalInstance is the name of the summary row of the cluster
+	ClusterTotalInstance = "(total)"
+	ClusterColumnHeader  = "cluster"
+)
+
+// Cluster is a kstat server to merge the metrics from, the server can be a
+// comma separated list of servers to fail over between
+type Cluster struct {
+	Name   string `yaml:"name"`
+	Server string `yaml:"server"`
+
+	conn *serverConn
+}
+
+// ClientConfig has the named contexts of the clusters
+type ClientConfig struct {
+	Contexts []*Cluster `yaml:"contexts"`
+}
+
+// ParseCluster parses the cluster in the form of name=server
+func ParseCluster(value string) (*Cluster, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid cluster %v, must be name=server, e.g. prod-a=host1:9159", value)
+	}
+	if strings.Contains(parts[0], ClusterSeparator) {
+		return nil, fmt.Errorf("invalid cluster name %v, cannot contain %v", parts[0], ClusterSeparator)
+	}
+	return &Cluster{Name: parts[0], Server: parts[1]}, nil
+}
+
+// LoadContexts returns the clusters of the named contexts in the client
+// config file, or all of them if no name is given
+func LoadContexts(file string, names []string) ([]*Cluster, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read the client config file %v", file)
+	}
+	cfg := &ClientConfig{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, errors.Wrapf(err, "cannot decode the client config file %v", file)
+	}
+	contexts := map[string]*Cluster{}
+	for _, cl := range cfg.Contexts {
+		if cl.Name == "" || cl.Server == "" || strings.Contains(cl.Name, ClusterSeparator) {
+			return nil, fmt.Errorf("invalid context %+v in the client config file %v", *cl, file)
+		}
+		contexts[cl.Name] = cl
+	}
+	if len(names) == 0 {
+		return cfg.Contexts, nil
+	}
+	clusters := []*Cluster{}
+	for _, name := range names {
+		cl := contexts[name]
+		if cl == nil {
+			return nil, fmt.Errorf("unknown context %v in the client config file %v", name, file)
+		}
+		clusters = append(clusters, cl)
+	}
+	return clusters, nil
+}
+
+func (c *Client) clusterNames() []string {
+	names := []string{}
+	for _, cl := range c.Clusters {
+		names = append(names, cl.Name)
+	}
+	return names
+}
+
+func (c *Client) connectClusters() error {
+	seen := map[string]bool{}
+	for _, cl := range c.Clusters {
+		if seen[cl.Name] {
+			return fmt.Errorf("duplicate cluster %v", cl.Name)
+		}
+		seen[cl.Name] = true
+		conn, err := newServerConn(parseServerAddresses(cl.Server), nil, c.Profile)
+		if err != nil {
+			return errors.Wrapf(err, "cannot connect to cluster %v", cl.Name)
+		}
+		cl.conn = conn
+	}
+	return nil
+}
+
+func (c *Client) closeClusters() {
+	for _, cl := range c.Clusters {
+		if cl.conn != nil {
+			cl.conn.Close()
+		}
+	}
+}
+
+// pollClusters gets the metrics from all the clusters at once, and merges
+// them into one snapshot. The unreachable clusters are reported in the
+// update, and only fail the update if all the clusters are unreachable.
+func (c *Client) pollClusters(updates chan<- *metricsUpdate) {
+	lastTimestamps := make([]int64, len(c.Clusters))
+	for {
+		c.checkConfig()
+
+		c.rwMutex.RLock()
+		f, withLabels := c.serverFilter(), c.needLabels()
+		c.rwMutex.RUnlock()
+
+		resps := make([]*pb.GetMetricsResponse, len(c.Clusters))
+		errs := make([]error, len(c.Clusters))
+		wg := sync.WaitGroup{}
+		for i, cl := range c.Clusters {
+			wg.Add(1)
+			go func(i int, cl *Cluster) {
+				defer wg.Done()
+				resps[i], errs[i] = cl.conn.GetMetrics(f, withLabels)
+			}(i, cl)
+		}
+		wg.Wait()
+		u := c.mergeClusters(resps, errs)
+		u.unchanged = true
+		for i, resp := range resps {
+			if errs[i] != nil {
+				continue
+			}
+			if resp.Timestamp != lastTimestamps[i] {
+				u.unchanged = false
+			}
+			lastTimestamps[i] = resp.Timestamp
+		}
+		updates <- u
+
+		select {
+		case <-time.After(types.PollInterval):
+		case <-c.refresh:
+		}
+	}
+}
+
+// mergeClusters puts the instances of every cluster into one snapshot, with
+// the cluster in the instance names
+func (c *Client) mergeClusters(resps []*pb.GetMetricsResponse, errs []error) *metricsUpdate {
+	merged := &pb.GetMetricsResponse{
+		ClusterMetrics: map[string]*pb.ClusterMetric{},
+	}
+	clusterErrors := map[string]error{}
+	for i, cl := range c.Clusters {
+		if errs[i] != nil {
+			clusterErrors[cl.Name] = errs[i]
+			continue
+		}
+		if resps[i].Timestamp > merged.Timestamp {
+			merged.Timestamp = resps[i].Timestamp
+		}
+		for name, cm := range resps[i].ClusterMetrics {
+			if merged.ClusterMetrics[name] == nil {
+				merged.ClusterMetrics[name] = &pb.ClusterMetric{
+					InstanceMetrics: map[string]*pb.InstanceMetric{},
+				}
+			}
+			for inst, im := range cm.InstanceMetrics {
+				merged.ClusterMetrics[name].InstanceMetrics[cl.Name+ClusterSeparator+inst] = im
+			}
+		}
+	}
+	if len(clusterErrors) == len(c.Clusters) {
+		return &metricsUpdate{err: errs[0]}
+	}
+	return &metricsUpdate{resp: merged, clusterErrors: clusterErrors}
+}
+
+// splitCluster returns the cluster and the instance name of the merged
+// instance, or empty cluster if the metrics are from a single server
+func splitCluster(inst string) (string, string) {
+	parts := strings.SplitN(inst, ClusterSeparator, 2)
+	if len(parts) != 2 {
+		return "", inst
+	}
+	return parts[0], parts[1]
+}
+
+// isClusterTotal returns if the instance is the summary of a cluster rather
+// than an instance to drill down into
+func isClusterTotal(inst string) bool {
+	cluster, name := splitCluster(inst)
+	return cluster != "" && name == ClusterTotalInstance
+}
+
+// clusterList returns the clusters of the instances and the unreachable
+// clusters, in the order of the clusters if connected to them, e.g. not in a
+// replay
+func (c *Client) clusterList(instances []string) []string {
+	found := map[string]bool{}
+	for _, inst := range instances {
+		if cluster, _ := splitCluster(inst); cluster != "" {
+			found[cluster] = true
+		}
+	}
+	for cluster := range c.clusterErrors {
+		found[cluster] = true
+	}
+
+	clusters := []string{}
+	for _, name := range c.clusterNames() {
+		if found[name] {
+			clusters = append(clusters, name)
+			delete(found, name)
+		}
+	}
+	rest := []string{}
+	for name := range found {
+		rest = append(rest, name)
+	}
+	sort.Strings(rest)
+	return append(clusters, rest...)
+}
+
+// clusterTotals returns the metrics of every cluster summarized as one
+// instance, e.g. "prod-a/(total)". The values of the cpu and percent metrics
+// are the averages of the instances, and the others are the totals.
+func clusterTotals(metrics map[string]*types.ClusterMetric, valueTypes map[string]string) map[string]*types.ClusterMetric {
+	totals := map[string]*types.ClusterMetric{}
+	for name, cm := range metrics {
+		counts := map[string]int64{}
+		result := &types.ClusterMetric{InstanceMetrics: map[string]*types.InstanceMetric{}}
+		for inst, im := range cm.InstanceMetrics {
+			cluster, _ := splitCluster(inst)
+			if cluster == "" {
+				continue
+			}
+			key := cluster + ClusterSeparator + ClusterTotalInstance
+			total := result.InstanceMetrics[key]
+			if total == nil {
+				total = &types.InstanceMetric{DeviceMetrics: map[string]int64{}}
+				result.InstanceMetrics[key] = total
+			}
+			total.Total += im.Summary(valueTypes[name])
+			counts[key]++
+		}
+		for key, total := range result.InstanceMetrics {
+			total.Average = total.Total / counts[key]
+			total.Value = total.Summary(valueTypes[name])
+		}
+		totals[name] = result
+	}
+	return totals
+}
+
+// clusterColumn puts the cluster column in front of the lines of the table,
+// the cluster is only shown on the first line of the instance
+func clusterColumn(rows []tableRow, cluster string, width int) []tableRow {
+	for i := range rows {
+		label := ""
+		if i == 0 {
+			label = cluster
+		}
+		rows[i].line = fmt.Sprintf("%-*s ", width, label) + rows[i].line
+	}
+	return rows
+}
+
+// clusterErrorRow marks the unreachable cluster
+func clusterErrorRow(cluster string, err error, width int) tableRow {
+	return tableRow{line: fmt.Sprintf("%-*s %v", width, cluster, aurora.BrightRed(fmt.Sprintf("unreachable: %v", err)))}
+}
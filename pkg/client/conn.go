@@ -0,0 +1,163 @@
+package client
+
+import (
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
+
+	"github.com/yasker/kstat/pkg/filter"
+	pb "github.com/yasker/kstat/pkg/pb/v1"
+	"github.com/yasker/kstat/pkg/types"
+)
+
+const (
+	ServerResolverScheme = "kstat"
+)
+
+// Dialer connects to the server address, e.g. through the port forward of
+// Kubernetes instead of the network
+type Dialer func(ctx context.Context, addr string) (net.Conn, error)
+
+// serverConn is a long-lived connection to the kstat server. The connection
+// reconnects with exponential backoff, and fails over between the server
+// addresses in order if there are multiple of them.
+type serverConn struct {
+	addresses []string
+	// profile is the profile of the metrics to get
+	profile string
+	conn    *grpc.ClientConn
+	client  pb.MetricsServiceClient
+}
+
+// parseServerAddresses splits the comma separated list of server addresses
+func parseServerAddresses(serverAddr string) []string {
+	addresses := []string{}
+	for _, addr := range strings.Split(serverAddr, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr != "" {
+			addresses = append(addresses, addr)
+		}
+	}
+	return addresses
+}
+
+// newServerConn connects to the servers for the metrics of the profile, with
+// the dialer if not nil
+func newServerConn(addresses []string, dialer Dialer, profile string) (*serverConn, error) {
+	if len(addresses) == 0 {
+		return nil, errors.New("no kstat server address specified")
+	}
+
+	r := manual.NewBuilderWithScheme(ServerResolverScheme)
+	state := resolver.State{}
+	for _, addr := range addresses {
+		state.Addresses = append(state.Addresses, resolver.Address{Addr: addr})
+	}
+	r.InitialState(state)
+
+	// the default pick_first balancer tries the addresses in order, so the
+	// later addresses serve as the failover
+	opts := []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithResolvers(r),
+		grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff: backoff.Config{
+				BaseDelay:  time.Second,
+				Multiplier: 1.6,
+				Jitter:     0.2,
+				MaxDelay:   types.GRPCReconnectMaxDelay,
+			},
+			MinConnectTimeout: types.GRPCServiceTimeout,
+		}),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                types.GRPCKeepaliveTime,
+			Timeout:             types.GRPCKeepaliveTimeout,
+			PermitWithoutStream: true,
+		}),
+	}
+	if dialer != nil {
+		opts = append(opts, grpc.WithContextDialer(dialer))
+	}
+	conn, err := grpc.Dial(r.Scheme()+":///"+strings.Join(addresses, ","), opts...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot connect to metric server %v", addresses)
+	}
+	return &serverConn{
+		addresses: addresses,
+		profile:   profile,
+		conn:      conn,
+		client:    pb.NewMetricsServiceClient(conn),
+	}, nil
+}
+
+// GetMetrics returns the current metrics, with the labels if withLabels
+func (sc *serverConn) GetMetrics(f *filter.Filter, withLabels bool) (*pb.GetMetricsResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), types.GRPCServiceTimeout)
+	defer cancel()
+
+	resp, err := sc.client.GetMetrics(ctx, &pb.GetMetricsRequest{
+		Filter:     f.ToPB(),
+		Profile:    sc.profile,
+		WithLabels: withLabels,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get metrics from %v", sc.addresses)
+	}
+	return resp, nil
+}
+
+// Watch calls the callback with every update of the metrics from the server,
+// until the stream is broken or the context is done
+func (sc *serverConn) Watch(ctx context.Context, f *filter.Filter, withLabels bool, callback func(*pb.GetMetricsResponse)) error {
+	stream, err := sc.client.Watch(ctx, &pb.WatchRequest{
+		Filter:     f.ToPB(),
+		Profile:    sc.profile,
+		WithLabels: withLabels,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to watch metrics from %v", sc.addresses)
+	}
+	for {
+		resp, err := stream.Recv()
+		if err != nil {
+			return errors.Wrapf(err, "failed to watch metrics from %v", sc.addresses)
+		}
+		callback(resp.Metrics)
+	}
+}
+
+// QueryRange returns the stream of the snapshots of the past window
+func (sc *serverConn) QueryRange(ctx context.Context, from, to time.Time, step time.Duration, f *filter.Filter, withLabels bool) (pb.MetricsService_QueryRangeClient, error) {
+	stream, err := sc.client.QueryRange(ctx, &pb.QueryRangeRequest{
+		Start:      from.UnixNano(),
+		End:        to.UnixNano(),
+		Step:       int64(step),
+		Filter:     f.ToPB(),
+		Profile:    sc.profile,
+		WithLabels: withLabels,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to query the metrics range from %v", sc.addresses)
+	}
+	return stream, nil
+}
+
+// isRejected returns true if the server rejected the request, e.g. for an
+// unknown profile, which retrying won't help
+func isRejected(err error) bool {
+	return status.Code(errors.Cause(err)) == codes.InvalidArgument
+}
+
+func (sc *serverConn) Close() error {
+	return sc.conn.Close()
+}
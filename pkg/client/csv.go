@@ -0,0 +1,207 @@
+package client
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+const (
+	CSVTimeFormat         = time.RFC3339
+	CSVRotateSuffixFormat = "20060102-150405"
+)
+
+// csvLogger appends every sample to a CSV file in raw units, and rotates the
+// file by size or time if configured
+type csvLogger struct {
+	file           string
+	rotateSize     int64
+	rotateInterval time.Duration
+
+	f        *os.File
+	w        *csv.Writer
+	openedAt time.Time
+	columns  []string
+}
+
+func newCSVLogger(file string, rotateSize int64, rotateInterval time.Duration) (*csvLogger, error) {
+	l := &csvLogger{
+		file:           file,
+		rotateSize:     rotateSize,
+		rotateInterval: rotateInterval,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *csvLogger) open() error {
+	f, err := os.OpenFile(l.file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "cannot open the CSV file %v", l.file)
+	}
+	l.f = f
+	l.w = csv.NewWriter(f)
+	l.openedAt = time.Now()
+	// columns will be decided by the first sample written to the file
+	l.columns = nil
+	return nil
+}
+
+func (l *csvLogger) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return errors.Wrapf(err, "cannot close the CSV file %v", l.file)
+	}
+	rotated, err := rotatedName(l.file + "." + time.Now().Format(CSVRotateSuffixFormat))
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(l.file, rotated); err != nil {
+		return errors.Wrapf(err, "cannot rotate the CSV file %v to %v", l.file, rotated)
+	}
+	return l.open()
+}
+
+// rotatedName returns the name not taken yet, with a sequence suffix if
+// rotated more than once in a second, e.g. kstat.csv.20200801-031200-1
+func rotatedName(name string) (string, error) {
+	rotated := name
+	for i := 1; ; i++ {
+		_, err := os.Stat(rotated)
+		if os.IsNotExist(err) {
+			return rotated, nil
+		}
+		if err != nil {
+			return "", errors.Wrapf(err, "cannot check the rotated CSV file %v", rotated)
+		}
+		rotated = fmt.Sprintf("%v-%d", name, i)
+	}
+}
+
+func (l *csvLogger) needRotate() (bool, error) {
+	if l.rotateInterval != 0 && time.Now().After(l.openedAt.Add(l.rotateInterval)) {
+		return true, nil
+	}
+	if l.rotateSize != 0 {
+		info, err := l.f.Stat()
+		if err != nil {
+			return false, errors.Wrapf(err, "cannot stat the CSV file %v", l.file)
+		}
+		if info.Size() >= l.rotateSize {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (l *csvLogger) writeHeader(formats map[string]*MetricFormat) error {
+	info, err := l.f.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "cannot stat the CSV file %v", l.file)
+	}
+
+	metricNames := []string{}
+	for name := range formats {
+		metricNames = append(metricNames, name)
+	}
+	sort.Strings(metricNames)
+	l.columns = metricNames
+
+	// don't repeat the header when appending to an existing file
+	if info.Size() != 0 {
+		return nil
+	}
+	return l.w.Write(append([]string{"time", "instance", "device"}, metricNames...))
+}
+
+func (l *csvLogger) Write(ts time.Time, metrics map[string]*types.ClusterMetric, formats map[string]*MetricFormat) error {
+	rotate, err := l.needRotate()
+	if err != nil {
+		return err
+	}
+	if rotate {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	// the columns are fixed once the file has been opened
+	if l.columns == nil {
+		if err := l.writeHeader(formats); err != nil {
+			return err
+		}
+	}
+
+	instanceMap := map[string]map[string]struct{}{}
+	for _, cm := range metrics {
+		for inst, im := range cm.InstanceMetrics {
+			if instanceMap[inst] == nil {
+				instanceMap[inst] = map[string]struct{}{}
+			}
+			for dev := range im.DeviceMetrics {
+				instanceMap[inst][dev] = struct{}{}
+			}
+		}
+	}
+	instanceList := []string{}
+	for inst := range instanceMap {
+		instanceList = append(instanceList, inst)
+	}
+	sort.Strings(instanceList)
+
+	timeString := ts.Format(CSVTimeFormat)
+	for _, inst := range instanceList {
+		record := []string{timeString, inst, ""}
+		for _, name := range l.columns {
+			value := ""
+			if cm := metrics[name]; cm != nil && cm.InstanceMetrics[inst] != nil {
+				valueType := ""
+				if cfg := formats[name]; cfg != nil {
+					valueType = cfg.ValueType
+				}
+				value = fmt.Sprint(cm.InstanceMetrics[inst].Summary(valueType))
+			}
+			record = append(record, value)
+		}
+		if err := l.w.Write(record); err != nil {
+			return errors.Wrapf(err, "cannot write to the CSV file %v", l.file)
+		}
+
+		devList := []string{}
+		for dev := range instanceMap[inst] {
+			devList = append(devList, dev)
+		}
+		sort.Strings(devList)
+		for _, dev := range devList {
+			record := []string{timeString, inst, dev}
+			for _, name := range l.columns {
+				value := ""
+				if cm := metrics[name]; cm != nil && cm.InstanceMetrics[inst] != nil {
+					if v, exists := cm.InstanceMetrics[inst].DeviceMetrics[dev]; exists {
+						value = fmt.Sprint(v)
+					}
+				}
+				record = append(record, value)
+			}
+			if err := l.w.Write(record); err != nil {
+				return errors.Wrapf(err, "cannot write to the CSV file %v", l.file)
+			}
+		}
+	}
+	l.w.Flush()
+	if err := l.w.Error(); err != nil {
+		return errors.Wrapf(err, "cannot write to the CSV file %v", l.file)
+	}
+	return nil
+}
+
+func (l *csvLogger) Close() error {
+	l.w.Flush()
+	return l.f.Close()
+}
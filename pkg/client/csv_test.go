@@ -0,0 +1,47 @@
+package client
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+func TestCSVRotateWithinSecond(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "kstat.csv")
+	// every sample is over the size, so every write rotates the file
+	l, err := newCSVLogger(file, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formats := map[string]*MetricFormat{
+		"cpu_user": {Name: "cpu_user", ValueType: types.ValueTypeCPU},
+	}
+	metrics := map[string]*types.ClusterMetric{
+		"cpu_user": {
+			InstanceMetrics: map[string]*types.InstanceMetric{
+				"node-1": {Value: 10, Total: 10, Average: 10},
+			},
+		},
+	}
+	const writes = 4
+	for i := 0; i < writes; i++ {
+		if err := l.Write(time.Now(), metrics, formats); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := filepath.Glob(file + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first write goes to the new file, and each of the others rotates
+	// it without overwriting the one rotated in the same second
+	if len(rotated) != writes-1 {
+		t.Errorf("got the rotated files %v, want %v of them", rotated, writes-1)
+	}
+}
@@ -0,0 +1,159 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"code.cloudfoundry.org/bytefmt"
+	aurora "github.com/logrusorgru/aurora/v3"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+const (
+	// DeltaModeDiff shows the difference from the previous snapshot
+	DeltaModeDiff = "diff"
+	// DeltaModeRate shows the difference per second from the previous
+	// snapshot
+	DeltaModeRate = "rate"
+
+	DeltaIndicatorUp   = "↑"
+	DeltaIndicatorDown = "↓"
+	DeltaHeaderPrefix  = "Δ"
+)
+
+func validateDeltaMode(mode string) error {
+	switch mode {
+	case "", DeltaModeDiff, DeltaModeRate:
+		return nil
+	}
+	return fmt.Errorf("invalid delta mode %v, must be %v or %v", mode, DeltaModeDiff, DeltaModeRate)
+}
+
+// deltaHeader prefixes the header of the column with the delta mark, and cuts
+// the header to keep it in the width of the column with a space before it
+func deltaHeader(header string, width int) string {
+	runes := []rune(header)
+	if max := width - 1 - utf8.RuneCountInString(DeltaHeaderPrefix); len(runes) > max && max > 0 {
+		runes = runes[:max]
+	}
+	return DeltaHeaderPrefix + string(runes)
+}
+
+// deltaMode returns how the column shows the change, or empty if the column
+// shows the value. The column configuration takes precedence over --delta.
+func (c *Client) deltaMode(cfg *MetricFormat) string {
+	if cfg.Delta != "" {
+		return cfg.Delta
+	}
+	if c.ShowDelta {
+		return DeltaModeDiff
+	}
+	return ""
+}
+
+// formatColumn returns the formatted value of the metric for the instance, or
+// the device if not empty, or the change since the previous snapshot if the
+// column is in the delta mode
+func (c *Client) formatColumn(cfg *MetricFormat, inst, dev string, value int64) string {
+	mode := c.deltaMode(cfg)
+	if mode == "" {
+		return formatValue(cfg, c.columnValue(cfg, inst, dev, value))
+	}
+
+	prev, exists := c.previousValue(cfg, inst, dev)
+	if !exists {
+		return colorNoDelta(cfg)
+	}
+	delta := value - prev
+	if mode == DeltaModeRate {
+		elapsed := time.Duration(c.lastResp.Timestamp - c.prevTimestamp)
+		if elapsed <= 0 {
+			return colorNoDelta(cfg)
+		}
+		delta = int64(float64(delta) / elapsed.Seconds())
+	}
+	return formatDelta(cfg, delta)
+}
+
+// previousValue returns the value of the metric for the instance or the device
+// in the previous snapshot
+func (c *Client) previousValue(cfg *MetricFormat, inst, dev string) (int64, bool) {
+	cm := c.prevMetrics[cfg.Name]
+	if cm == nil || cm.InstanceMetrics[inst] == nil {
+		return 0, false
+	}
+	im := cm.InstanceMetrics[inst]
+	if dev == "" {
+		return im.Summary(cfg.ValueType), true
+	}
+	v, exists := im.DeviceMetrics[dev]
+	return v, exists
+}
+
+// formatDelta returns the colored change with the up or down indicator, in
+// the width of the value type
+func formatDelta(cfg *MetricFormat, delta int64) string {
+	indicator := " "
+	abs := delta
+	if delta > 0 {
+		indicator = DeltaIndicatorUp
+	} else if delta < 0 {
+		indicator = DeltaIndicatorDown
+		abs = -delta
+	}
+
+	// the indicator is put right before the number, the padding is done
+	// separately since the width counts in bytes
+	value := ""
+	width := 0
+	switch cfg.ValueType {
+	case types.ValueTypeCPU:
+		value = indicator + fmt.Sprint(abs)
+		width = len(fmt.Sprintf(types.ValueTypeCPUFormat, ""))
+	case types.ValueTypeSize:
+		value = indicator + bytefmt.ByteSize(uint64(abs))
+		width = len(fmt.Sprintf(types.ValueTypeSizeFormat, ""))
+	case types.ValueTypePercent:
+		value = indicator + fmt.Sprint(abs)
+		width = len(fmt.Sprintf(types.ValueTypePercentFormat, ""))
+	case types.ValueTypeCount:
+		value = indicator + types.FormatCount(abs)
+		width = len(fmt.Sprintf(types.ValueTypeCountFormat, ""))
+	case types.ValueTypeDuration:
+		value = indicator + types.FormatDuration(abs)
+		width = len(fmt.Sprintf(types.ValueTypeDurationFormat, ""))
+	default:
+		return ""
+	}
+	if n := utf8.RuneCountInString(value); n < width {
+		value = strings.Repeat(" ", width-n) + value
+	}
+
+	if delta > 0 {
+		return aurora.BrightYellow(value).String()
+	} else if delta < 0 {
+		return aurora.BrightCyan(value).String()
+	}
+	return aurora.Gray(10, value).String()
+}
+
+// colorNoDelta returns the placeholder when there is no previous value to
+// compare with
+func colorNoDelta(cfg *MetricFormat) string {
+	switch cfg.ValueType {
+	case types.ValueTypeCPU:
+		return aurora.Sprintf(aurora.Gray(10, types.ValueTypeCPUFormat), "-")
+	case types.ValueTypeSize:
+		return aurora.Sprintf(aurora.Gray(10, types.ValueTypeSizeFormat), "-")
+	case types.ValueTypePercent:
+		return aurora.Sprintf(aurora.Gray(10, types.ValueTypePercentFormat), "-")
+	case types.ValueTypeCount:
+		return aurora.Sprintf(aurora.Gray(10, types.ValueTypeCountFormat), "-")
+	case types.ValueTypeDuration:
+		return aurora.Sprintf(aurora.Gray(10, types.ValueTypeDurationFormat), "-")
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"testing"
+
+	pb "github.com/yasker/kstat/pkg/pb/v1"
+)
+
+func TestDeltaHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		width  int
+		want   string
+	}{
+		{"usr", 5, "Δusr"},
+		{"idle", 5, "Δidl"},
+		{"write", 8, "Δwrite"},
+		{"avail", 4, "Δav"},
+	}
+	for _, tt := range tests {
+		if got := deltaHeader(tt.header, tt.width); got != tt.want {
+			t.Errorf("deltaHeader(%q, %v) = %q, want %q", tt.header, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateUnchanged(t *testing.T) {
+	snapshot := func(ts, value int64) *pb.GetMetricsResponse {
+		return &pb.GetMetricsResponse{
+			Timestamp: ts,
+			ClusterMetrics: map[string]*pb.ClusterMetric{
+				"cpu_user": {
+					InstanceMetrics: map[string]*pb.InstanceMetric{
+						"node-1": {Value: value, Total: value, Average: value},
+					},
+				},
+			},
+		}
+	}
+	c := NewClient("", "", "", "")
+	for _, u := range []*metricsUpdate{
+		{resp: snapshot(1, 10)},
+		// polled again before the server updated the metrics
+		{resp: snapshot(1, 10), unchanged: true},
+	} {
+		if err := c.update(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.prevMetrics != nil || c.prevTimestamp != 0 {
+		t.Errorf("the same snapshot is taken as the previous one at %v", c.prevTimestamp)
+	}
+
+	if err := c.update(&metricsUpdate{resp: snapshot(2, 20)}); err != nil {
+		t.Fatal(err)
+	}
+	if c.prevTimestamp != 1 {
+		t.Errorf("the previous snapshot is at %v, want 1", c.prevTimestamp)
+	}
+	if got := c.prevMetrics["cpu_user"].InstanceMetrics["node-1"].Value; got != 10 {
+		t.Errorf("the previous value is %v, want 10", got)
+	}
+}
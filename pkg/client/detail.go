@@ -0,0 +1,137 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+const (
+	DetailRowFormat = "%-20s %-20s %s  %-30s  %s"
+)
+
+// formatDetail returns the header and the rows of the vertical view of the
+// instance, with every metric and device, the recent history and the labels
+func (c *Client) formatDetail(metrics map[string]*types.ClusterMetric, inst string) ([]string, []tableRow) {
+	c.rwMutex.RLock()
+	defer c.rwMutex.RUnlock()
+
+	names := []string{}
+	metricLabels := map[string]map[string]string{}
+	for name, cm := range metrics {
+		if cm.InstanceMetrics[inst] == nil || c.metricFormatMap[name] == nil {
+			continue
+		}
+		names = append(names, name)
+		metricLabels[name] = cm.InstanceMetrics[inst].Labels
+	}
+	if len(names) == 0 {
+		return nil, []tableRow{{instance: inst, line: fmt.Sprintf("No data available for instance %v", inst)}}
+	}
+	sort.Strings(names)
+
+	// the labels shared by all the metrics are shown in the header, and the
+	// rest are shown with each metric
+	common := map[string]string{}
+	for k, v := range metricLabels[names[0]] {
+		common[k] = v
+	}
+	for _, name := range names[1:] {
+		for k, v := range common {
+			if metricLabels[name][k] != v {
+				delete(common, k)
+			}
+		}
+	}
+
+	title := fmt.Sprintf("instance: %v", inst)
+	if c.smoothing() {
+		title += " " + c.smoothNote()
+	}
+	header := []string{
+		title,
+		fmt.Sprintf("labels:   %v", formatLabels(common, nil)),
+		"",
+		fmt.Sprintf(DetailRowFormat, "metric", "device", fmt.Sprintf("%8s", "value"), "history", "labels"),
+	}
+
+	// the devices are sorted by the values as shown
+	shown := c.smoothedMetrics(metrics)
+	rows := []tableRow{}
+	for _, name := range names {
+		cfg := c.metricFormatMap[name]
+		im := metrics[name].InstanceMetrics[inst]
+
+		title := name
+		if c.deltaMode(cfg) != "" {
+			title = DeltaHeaderPrefix + title
+		}
+		line := fmt.Sprintf(DetailRowFormat, title, "",
+			padValue(cfg, c.formatColumn(cfg, inst, "", im.Summary(cfg.ValueType))),
+			sparkline(c.history.get(name, inst, "")),
+			formatLabels(im.Labels, common))
+		rows = append(rows, tableRow{instance: inst, line: line})
+
+		devList := []string{}
+		for dev := range im.DeviceMetrics {
+			devList = append(devList, dev)
+		}
+		c.sortDevices(shown, inst, devList)
+		for _, dev := range devList {
+			line := fmt.Sprintf(DetailRowFormat, "", dev,
+				padValue(cfg, c.formatColumn(cfg, inst, dev, im.DeviceMetrics[dev])),
+				sparkline(c.history.get(name, inst, dev)),
+				formatLabels(im.DeviceLabels[dev], nil))
+			rows = append(rows, tableRow{instance: inst, device: dev, line: line})
+		}
+	}
+	return header, rows
+}
+
+// printDetail prints the detail view of the instance in the dstat style
+func (c *Client) printDetail(metrics map[string]*types.ClusterMetric, ts time.Time) {
+	header, rows := c.formatDetail(metrics, c.DetailInstance)
+
+	output := &strings.Builder{}
+	output.WriteString(fmt.Sprintf("--- %v ---\n", ts.Format("2006-01-02 15:04:05")))
+	for _, line := range header {
+		output.WriteString(line + "\n")
+	}
+	for _, row := range rows {
+		output.WriteString(row.line + "\n")
+	}
+	output.WriteString("\n")
+	fmt.Print(output.String())
+}
+
+// formatLabels formats the labels sorted by name, except the excluded ones
+func formatLabels(labels, exclude map[string]string) string {
+	keys := []string{}
+	for k := range labels {
+		if _, exists := exclude[k]; !exists {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%v=%q", k, labels[k]))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// padValue pads the formatted value to the widest value type, the padding
+// cannot be done by the format width due to the color escape codes
+func padValue(cfg *MetricFormat, value string) string {
+	width := len(fmt.Sprintf(types.ValueTypeSizeFormat, ""))
+	switch cfg.ValueType {
+	case types.ValueTypeCPU:
+		return strings.Repeat(" ", width-len(fmt.Sprintf(types.ValueTypeCPUFormat, ""))) + value
+	case types.ValueTypePercent:
+		return strings.Repeat(" ", width-len(fmt.Sprintf(types.ValueTypePercentFormat, ""))) + value
+	}
+	return value
+}
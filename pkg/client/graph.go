@@ -0,0 +1,424 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/bytefmt"
+	aurora "github.com/logrusorgru/aurora/v3"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/net/context"
+
+	"github.com/yasker/kstat/pkg/config"
+	pb "github.com/yasker/kstat/pkg/pb/v1"
+	"github.com/yasker/kstat/pkg/types"
+)
+
+const (
+	DefaultGraphSince = 15 * time.Minute
+
+	// the size of the graph when not drawing on a terminal
+	DefaultGraphWidth  = 100
+	DefaultGraphHeight = 24
+
+	// GraphAxisWidth is the width of the labels on the value axis
+	GraphAxisWidth = 9
+	// GraphMaxBackfillPoints limits the points of each line filled from
+	// Prometheus
+	GraphMaxBackfillPoints = 500
+
+	brailleBase = 0x2800
+)
+
+var (
+	// brailleDots are the bits of the dots in a braille character, by the
+	// row and the column of the dot
+	brailleDots = [4][2]rune{
+		{0x01, 0x08},
+		{0x02, 0x10},
+		{0x04, 0x20},
+		{0x40, 0x80},
+	}
+
+	graphColors = []aurora.Color{
+		aurora.GreenFg,
+		aurora.YellowFg,
+		aurora.CyanFg,
+		aurora.MagentaFg,
+		aurora.BlueFg,
+		aurora.RedFg,
+		aurora.BrightFg | aurora.GreenFg,
+		aurora.BrightFg | aurora.YellowFg,
+		aurora.BrightFg | aurora.CyanFg,
+		aurora.BrightFg | aurora.MagentaFg,
+		aurora.BrightFg | aurora.BlueFg,
+		aurora.BrightFg | aurora.RedFg,
+	}
+)
+
+type graphPoint struct {
+	// ts is in Unix nanoseconds
+	ts    int64
+	value int64
+}
+
+// graph keeps the recent points of a metric, one line per instance, or per
+// device if showing the devices
+type graph struct {
+	cfg         *MetricFormat
+	since       time.Duration
+	showDevices bool
+
+	lines  map[string][]graphPoint
+	latest int64
+}
+
+func newGraph(cfg *MetricFormat, since time.Duration, showDevices bool) *graph {
+	return &graph{
+		cfg:         cfg,
+		since:       since,
+		showDevices: showDevices,
+		lines:       map[string][]graphPoint{},
+	}
+}
+
+// add appends the snapshot to the lines, and drops the points out of the
+// time window
+func (g *graph) add(resp *pb.GetMetricsResponse) {
+	if resp.Timestamp <= g.latest {
+		return
+	}
+	g.latest = resp.Timestamp
+
+	if cm := PBToMetrics(resp)[g.cfg.Name]; cm != nil {
+		for inst, im := range cm.InstanceMetrics {
+			if g.showDevices && len(im.DeviceMetrics) != 0 {
+				for dev, v := range im.DeviceMetrics {
+					name := inst + " " + dev
+					g.lines[name] = append(g.lines[name], graphPoint{resp.Timestamp, v})
+				}
+				continue
+			}
+			g.lines[inst] = append(g.lines[inst], graphPoint{resp.Timestamp, im.Summary(g.cfg.ValueType)})
+		}
+	}
+
+	start := g.latest - int64(g.since)
+	for name, points := range g.lines {
+		i := sort.Search(len(points), func(i int) bool { return points[i].ts >= start })
+		if i == len(points) {
+			delete(g.lines, name)
+			continue
+		}
+		g.lines[name] = points[i:]
+	}
+}
+
+func (g *graph) names() []string {
+	names := []string{}
+	for name := range g.lines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// formatAxisValue formats the value on the value axis in the unit of the
+// metric
+func (g *graph) formatAxisValue(v int64) string {
+	switch g.cfg.ValueType {
+	case types.ValueTypeCPU, types.ValueTypePercent:
+		return fmt.Sprintf("%d%%", v)
+	case types.ValueTypeSize:
+		if v < 0 {
+			return "-" + bytefmt.ByteSize(uint64(-v))
+		}
+		return bytefmt.ByteSize(uint64(v))
+	case types.ValueTypeCount:
+		return types.FormatCount(v)
+	case types.ValueTypeDuration:
+		return types.FormatDuration(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// draw returns the lines of the chart in the size, with the value axis, the
+// time axis and the legend
+func (g *graph) draw(width, height int) []string {
+	names := g.names()
+	if len(names) == 0 {
+		return []string{fmt.Sprintf("No data available for %v", g.cfg.Name)}
+	}
+
+	// the legend takes one line per line of the chart, and the time axis
+	// takes two lines
+	cols := width - GraphAxisWidth - 2
+	rows := height - len(names) - 2
+	if cols < 10 || rows < 4 {
+		return []string{"The terminal is too small for the graph"}
+	}
+	dotWidth, dotHeight := cols*2, rows*4
+
+	end := g.latest
+	start := end - int64(g.since)
+	min, max := int64(0), int64(0)
+	for _, points := range g.lines {
+		for _, p := range points {
+			if p.value < min {
+				min = p.value
+			}
+			if p.value > max {
+				max = p.value
+			}
+		}
+	}
+	if (g.cfg.ValueType == types.ValueTypeCPU || g.cfg.ValueType == types.ValueTypePercent) && max < 100 {
+		max = 100
+	}
+	if max == min {
+		max = min + 1
+	}
+
+	cells := make([][]rune, rows)
+	colors := make([][]int, rows)
+	for r := range cells {
+		cells[r] = make([]rune, cols)
+		colors[r] = make([]int, cols)
+	}
+	setDot := func(x, y, color int) {
+		if x < 0 || x >= dotWidth || y < 0 || y >= dotHeight {
+			return
+		}
+		cells[y/4][x/2] |= brailleDots[y%4][x%2]
+		colors[y/4][x/2] = color
+	}
+	toDot := func(p graphPoint) (int, int) {
+		x := int((p.ts - start) * int64(dotWidth-1) / (end - start))
+		y := dotHeight - 1 - int((p.value-min)*int64(dotHeight-1)/(max-min))
+		return x, y
+	}
+
+	for i, name := range names {
+		points := g.lines[name]
+		for j, p := range points {
+			x, y := toDot(p)
+			if j == 0 {
+				setDot(x, y, i)
+				continue
+			}
+			px, py := toDot(points[j-1])
+			drawLine(px, py, x, y, func(x, y int) { setDot(x, y, i) })
+		}
+	}
+
+	lines := []string{}
+	for r := 0; r < rows; r++ {
+		label := ""
+		switch r {
+		case 0:
+			label = g.formatAxisValue(max)
+		case rows / 2:
+			label = g.formatAxisValue(min + (max-min)/2)
+		case rows - 1:
+			label = g.formatAxisValue(min)
+		}
+		line := &strings.Builder{}
+		line.WriteString(fmt.Sprintf("%*s ┤", GraphAxisWidth, label))
+		for c, bits := range cells[r] {
+			if bits == 0 {
+				line.WriteString(" ")
+				continue
+			}
+			line.WriteString(aurora.Colorize(string(brailleBase+bits), graphColors[colors[r][c]%len(graphColors)]).String())
+		}
+		lines = append(lines, line.String())
+	}
+
+	lines = append(lines, strings.Repeat(" ", GraphAxisWidth+1)+"└"+strings.Repeat("─", cols))
+	startLabel := time.Unix(0, start).Format("15:04:05")
+	endLabel := time.Unix(0, end).Format("15:04:05")
+	gap := cols - len(startLabel) - len(endLabel)
+	if gap < 1 {
+		gap = 1
+	}
+	lines = append(lines, strings.Repeat(" ", GraphAxisWidth+2)+startLabel+strings.Repeat(" ", gap)+endLabel)
+
+	for i, name := range names {
+		points := g.lines[name]
+		last := points[len(points)-1].value
+		lines = append(lines, fmt.Sprintf("%*s %v %v %v", GraphAxisWidth, "",
+			aurora.Colorize("━━", graphColors[i%len(graphColors)]), name, g.formatAxisValue(last)))
+	}
+	return lines
+}
+
+// drawLine calls the plot for every dot on the line between the two dots
+func drawLine(x0, y0, x1, y1 int, plot func(x, y int)) {
+	dx, dy := abs(x1-x0), -abs(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		plot(x0, y0)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if e2 := 2 * e; e2 >= dy {
+			e += dy
+			x0 += sx
+		} else {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// Graph draws the metric over the recent time window as a chart in the
+// terminal. The chart is filled with the history in Prometheus first, then
+// updated live from the server.
+func (c *Client) Graph(metric string, since time.Duration) error {
+	data, _, err := config.Load(config.ProfileFile(c.Profile, config.MetricsFormatFile), c.MetricFormatFile)
+	if err != nil {
+		return err
+	}
+	metricFormatMap, err := parseMetricFormat(string(data))
+	if err != nil {
+		return err
+	}
+	cfg := metricFormatMap[metric]
+	if cfg == nil {
+		return fmt.Errorf("unknown metric %v", metric)
+	}
+	c.metricFormatMap = metricFormatMap
+
+	conn, err := newServerConn(parseServerAddresses(c.ServerAddress), c.Dialer, c.Profile)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	defer c.conn.Close()
+	c.source = "server " + c.ServerAddress
+	c.live = true
+
+	g := newGraph(cfg, since, c.ShowDevices)
+	c.backfillGraph(g)
+
+	updates := make(chan *metricsUpdate)
+	go c.watchMetrics(updates)
+
+	if terminal.IsTerminal(int(os.Stdin.Fd())) && terminal.IsTerminal(int(os.Stdout.Fd())) {
+		return c.runGraph(g, updates)
+	}
+	for u := range updates {
+		if isRejected(u.err) {
+			return u.err
+		}
+		if u.err != nil {
+			logrus.Debugf("Failed to watch metrics from server: %v", u.err)
+			continue
+		}
+		g.add(u.resp)
+		fmt.Println(strings.Join(g.draw(DefaultGraphWidth, DefaultGraphHeight), "\n"))
+		fmt.Println()
+	}
+	return nil
+}
+
+// backfillGraph fills the graph with the history of the time window from
+// Prometheus, the graph starts empty if the history is not available
+func (c *Client) backfillGraph(g *graph) {
+	ctx, cancel := context.WithTimeout(context.Background(), types.GRPCServiceTimeout)
+	defer cancel()
+
+	to := time.Now()
+	step := g.since / GraphMaxBackfillPoints
+	if step < types.PollInterval {
+		step = types.PollInterval
+	}
+	stream, err := c.conn.QueryRange(ctx, to.Add(-g.since), to, step, c.Filter, false)
+	if err != nil {
+		logrus.Warnf("Failed to fill the graph with the history: %v", err)
+		return
+	}
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			logrus.Warnf("Failed to fill the graph with the history: %v", err)
+			return
+		}
+		g.add(resp.Metrics)
+	}
+}
+
+// watchMetrics streams the metrics from the server, and watches again after
+// the poll interval if the stream is broken
+func (c *Client) watchMetrics(updates chan<- *metricsUpdate) {
+	for {
+		err := c.conn.Watch(context.Background(), c.Filter, false, func(resp *pb.GetMetricsResponse) {
+			updates <- &metricsUpdate{resp: resp}
+		})
+		updates <- &metricsUpdate{err: err}
+		time.Sleep(types.PollInterval)
+	}
+}
+
+func (c *Client) runGraph(g *graph, updates <-chan *metricsUpdate) error {
+	screen, err := newTopScreen()
+	if err != nil {
+		return err
+	}
+	defer screen.Close()
+	screen.hideCursor = true
+
+	for {
+		rows := []tableRow{}
+		for _, line := range g.draw(screen.width, screen.bodyHeight(0)) {
+			rows = append(rows, tableRow{line: line})
+		}
+		status := fmt.Sprintf(" kstat graph | %v | %v | last %v", g.cfg.Name, c.source, g.since)
+		if c.reconnecting {
+			status += " | " + c.reconnectingStatus()
+		}
+		screen.draw(nil, rows, status, "q:quit")
+
+		select {
+		case u := <-updates:
+			if isRejected(u.err) {
+				return u.err
+			}
+			c.reconnecting = u.err != nil
+			if u.err == nil {
+				c.lastResp = u.resp
+				g.add(u.resp)
+			}
+		case key := <-screen.keys:
+			if key == "q" || key == KeyCtrlC {
+				return nil
+			}
+		case <-screen.resize:
+			screen.updateSize()
+		case <-screen.signals:
+			return nil
+		}
+	}
+}
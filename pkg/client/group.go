@@ -0,0 +1,202 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	GroupCPU  = "cpu"
+	GroupMem  = "mem"
+	GroupDisk = "disk"
+	GroupNet  = "net"
+
+	GroupSeparator = " | "
+)
+
+var (
+	// BuiltinGroups are in the order of the default layout, the custom
+	// groups follow in the order of the metrics format config
+	BuiltinGroups = []string{GroupCPU, GroupMem, GroupDisk, GroupNet}
+)
+
+// groupList returns all the groups in the metrics format config
+func groupList(metricFormatMap map[string]*MetricFormat) []string {
+	first := map[string]int{}
+	for _, cfg := range metricFormatMap {
+		if cfg.Group == "" {
+			continue
+		}
+		if order, exists := first[cfg.Group]; !exists || cfg.order < order {
+			first[cfg.Group] = cfg.order
+		}
+	}
+
+	groups := []string{}
+	for _, g := range BuiltinGroups {
+		if _, exists := first[g]; exists {
+			groups = append(groups, g)
+			delete(first, g)
+		}
+	}
+	custom := []string{}
+	for g := range first {
+		custom = append(custom, g)
+	}
+	sort.Slice(custom, func(i, j int) bool { return first[custom[i]] < first[custom[j]] })
+	return append(groups, custom...)
+}
+
+// groupMetrics returns the metrics of the group in the order of the metrics
+// format config
+func groupMetrics(metricFormatMap map[string]*MetricFormat, group string) []*MetricFormat {
+	cfgs := []*MetricFormat{}
+	for _, cfg := range metricFormatMap {
+		if cfg.Group == group {
+			cfgs = append(cfgs, cfg)
+		}
+	}
+	sort.Slice(cfgs, func(i, j int) bool { return cfgs[i].order < cfgs[j].order })
+	return cfgs
+}
+
+func validateGroups(metricFormatMap map[string]*MetricFormat, groups []string) error {
+	available := groupList(metricFormatMap)
+	for _, g := range groups {
+		found := false
+		for _, a := range available {
+			if g == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown group %v, must be one of %v", g, strings.Join(available, ","))
+		}
+	}
+	return nil
+}
+
+// columnWidth returns the width of the value of the metric, including the
+// sub columns of the windows
+func columnWidth(cfg *MetricFormat) int {
+	width := len(blankValue(cfg))
+	if len(cfg.Windows) > 1 {
+		width = width*len(cfg.Windows) + len(WindowSeparator)*(len(cfg.Windows)-1)
+	}
+	return width
+}
+
+// groupTitle returns the name of the group in the middle of the dashes in the
+// width, e.g. "---cpu---"
+func groupTitle(group string, width int) string {
+	if len(group) >= width {
+		return group[:width]
+	}
+	left := (width - len(group)) / 2
+	return strings.Repeat("-", left) + group + strings.Repeat("-", width-len(group)-left)
+}
+
+// groupTemplates generates the header and the output templates showing the
+// metrics of the groups in the order
+func groupTemplates(metricFormatMap map[string]*MetricFormat, groups []string) (string, string) {
+	titles := []string{}
+	headerFormats := []string{}
+	outputFormats := []string{}
+	keys := []string{}
+	for _, g := range groups {
+		width := 0
+		headerFormat := ""
+		outputFormat := ""
+		for _, cfg := range groupMetrics(metricFormatMap, g) {
+			// the values are padded already, but the header labels
+			// are not
+			headerFormat += fmt.Sprintf("%%%ds", columnWidth(cfg))
+			outputFormat += "%s"
+			keys = append(keys, fmt.Sprintf("(index . %q)", cfg.Name))
+			width += columnWidth(cfg)
+		}
+		titles = append(titles, fmt.Sprintf("%q", groupTitle(g, width)))
+		headerFormats = append(headerFormats, headerFormat)
+		outputFormats = append(outputFormats, outputFormat)
+	}
+
+	titleFormat := "%20s : " + strings.Repeat("%s"+GroupSeparator, len(titles))
+	titleFormat = strings.TrimSuffix(titleFormat, GroupSeparator)
+	headerFormat := "%20s : " + strings.Join(headerFormats, GroupSeparator)
+	outputFormat := "%20s : " + strings.Join(outputFormats, GroupSeparator)
+
+	header := fmt.Sprintf("{{printf %q \"\" %v}}\n{{printf %q .instance %v}}\n",
+		titleFormat, strings.Join(titles, " "), headerFormat, strings.Join(keys, " "))
+	output := fmt.Sprintf("{{printf %q .instance %v}}\n", outputFormat, strings.Join(keys, " "))
+	return header, output
+}
+
+// setGroups shows the metrics of the groups with the generated layout, or
+// the layout of the templates if no group is selected
+func (c *Client) setGroups(groups []string) error {
+	c.rwMutex.Lock()
+	defer c.rwMutex.Unlock()
+
+	return c.applyTemplates(c.metricFormatData, c.headerTemplateData, c.outputTemplateData, groups)
+}
+
+// toggleGroup shows or hides the Nth group, starting from all the groups if
+// none is selected. The last group shown cannot be hidden.
+func (c *Client) toggleGroup(index int) error {
+	c.rwMutex.RLock()
+	all := groupList(c.metricFormatMap)
+	current := c.Groups
+	c.rwMutex.RUnlock()
+	if index >= len(all) {
+		return nil
+	}
+
+	shown := map[string]bool{}
+	for _, g := range current {
+		shown[g] = true
+	}
+	if len(current) == 0 {
+		for _, g := range all {
+			shown[g] = true
+		}
+	}
+	shown[all[index]] = !shown[all[index]]
+
+	groups := []string{}
+	for _, g := range all {
+		if shown[g] {
+			groups = append(groups, g)
+		}
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+	return c.setGroups(groups)
+}
+
+// groupsStatus shows the groups with the keys to toggle them, the hidden
+// groups are in the parentheses, e.g. "1:cpu 2:(mem) 3:disk"
+func (c *Client) groupsStatus() string {
+	c.rwMutex.RLock()
+	all := groupList(c.metricFormatMap)
+	current := c.Groups
+	c.rwMutex.RUnlock()
+
+	shown := map[string]bool{}
+	for _, g := range current {
+		shown[g] = true
+	}
+	labels := []string{}
+	for i, g := range all {
+		if i >= 9 {
+			break
+		}
+		if len(current) != 0 && !shown[g] {
+			g = "(" + g + ")"
+		}
+		labels = append(labels, fmt.Sprintf("%d:%v", i+1, g))
+	}
+	return strings.Join(labels, " ")
+}
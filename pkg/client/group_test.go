@@ -0,0 +1,58 @@
+package client
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+const testMetricFormat = `
+- name: cpu_user
+  value_type: cpu
+  shorthand: usr
+  group: cpu
+- name: mem_avail
+  value_type: size
+  shorthand: avail
+  group: mem
+`
+
+func TestSetGroups(t *testing.T) {
+	c := NewClient("", "", "", "")
+	if err := c.loadTemplates(testMetricFormat, "header\n", "output\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.setGroups([]string{"cpu"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.setGroups([]string{"disk"}); err == nil {
+		t.Errorf("set the unknown group")
+	}
+	if !reflect.DeepEqual(c.Groups, []string{"cpu"}) {
+		t.Errorf("got the groups %v after the invalid one, want [cpu]", c.Groups)
+	}
+
+	// the configuration is reloaded by the poller while the groups are
+	// toggled by the keys, run with -race to check
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if err := c.loadTemplates(testMetricFormat, "header\n", "output\n"); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if err := c.toggleGroup(1); err != nil {
+			t.Fatal(err)
+		}
+		c.groupsStatus()
+	}
+	wg.Wait()
+	if !reflect.DeepEqual(c.Groups, []string{"cpu"}) {
+		t.Errorf("got the groups %v after toggling mem even times, want [cpu]", c.Groups)
+	}
+}
@@ -0,0 +1,217 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/bytefmt"
+	aurora "github.com/logrusorgru/aurora/v3"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+const (
+	HeatmapCell      = "█"
+	HeatmapEmptyCell = " "
+	HeatmapRowFormat = "%20s : %s  %s"
+
+	// heatmapLevels is the number of the levels by the maximum value, when
+	// the metric has no thresholds
+	heatmapLevels = 4
+)
+
+// heatmapColors are from the coolest to the hottest, the levels are spread
+// over the colors if there are less levels
+var heatmapColors = []func(interface{}) aurora.Value{
+	func(arg interface{}) aurora.Value { return aurora.Gray(10, arg) },
+	aurora.Green,
+	aurora.Yellow,
+	aurora.Red,
+	aurora.BrightRed,
+}
+
+// parseThresholds parses the thresholds, which are numbers or sizes with
+// units, e.g. 10M
+func parseThresholds(values []string) ([]int64, error) {
+	thresholds := []int64{}
+	for _, v := range values {
+		t, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			size, sizeErr := bytefmt.ToBytes(v)
+			if sizeErr != nil {
+				return nil, fmt.Errorf("invalid threshold %v", v)
+			}
+			t = int64(size)
+		}
+		if len(thresholds) != 0 && t <= thresholds[len(thresholds)-1] {
+			return nil, fmt.Errorf("thresholds must be in the ascending order")
+		}
+		thresholds = append(thresholds, t)
+	}
+	return thresholds, nil
+}
+
+// heatmapThresholds returns the thresholds of the metric, or the thresholds
+// evenly spread up to the maximum value if the metric has no thresholds. The
+// spread thresholds are at least 1 apart, so the small values aren't all at
+// the hottest level, e.g. the zeros are at the coolest level.
+func heatmapThresholds(cfg *MetricFormat, max int64) []int64 {
+	if len(cfg.thresholds) != 0 {
+		return cfg.thresholds
+	}
+	thresholds := []int64{}
+	prev := int64(0)
+	for i := 1; i <= heatmapLevels; i++ {
+		t := max * int64(i) / (heatmapLevels + 1)
+		if t <= prev {
+			t = prev + 1
+		}
+		thresholds = append(thresholds, t)
+		prev = t
+	}
+	return thresholds
+}
+
+// heatmapColor returns the color of the level of the value by the thresholds
+func heatmapColor(thresholds []int64, value int64) func(interface{}) aurora.Value {
+	level := sort.Search(len(thresholds), func(i int) bool { return value < thresholds[i] })
+	return heatmapColors[level*(len(heatmapColors)-1)/len(thresholds)]
+}
+
+// compareDevices orders the devices by the name, and the number in the name
+// by the value, e.g. "cpu: 2" before "cpu: 10"
+func compareDevices(a, b string) bool {
+	aPrefix, aNum := splitDeviceNumber(a)
+	bPrefix, bNum := splitDeviceNumber(b)
+	if aPrefix != bPrefix || aNum < 0 || bNum < 0 {
+		return a < b
+	}
+	return aNum < bNum
+}
+
+func splitDeviceNumber(dev string) (string, int) {
+	i := len(dev)
+	for i > 0 && dev[i-1] >= '0' && dev[i-1] <= '9' {
+		i--
+	}
+	num, err := strconv.Atoi(dev[i:])
+	if err != nil {
+		return dev, -1
+	}
+	return dev[:i], num
+}
+
+// formatHeatmap returns the header and the rows of the heatmap of the metric,
+// with a row per instance and a cell per device
+func (c *Client) formatHeatmap(metrics map[string]*types.ClusterMetric, metric string) ([]string, []tableRow) {
+	c.rwMutex.RLock()
+	defer c.rwMutex.RUnlock()
+
+	cfg := c.metricFormatMap[metric]
+	if cfg == nil {
+		return nil, []tableRow{{line: fmt.Sprintf("Unknown metric %v", metric)}}
+	}
+
+	metrics = c.Filter.Apply(metrics, c.valueTypes())
+	cm := metrics[metric]
+	if cm == nil || len(cm.InstanceMetrics) == 0 {
+		return nil, []tableRow{{line: "No data available"}}
+	}
+
+	instanceList := []string{}
+	max := int64(0)
+	cellCount := 1
+	for inst, im := range cm.InstanceMetrics {
+		if c.HideIdle && c.instanceActivity(metrics, inst) == 0 {
+			continue
+		}
+		instanceList = append(instanceList, inst)
+		if len(im.DeviceMetrics) > cellCount {
+			cellCount = len(im.DeviceMetrics)
+		}
+		for _, v := range im.DeviceMetrics {
+			if v > max {
+				max = v
+			}
+		}
+		if len(im.DeviceMetrics) == 0 && im.Value > max {
+			max = im.Value
+		}
+	}
+	if len(instanceList) == 0 {
+		return nil, []tableRow{{line: "All instances are idle"}}
+	}
+	c.sortInstances(metrics, instanceList)
+	if c.Limit > 0 && len(instanceList) > c.Limit {
+		instanceList = instanceList[:c.Limit]
+	}
+
+	thresholds := heatmapThresholds(cfg, max)
+	legend := []string{}
+	for _, t := range thresholds {
+		legend = append(legend, heatmapColor(thresholds, t-1)(HeatmapCell).String()+" <"+rawValue(cfg, t))
+	}
+	last := thresholds[len(thresholds)-1]
+	legend = append(legend, heatmapColor(thresholds, last)(HeatmapCell).String()+" >="+rawValue(cfg, last))
+	header := []string{
+		fmt.Sprintf("heatmap of %v: %v", metric, strings.Join(legend, "  ")),
+		fmt.Sprintf(HeatmapRowFormat, "instance", fmt.Sprintf("%-*s", cellCount, "devices"), ""),
+	}
+
+	rows := []tableRow{}
+	for _, inst := range instanceList {
+		im := cm.InstanceMetrics[inst]
+		cells := &strings.Builder{}
+		// keep the summary values aligned
+		padding := cellCount - len(im.DeviceMetrics)
+		if len(im.DeviceMetrics) == 0 {
+			cells.WriteString(heatmapColor(thresholds, im.Value)(HeatmapCell).String())
+			padding = cellCount - 1
+		} else {
+			devList := []string{}
+			for dev := range im.DeviceMetrics {
+				devList = append(devList, dev)
+			}
+			sort.Slice(devList, func(i, j int) bool { return compareDevices(devList[i], devList[j]) })
+			for _, dev := range devList {
+				cells.WriteString(heatmapColor(thresholds, im.DeviceMetrics[dev])(HeatmapCell).String())
+			}
+		}
+		cells.WriteString(strings.Repeat(HeatmapEmptyCell, padding))
+		line := fmt.Sprintf(HeatmapRowFormat, inst, cells.String(), formatValue(cfg, im.Summary(cfg.ValueType)))
+		rows = append(rows, tableRow{instance: inst, line: line})
+	}
+	return header, rows
+}
+
+// rawValue formats the value in the unit of the value type without the color
+func rawValue(cfg *MetricFormat, value int64) string {
+	switch cfg.ValueType {
+	case types.ValueTypeSize:
+		return bytefmt.ByteSize(uint64(value))
+	case types.ValueTypeCount:
+		return types.FormatCount(value)
+	case types.ValueTypeDuration:
+		return types.FormatDuration(value)
+	}
+	return strconv.FormatInt(value, 10)
+}
+
+// printHeatmap prints the heatmap in the dstat style
+func (c *Client) printHeatmap(metrics map[string]*types.ClusterMetric, ts time.Time) {
+	header, rows := c.formatHeatmap(metrics, c.Heatmap)
+
+	output := &strings.Builder{}
+	output.WriteString(fmt.Sprintf("--- %v ---\n", ts.Format("2006-01-02 15:04:05")))
+	for _, line := range header {
+		output.WriteString(line + "\n")
+	}
+	for _, row := range rows {
+		output.WriteString(row.line + "\n")
+	}
+	output.WriteString("\n")
+	fmt.Print(output.String())
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHeatmapThresholds(t *testing.T) {
+	tests := []struct {
+		name       string
+		thresholds []int64
+		max        int64
+		want       []int64
+	}{
+		{"configured", []int64{25, 50, 75}, 1000, []int64{25, 50, 75}},
+		{"spread", nil, 100, []int64{20, 40, 60, 80}},
+		{"small", nil, 3, []int64{1, 2, 3, 4}},
+		{"zero", nil, 0, []int64{1, 2, 3, 4}},
+		{"negative", nil, -5, []int64{1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := heatmapThresholds(&MetricFormat{thresholds: tt.thresholds}, tt.max)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeatmapColor(t *testing.T) {
+	thresholds := heatmapThresholds(&MetricFormat{}, 0)
+	if got, want := heatmapColor(thresholds, 0)(HeatmapCell), heatmapColors[0](HeatmapCell); got != want {
+		t.Errorf("zero is colored %v, want the coolest %v", got, want)
+	}
+	thresholds = heatmapThresholds(&MetricFormat{}, 100)
+	tests := []struct {
+		value int64
+		level int
+	}{
+		{0, 0},
+		{19, 0},
+		{20, 1},
+		{79, 3},
+		{100, 4},
+	}
+	for _, tt := range tests {
+		if got, want := heatmapColor(thresholds, tt.value)(HeatmapCell), heatmapColors[tt.level](HeatmapCell); got != want {
+			t.Errorf("%v is colored %v, want %v", tt.value, got, want)
+		}
+	}
+}
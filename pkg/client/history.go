@@ -0,0 +1,92 @@
+package client
+
+import (
+	"github.com/yasker/kstat/pkg/types"
+)
+
+const (
+	DefaultHistoryLength = 30
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+type historyKey struct {
+	metric   string
+	instance string
+	// device is empty for the summarized value of the instance
+	device string
+}
+
+// history keeps the recent values of every metric of the instances and
+// devices
+type history struct {
+	length int
+	values map[historyKey][]int64
+}
+
+func newHistory(length int) *history {
+	return &history{
+		length: length,
+		values: map[historyKey][]int64{},
+	}
+}
+
+// add appends the snapshot to the history. The series no longer exist in the
+// snapshot are dropped.
+func (h *history) add(metrics map[string]*types.ClusterMetric, valueTypes map[string]string) {
+	values := map[historyKey][]int64{}
+	appendValue := func(key historyKey, v int64) {
+		series := append(h.values[key], v)
+		if len(series) > h.length {
+			series = series[len(series)-h.length:]
+		}
+		values[key] = series
+	}
+	for name, cm := range metrics {
+		for inst, im := range cm.InstanceMetrics {
+			appendValue(historyKey{name, inst, ""}, im.Summary(valueTypes[name]))
+			for dev, v := range im.DeviceMetrics {
+				appendValue(historyKey{name, inst, dev}, v)
+			}
+		}
+	}
+	h.values = values
+}
+
+// resize makes sure the history keeps at least the length of values
+func (h *history) resize(length int) {
+	if length > h.length {
+		h.length = length
+	}
+}
+
+// get returns the recent values from the oldest to the latest
+func (h *history) get(metric, inst, dev string) []int64 {
+	return h.values[historyKey{metric, inst, dev}]
+}
+
+// sparkline draws the values with the block characters, scaled between the
+// minimum and the maximum of the values
+func sparkline(values []int64) string {
+	if len(values) == 0 {
+		return ""
+	}
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	line := make([]rune, len(values))
+	for i, v := range values {
+		level := 0
+		if max != min {
+			level = int((v - min) * int64(len(sparkBlocks)-1) / (max - min))
+		}
+		line[i] = sparkBlocks[level]
+	}
+	return string(line)
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+// IdleLevel defines when the metric value is considered idle. The value is
+// idle if it's no more than Max, or no less than Min. Without the definition,
+// the value is idle only when it's zero.
+type IdleLevel struct {
+	Max *int64 `yaml:"max"`
+	Min *int64 `yaml:"min"`
+}
+
+// activity returns how far the value is from the idle level, 0 means idle
+func (f *MetricFormat) activity(value int64) int64 {
+	if f.Idle == nil {
+		if value < 0 {
+			return -value
+		}
+		return value
+	}
+	if f.Idle.Max != nil && value <= *f.Idle.Max {
+		return 0
+	}
+	if f.Idle.Min != nil && value >= *f.Idle.Min {
+		return 0
+	}
+	if f.Idle.Max != nil {
+		return value - *f.Idle.Max
+	}
+	if f.Idle.Min != nil {
+		return *f.Idle.Min - value
+	}
+	return 0
+}
+
+// instanceActivity sums up the activity of the summarized instance values
+func (c *Client) instanceActivity(metrics map[string]*types.ClusterMetric, inst string) int64 {
+	activity := int64(0)
+	for name, cm := range metrics {
+		cfg := c.metricFormatMap[name]
+		if cfg == nil || cm.InstanceMetrics[inst] == nil {
+			continue
+		}
+		activity += cfg.activity(cm.InstanceMetrics[inst].Summary(cfg.ValueType))
+	}
+	return activity
+}
+
+// deviceActivity sums up the activity of the device values
+func (c *Client) deviceActivity(metrics map[string]*types.ClusterMetric, inst, dev string) int64 {
+	activity := int64(0)
+	for name, cm := range metrics {
+		cfg := c.metricFormatMap[name]
+		if cfg == nil || cm.InstanceMetrics[inst] == nil {
+			continue
+		}
+		if v, exists := cm.InstanceMetrics[inst].DeviceMetrics[dev]; exists {
+			activity += cfg.activity(v)
+		}
+	}
+	return activity
+}
+
+// selectDevices drops the idle devices if HideIdle is set, and keeps the top
+// K busiest devices of each device prefix if TopDevices is set
+func (c *Client) selectDevices(metrics map[string]*types.ClusterMetric, inst string, devList []string) []string {
+	if !c.HideIdle && c.TopDevices <= 0 {
+		return devList
+	}
+
+	activities := map[string]int64{}
+	selected := []string{}
+	for _, dev := range devList {
+		activities[dev] = c.deviceActivity(metrics, inst, dev)
+		if c.HideIdle && activities[dev] == 0 {
+			continue
+		}
+		selected = append(selected, dev)
+	}
+	if c.TopDevices <= 0 {
+		return selected
+	}
+
+	// the busiest first, so the first K of each prefix are kept
+	sort.SliceStable(selected, func(i, j int) bool {
+		if activities[selected[i]] == activities[selected[j]] {
+			return selected[i] < selected[j]
+		}
+		return activities[selected[i]] > activities[selected[j]]
+	})
+	prefixCount := map[string]int{}
+	result := []string{}
+	for _, dev := range selected {
+		prefix := dev
+		if i := strings.Index(dev, ":"); i != -1 {
+			prefix = dev[:i]
+		}
+		if prefixCount[prefix] >= c.TopDevices {
+			continue
+		}
+		prefixCount[prefix]++
+		result = append(result, dev)
+	}
+	return result
+}
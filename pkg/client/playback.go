@@ -0,0 +1,116 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+const (
+	PlaybackTimeFormat = "2006-01-02 15:04:05"
+)
+
+var (
+	// timeLayouts are tried in order, the layouts without the zone are in
+	// the local time, and the layouts without the date are today
+	timeLayouts = []string{
+		time.RFC3339,
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04",
+	}
+	clockLayouts = []string{
+		"15:04:05",
+		"15:04",
+	}
+)
+
+// ParseTime parses the absolute time, e.g. "2020-08-01 03:12", "03:12", or
+// the time relative to now, e.g. "now", "now-1h" or "-1h"
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "now" {
+		return now, nil
+	}
+	// "now-1h" is the same as "-1h", which avoids the value being taken as
+	// a flag
+	value = strings.TrimPrefix(value, "now")
+	if strings.HasPrefix(value, "-") {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "invalid relative time %v", value)
+		}
+		return now.Add(d), nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range clockLayouts {
+		if t, err := time.ParseInLocation(layout, value, now.Location()); err == nil {
+			return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location()), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %v, e.g. \"2006-01-02 15:04:05\", \"15:04\", \"now\" or \"now-1h\"", value)
+}
+
+// Playback renders the metrics of the past window from Prometheus through
+// the server, at every step of the window. The snapshots are played at the
+// original pace multiplied by the speed, or as fast as possible if the speed
+// is 0.
+func (c *Client) Playback(from, to time.Time, step time.Duration, speed float64) error {
+	if len(c.Clusters) != 0 {
+		return fmt.Errorf("playback of multiple clusters is not supported")
+	}
+	if !to.After(from) {
+		return fmt.Errorf("the end %v is not after the start %v", to.Format(PlaybackTimeFormat), from.Format(PlaybackTimeFormat))
+	}
+	if err := c.reloadTemplateFiles(); err != nil {
+		return err
+	}
+	ConfigCheckedAt = time.Now()
+
+	source := fmt.Sprintf("playback %v to %v", from.Format(PlaybackTimeFormat), to.Format(PlaybackTimeFormat))
+	return c.run(source, func(updates chan<- *metricsUpdate) {
+		c.playbackMetrics(from, to, step, speed, updates)
+	})
+}
+
+func (c *Client) playbackMetrics(from, to time.Time, step time.Duration, speed float64, updates chan<- *metricsUpdate) {
+	defer close(updates)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c.rwMutex.RLock()
+	f, withLabels := c.serverFilter(), c.needLabels()
+	c.rwMutex.RUnlock()
+
+	stream, err := c.conn.QueryRange(ctx, from, to, step, f, withLabels)
+	if err != nil {
+		logrus.Errorf("Failed to play back the metrics: %v", err)
+		return
+	}
+
+	var last time.Time
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			logrus.Errorf("Failed to play back the metrics from %v to %v: %v", from, to, err)
+			return
+		}
+
+		last = paceSnapshot(resp.Metrics, last, speed)
+		updates <- &metricsUpdate{resp: resp.Metrics}
+	}
+}
@@ -2,7 +2,6 @@ package client
 
 import (
 	"fmt"
-	"sort"
 	"strings"
 
 	"code.cloudfoundry.org/bytefmt"
@@ -16,14 +15,55 @@ const (
 	MetricsOutputSummaryKey = "SUMMARY"
 )
 
+// tableRow is a line of the metrics table, with the instance and the device
+// (empty for the summary) it belongs to
+type tableRow struct {
+	instance string
+	device   string
+	line     string
+}
+
 func (c *Client) printMetrics(metrics map[string]*types.ClusterMetric, lineCounter *int) {
+	header, rows := c.formatMetrics(metrics)
+
+	output := &strings.Builder{}
+	if len(header) != 0 && needHeader(lineCounter) {
+		for _, line := range header {
+			output.WriteString(line + "\n")
+		}
+	}
+	for _, row := range rows {
+		output.WriteString(row.line + "\n")
+	}
+	*lineCounter += len(rows)
+
+	fmt.Print(output.String())
+}
+
+// formatMetrics returns the header lines and the rows of the metrics table
+func (c *Client) formatMetrics(metrics map[string]*types.ClusterMetric) ([]string, []tableRow) {
+	c.rwMutex.RLock()
+	defer c.rwMutex.RUnlock()
+
+	// the server has filtered the metrics already, but the filter may have
+	// been changed since, or there is no server for the replay. The where
+	// expression and the sort use the values as shown.
+	shown := c.smoothedMetrics(metrics)
+	metrics = c.Filter.ApplyBy(metrics, shown, c.valueTypes())
+
 	instanceMap := map[string]map[string]struct{}{}
 
-	for _, mi := range metrics {
+	for name, mi := range metrics {
+		_, formatted := c.metricFormatMap[name]
 		for inst, m := range mi.InstanceMetrics {
 			if instanceMap[inst] == nil {
 				instanceMap[inst] = map[string]struct{}{}
 			}
+			// the devices only in the metrics not shown, e.g. the
+			// windows not in the metric format, have no row
+			if !formatted {
+				continue
+			}
 			devMap := instanceMap[inst]
 			for dev := range (*m).DeviceMetrics {
 				devMap[dev] = struct{}{}
@@ -33,118 +73,242 @@ func (c *Client) printMetrics(metrics map[string]*types.ClusterMetric, lineCount
 	instanceList := []string{}
 	instanceDeviceList := map[string][]string{}
 	for k := range instanceMap {
+		if c.HideIdle && c.instanceActivity(metrics, k) == 0 {
+			continue
+		}
 		instanceList = append(instanceList, k)
 		devList := []string{}
 		for d := range instanceMap[k] {
 			devList = append(devList, d)
 		}
-		sort.Strings(devList)
+		devList = c.selectDevices(metrics, k, devList)
+		c.sortDevices(shown, k, devList)
 		instanceDeviceList[k] = devList
 	}
 
 	if len(instanceList) == 0 {
-		fmt.Println("No data available")
-		return
+		if len(instanceMap) != 0 {
+			return nil, []tableRow{{line: "All instances are idle"}}
+		}
+		return nil, []tableRow{{line: "No data available"}}
 	}
 
-	sort.Strings(instanceList)
+	c.sortInstances(shown, instanceList)
+	if c.Limit > 0 && len(instanceList) > c.Limit {
+		instanceList = instanceList[:c.Limit]
+	}
 
-	output := &strings.Builder{}
-	if needHeader(lineCounter) {
-		hm := map[string]string{
-			"instance": "instance",
-		}
-		for k, c := range c.metricFormatMap {
-			hm[k] = c.Shorthand
+	header := &strings.Builder{}
+	hm := map[string]string{
+		"instance": "instance",
+		"cluster":  ClusterColumnHeader,
+	}
+	for k, cfg := range c.metricFormatMap {
+		hm[k] = cfg.Shorthand
+		if c.deltaMode(cfg) != "" {
+			hm[k] = deltaHeader(hm[k], columnWidth(cfg))
 		}
-		if err := c.headerTemplate.Execute(output, hm); err != nil {
-			fmt.Printf("failed to parse for header\n")
+		if len(cfg.Windows) > 1 {
+			hm[k] = c.windowHeader(cfg, hm[k])
+		} else if k == c.SortBy {
+			hm[k] = sortHeader(hm[k], c.sortIndicator(), columnWidth(cfg))
 		}
 	}
+	bars := barMetrics(c.metricFormatMap)
+	for key, cfgs := range bars {
+		hm[key] = c.barHeader(key, cfgs)
+	}
+	headerErr := c.headerTemplate.Execute(header, hm)
 
-	*lineCounter += len(instanceList)
+	clusters := c.clusterList(instanceList)
+	clusterWidth := len(ClusterColumnHeader)
+	for _, cluster := range clusters {
+		if len(cluster) > clusterWidth {
+			clusterWidth = len(cluster)
+		}
+	}
 
+	rows := []tableRow{}
 	for _, inst := range instanceList {
-		// instance -> instance device -> metrics
-		// special key SUMMARY stored the summarized metrics
-		mc := map[string]map[string]string{}
-		mc[MetricsOutputSummaryKey] = map[string]string{}
-		mc[MetricsOutputSummaryKey]["instance"] = inst
-		for k, m := range metrics {
-			cfg, exist := c.metricFormatMap[k]
-			if !exist {
-				fmt.Printf("BUG: shouldn't have undefined metric: %v\n", k)
-				continue
-			}
-			value := ""
-			if m != nil && m.InstanceMetrics[inst] != nil {
-				switch cfg.ValueType {
-				case types.ValueTypeCPU:
-					value = colorCPU(m.InstanceMetrics[inst].Average)
-				case types.ValueTypeSize:
-					value = colorSize(bytefmt.ByteSize(uint64(m.InstanceMetrics[inst].Total)))
-				default:
-					fmt.Printf("Unknown value type %v for %v\n", cfg.ValueType, k)
-				}
-				if c.ShowDevices {
-					devValue := ""
-					for devName, devMetrics := range m.InstanceMetrics[inst].DeviceMetrics {
-						switch cfg.ValueType {
-						case types.ValueTypeCPU:
-							devValue = colorCPU(devMetrics)
-						case types.ValueTypeSize:
-							devValue = colorSize(bytefmt.ByteSize(uint64(devMetrics)))
-						default:
-							fmt.Printf("Unknown value type %v for %v\n", cfg.ValueType, k)
-						}
-						if mc[devName] == nil {
-							mc[devName] = map[string]string{}
-							mc[devName]["instance"] = devName
-						}
-						mc[devName][k] = devValue
-					}
-				}
-			} else {
-				switch cfg.ValueType {
-				case types.ValueTypeCPU:
-					value = colorNA(types.ValueTypeCPUFormat)
-				case types.ValueTypeSize:
-					value = colorNA(types.ValueTypeSizeFormat)
-				default:
-					fmt.Printf("Unknown value type %v for %v\n", cfg.ValueType, k)
-				}
+		instanceRows := c.instanceRows(metrics, bars, inst, instanceDeviceList[inst])
+		if len(clusters) != 0 {
+			cluster, _ := splitCluster(inst)
+			instanceRows = clusterColumn(instanceRows, cluster, clusterWidth)
+		}
+		rows = append(rows, instanceRows...)
+	}
+	if len(clusters) != 0 {
+		rows = append(rows, c.clusterTotalRows(metrics, bars, clusters, clusterWidth)...)
+	}
+
+	headerLines := splitLines(header.String())
+	if headerErr != nil {
+		headerLines = []string{errorLine("failed to render the header", headerErr)}
+	}
+	if len(clusters) != 0 {
+		for i := range headerLines {
+			label := ""
+			if i == len(headerLines)-1 {
+				label = ClusterColumnHeader
 			}
-			mc[MetricsOutputSummaryKey][k] = value
+			headerLines[i] = fmt.Sprintf("%-*s ", clusterWidth, label) + headerLines[i]
 		}
+	}
+	if c.smoothing() {
+		headerLines = append([]string{c.smoothNote()}, headerLines...)
+	}
+	return headerLines, rows
+}
 
-		if err := c.outputTemplate.Execute(output, mc[MetricsOutputSummaryKey]); err != nil {
-			fmt.Printf("failed to parse for instance %v\n", inst)
+// instanceRows renders the row of the instance, and the rows of the devices
+// if showing the devices
+func (c *Client) instanceRows(metrics map[string]*types.ClusterMetric, bars map[string][]*MetricFormat, inst string, devices []string) []tableRow {
+	rows := []tableRow{}
+	// instance -> instance device -> metrics
+	// special key SUMMARY stored the summarized metrics
+	mc := map[string]map[string]string{}
+	mc[MetricsOutputSummaryKey] = map[string]string{}
+	cluster, name := splitCluster(inst)
+	mc[MetricsOutputSummaryKey]["instance"] = name
+	mc[MetricsOutputSummaryKey]["cluster"] = cluster
+	for k, m := range metrics {
+		cfg, exist := c.metricFormatMap[k]
+		if !exist {
+			// e.g. the windows of the metric are not in the
+			// metric format
+			continue
 		}
-		if c.ShowDevices {
-			for _, dName := range instanceDeviceList[inst] {
-				for k, cfg := range c.metricFormatMap {
-					_, exists := mc[dName][k]
-					if !exists {
-						value := ""
-						switch cfg.ValueType {
-						case types.ValueTypeCPU:
-							value = fmt.Sprintf(types.ValueTypeCPUFormat, "")
-						case types.ValueTypeSize:
-							value = fmt.Sprintf(types.ValueTypeSizeFormat, "")
-						default:
-							fmt.Printf("Unknown value type %v for %v\n", cfg.ValueType, k)
-						}
-						mc[dName][cfg.Name] = value
+		value := ""
+		if m != nil && m.InstanceMetrics[inst] != nil {
+			value = c.formatColumn(cfg, inst, "", m.InstanceMetrics[inst].Summary(cfg.ValueType))
+			if c.ShowDevices {
+				for devName, devMetrics := range m.InstanceMetrics[inst].DeviceMetrics {
+					devValue := c.formatColumn(cfg, inst, devName, devMetrics)
+					if mc[devName] == nil {
+						mc[devName] = map[string]string{}
+						mc[devName]["instance"] = devName
 					}
+					mc[devName][k] = devValue
 				}
-				if err := c.outputTemplate.Execute(output, mc[dName]); err != nil {
-					fmt.Printf("failed to parse for instance device %v\n", dName)
+			}
+		} else {
+			switch cfg.ValueType {
+			case types.ValueTypeCPU:
+				value = colorNA(types.ValueTypeCPUFormat)
+			case types.ValueTypeSize:
+				value = colorNA(types.ValueTypeSizeFormat)
+			case types.ValueTypePercent:
+				value = colorNA(types.ValueTypePercentFormat)
+			case types.ValueTypeCount:
+				value = colorNA(types.ValueTypeCountFormat)
+			case types.ValueTypeDuration:
+				value = colorNA(types.ValueTypeDurationFormat)
+			}
+		}
+		mc[MetricsOutputSummaryKey][k] = value
+	}
+
+	c.addBars(metrics, bars, inst, devices, mc)
+	c.combineWindows(mc[MetricsOutputSummaryKey])
+	rows = append(rows, c.executeRow(inst, "", mc[MetricsOutputSummaryKey])...)
+	if c.ShowDevices {
+		for _, dName := range devices {
+			if mc[dName] == nil {
+				mc[dName] = map[string]string{"instance": dName}
+			}
+			for k, cfg := range c.metricFormatMap {
+				_, exists := mc[dName][k]
+				if !exists {
+					mc[dName][cfg.Name] = blankValue(cfg)
 				}
 			}
+			c.combineWindows(mc[dName])
+			rows = append(rows, c.executeRow(inst, dName, mc[dName])...)
 		}
 	}
+	return rows
+}
 
-	fmt.Print(output.String())
+// clusterTotalRows renders the summary row of every cluster, or marks the
+// cluster if unreachable
+func (c *Client) clusterTotalRows(metrics map[string]*types.ClusterMetric, bars map[string][]*MetricFormat, clusters []string, width int) []tableRow {
+	totals := clusterTotals(metrics, c.valueTypes())
+	rows := []tableRow{}
+	for _, cluster := range clusters {
+		if err := c.clusterErrors[cluster]; err != nil {
+			rows = append(rows, clusterErrorRow(cluster, err, width))
+			continue
+		}
+		totalRows := c.instanceRows(totals, bars, cluster+ClusterSeparator+ClusterTotalInstance, nil)
+		rows = append(rows, clusterColumn(totalRows, cluster, width)...)
+	}
+	return rows
+}
+
+// executeRow renders the row of the instance or the device with the output
+// template
+func (c *Client) executeRow(inst, dev string, data map[string]string) []tableRow {
+	output := &strings.Builder{}
+	if err := c.outputTemplate.Execute(output, data); err != nil {
+		// in place of the row, since printing it breaks the table of
+		// the top style
+		what := "instance " + inst
+		if dev != "" {
+			what = "device " + dev + " of " + what
+		}
+		return []tableRow{{instance: inst, device: dev, line: errorLine("failed to render the "+what, err)}}
+	}
+	rows := []tableRow{}
+	for _, line := range splitLines(output.String()) {
+		rows = append(rows, tableRow{instance: inst, device: dev, line: line})
+	}
+	return rows
+}
+
+// blankValue returns the empty cell in the width of the value type
+func blankValue(cfg *MetricFormat) string {
+	switch cfg.ValueType {
+	case types.ValueTypeCPU:
+		return fmt.Sprintf(types.ValueTypeCPUFormat, "")
+	case types.ValueTypeSize:
+		return fmt.Sprintf(types.ValueTypeSizeFormat, "")
+	case types.ValueTypePercent:
+		return fmt.Sprintf(types.ValueTypePercentFormat, "")
+	case types.ValueTypeCount:
+		return fmt.Sprintf(types.ValueTypeCountFormat, "")
+	case types.ValueTypeDuration:
+		return fmt.Sprintf(types.ValueTypeDurationFormat, "")
+	}
+	// the value types are validated when the metrics format is loaded
+	return ""
+}
+
+// formatValue returns the colored value in the width of the value type
+func formatValue(cfg *MetricFormat, value int64) string {
+	switch cfg.ValueType {
+	case types.ValueTypeCPU, types.ValueTypePercent:
+		return colorCPU(value)
+	case types.ValueTypeSize:
+		return colorSize(bytefmt.ByteSize(uint64(value)))
+	case types.ValueTypeCount:
+		return colorCount(types.FormatCount(value))
+	case types.ValueTypeDuration:
+		return colorDuration(types.FormatDuration(value))
+	}
+	return ""
+}
+
+// errorLine returns the error to show in the table
+func errorLine(msg string, err error) string {
+	return aurora.BrightRed(fmt.Sprintf("%v: %v", msg, err)).String()
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
 }
 
 func colorCPU(percentage int64) string {
@@ -179,6 +343,34 @@ func colorSize(byteString string) string {
 	return aurora.Sprintf(aurora.BrightWhite(format), byteString)
 }
 
+func colorCount(count string) string {
+	format := types.ValueTypeCountFormat
+	switch {
+	case count == "0":
+		return aurora.Sprintf(aurora.Gray(10, format), count)
+	case strings.HasSuffix(count, "k"):
+		return aurora.Sprintf(aurora.Yellow(format), count)
+	case strings.HasSuffix(count, "M"):
+		return aurora.Sprintf(aurora.Green(format), count)
+	case strings.HasSuffix(count, "G"), strings.HasSuffix(count, "T"):
+		return aurora.Sprintf(aurora.BrightWhite(format), count)
+	}
+	return aurora.Sprintf(aurora.Red(format), count)
+}
+
+func colorDuration(duration string) string {
+	format := types.ValueTypeDurationFormat
+	switch {
+	case duration == "0us":
+		return aurora.Sprintf(aurora.Gray(10, format), duration)
+	case strings.HasSuffix(duration, "us"):
+		return aurora.Sprintf(aurora.Red(format), duration)
+	case strings.HasSuffix(duration, "ms"):
+		return aurora.Sprintf(aurora.Yellow(format), duration)
+	}
+	return aurora.Sprintf(aurora.Green(format), duration)
+}
+
 func colorNA(format string) string {
 	return aurora.Sprintf(aurora.BrightRed(format), "NA")
 }
@@ -198,8 +390,3 @@ func needHeader(lineCounter *int) bool {
 	}
 	return false
 }
-
-func (c *Client) printTop(metrics map[string]*types.ClusterMetric) {
-	lineCounter := 0
-	c.printMetrics(metrics, &lineCounter)
-}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+const testWindowMetricFormat = `
+- name: cpu_user
+  value_type: cpu
+  shorthand: usr
+- name: disk_read
+  value_type: size
+  shorthand: read
+  windows: [10s, 1m]
+`
+
+func TestFormatMetricsUnformattedDevice(t *testing.T) {
+	c := NewClient("", "", "", "")
+	c.ShowDevices = true
+	if err := c.loadTemplates(testWindowMetricFormat, "{{.instance}} {{.cpu_user}}\n", "{{.instance}} {{.cpu_user}}\n"); err != nil {
+		t.Fatal(err)
+	}
+	metrics := map[string]*types.ClusterMetric{
+		"cpu_user": {
+			InstanceMetrics: map[string]*types.InstanceMetric{
+				"node-1": {
+					DeviceMetrics: map[string]int64{"cpu: 0": 10},
+					Average:       10,
+				},
+			},
+		},
+		// the window of the server not in the metric format of the client
+		"disk_read_5m": {
+			InstanceMetrics: map[string]*types.InstanceMetric{
+				"node-1": {DeviceMetrics: map[string]int64{"disk: sdb": 100}},
+			},
+		},
+		// the metric of an overlay of the server only
+		"disk_write": {
+			InstanceMetrics: map[string]*types.InstanceMetric{
+				"node-1": {DeviceMetrics: map[string]int64{"disk: sdc": 100}},
+			},
+		},
+	}
+	_, rows := c.formatMetrics(metrics)
+
+	devices := []string{}
+	for _, row := range rows {
+		if row.device != "" {
+			devices = append(devices, row.device)
+		}
+	}
+	if len(devices) != 1 || devices[0] != "cpu: 0" {
+		t.Errorf("got the device rows %v, want [cpu: 0]", devices)
+	}
+	if len(rows) == 0 || !strings.HasPrefix(rows[0].line, "node-1 ") {
+		t.Errorf("got the rows %v, want the row of node-1 first", rows)
+	}
+}
+
+func TestFormatMetricsTemplateError(t *testing.T) {
+	c := NewClient("", "", "", "")
+	// the field of the string cannot be evaluated when rendered
+	if err := c.loadTemplates(testWindowMetricFormat, "{{.instance.name}}\n", "{{.instance.name}}\n"); err != nil {
+		t.Fatal(err)
+	}
+	metrics := map[string]*types.ClusterMetric{
+		"cpu_user": {
+			InstanceMetrics: map[string]*types.InstanceMetric{
+				"node-1": {Average: 10},
+			},
+		},
+	}
+	header, rows := c.formatMetrics(metrics)
+	if len(header) != 1 || !strings.Contains(header[0], "failed to render the header") {
+		t.Errorf("got the header %q, want the error", header)
+	}
+	if len(rows) != 1 || rows[0].instance != "node-1" || !strings.Contains(rows[0].line, "failed to render the instance node-1") {
+		t.Errorf("got the rows %q, want the error of node-1", rows)
+	}
+}
+
+func TestParseMetricFormatUnknownValueType(t *testing.T) {
+	for _, format := range []string{
+		"- name: cpu_user\n  value_type: cpus\n",
+		"- name: cpu_user\n",
+	} {
+		if _, err := parseMetricFormat(format); err == nil {
+			t.Errorf("parsed the metrics format %q with the unknown value type", format)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	pb "github.com/yasker/kstat/pkg/pb/v1"
+	"github.com/yasker/kstat/pkg/record"
+	"github.com/yasker/kstat/pkg/version"
+)
+
+func (c *Client) startRecording() error {
+	// the recording needs the configuration in the header
+	if err := c.reloadTemplateFiles(); err != nil {
+		return errors.Wrap(err, "failed to load the configuration files for recording")
+	}
+	ConfigCheckedAt = time.Now()
+
+	header := c.recordHeader()
+	w, err := record.NewWriter(c.RecordFile, header)
+	if err != nil {
+		return err
+	}
+	c.recorder = w
+	c.recordedHeader = header
+	return nil
+}
+
+// recordHeader returns the header of the current configuration
+func (c *Client) recordHeader() *pb.RecordHeader {
+	c.rwMutex.RLock()
+	defer c.rwMutex.RUnlock()
+	return &pb.RecordHeader{
+		Version:        version.FriendlyVersion(),
+		MetricsFormat:  c.metricFormatData,
+		HeaderTemplate: c.headerTemplateData,
+		OutputTemplate: c.outputTemplateData,
+	}
+}
+
+// recordHeaderIfChanged records the new header if the configuration has been
+// reloaded with changes, so the snapshots after it replay with it
+func (c *Client) recordHeaderIfChanged() error {
+	header := c.recordHeader()
+	last := c.recordedHeader
+	if header.MetricsFormat == last.MetricsFormat && header.HeaderTemplate == last.HeaderTemplate && header.OutputTemplate == last.OutputTemplate {
+		return nil
+	}
+	if err := c.recorder.WriteHeader(header); err != nil {
+		return err
+	}
+	c.recordedHeader = header
+	return nil
+}
+
+// Replay renders a recorded session using the recorded configuration. The
+// snapshots are played at the original pace multiplied by the speed, or as
+// fast as possible if the speed is 0.
+func (c *Client) Replay(file string, speed float64) error {
+	r, err := record.NewReader(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if err := c.loadTemplates(r.Header.MetricsFormat, r.Header.HeaderTemplate, r.Header.OutputTemplate); err != nil {
+		return errors.Wrapf(err, "failed to load the configuration recorded in %v", file)
+	}
+
+	c.source = "replay " + file
+	updates := make(chan *metricsUpdate)
+	go c.replayMetrics(r, file, speed, updates)
+	return c.display(updates)
+}
+
+func (c *Client) replayMetrics(r *record.Reader, file string, speed float64, updates chan<- *metricsUpdate) {
+	defer close(updates)
+
+	var last time.Time
+	for {
+		frame, err := r.Read()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			logrus.Errorf("Failed to read the record file %v: %v", file, err)
+			return
+		}
+		if h := frame.Header; h != nil {
+			// the configuration was reloaded during the recording
+			if err := c.loadTemplates(h.MetricsFormat, h.HeaderTemplate, h.OutputTemplate); err != nil {
+				logrus.Errorf("Failed to load the configuration recorded in %v: %v", file, err)
+			}
+			continue
+		}
+
+		last = paceSnapshot(frame.Metrics, last, speed)
+		updates <- &metricsUpdate{resp: frame.Metrics}
+	}
+}
+
+// paceSnapshot waits for the time between the last snapshot and this one
+// divided by the speed, or doesn't wait if the speed is 0. It returns the time
+// of this snapshot.
+func paceSnapshot(resp *pb.GetMetricsResponse, last time.Time, speed float64) time.Time {
+	ts := time.Unix(0, resp.Timestamp)
+	if !last.IsZero() && speed > 0 {
+		time.Sleep(time.Duration(float64(ts.Sub(last)) / speed))
+	}
+	return ts
+}
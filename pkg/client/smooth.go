@@ -0,0 +1,131 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+const (
+	SmoothFuncAvg = "avg"
+	SmoothFuncMax = "max"
+
+	// DefaultSmoothSamples is used when the smoothing is turned on in the
+	// top mode without --smooth
+	DefaultSmoothSamples = 5
+)
+
+func ValidateSmoothFunc(fn string) error {
+	switch fn {
+	case SmoothFuncAvg, SmoothFuncMax:
+		return nil
+	}
+	return fmt.Errorf("invalid smooth function %v, must be %v or %v", fn, SmoothFuncAvg, SmoothFuncMax)
+}
+
+// smoothing returns if the values are smoothed over the recent samples
+func (c *Client) smoothing() bool {
+	return c.Smooth > 1 && !c.smoothPaused
+}
+
+// toggleSmooth switches between the raw and the smoothed values, and
+// refreshes the metrics since the server only filters the raw values
+func (c *Client) toggleSmooth() {
+	c.rwMutex.Lock()
+	if c.Smooth <= 1 {
+		c.Smooth = DefaultSmoothSamples
+		c.smoothPaused = false
+	} else {
+		c.smoothPaused = !c.smoothPaused
+	}
+	c.history.resize(c.Smooth)
+	c.rwMutex.Unlock()
+
+	c.refreshMetrics()
+}
+
+// smoothNote describes the smoothing for the header
+func (c *Client) smoothNote() string {
+	return fmt.Sprintf("(%v of the last %d samples)", c.smoothFunc(), c.Smooth)
+}
+
+func (c *Client) smoothFunc() string {
+	if c.SmoothFunc == "" {
+		return SmoothFuncAvg
+	}
+	return c.SmoothFunc
+}
+
+// columnValue returns the value of the metric for the instance, or the device
+// if not empty, smoothed over the recent samples if smoothing
+func (c *Client) columnValue(cfg *MetricFormat, inst, dev string, value int64) int64 {
+	if c.smoothing() {
+		return c.smoothValue(cfg.Name, inst, dev, value)
+	}
+	return value
+}
+
+// smoothedMetrics returns the copy of the metrics with the values shown in the
+// columns if smoothing, so the where expression and the sort use the same
+// values as the display. The columns in the delta mode keep the raw values.
+// Caller must hold the lock.
+func (c *Client) smoothedMetrics(metrics map[string]*types.ClusterMetric) map[string]*types.ClusterMetric {
+	if !c.smoothing() {
+		return metrics
+	}
+	result := map[string]*types.ClusterMetric{}
+	for name, cm := range metrics {
+		cfg := c.metricFormatMap[name]
+		if cfg == nil || c.deltaMode(cfg) != "" {
+			result[name] = cm
+			continue
+		}
+		scm := &types.ClusterMetric{
+			InstanceMetrics: map[string]*types.InstanceMetric{},
+		}
+		for inst, im := range cm.InstanceMetrics {
+			sim := *im
+			summary := c.smoothValue(name, inst, "", im.Summary(cfg.ValueType))
+			sim.Total, sim.Average = summary, summary
+			if im.DeviceMetrics != nil {
+				sim.DeviceMetrics = map[string]int64{}
+				for dev, v := range im.DeviceMetrics {
+					sim.DeviceMetrics[dev] = c.smoothValue(name, inst, dev, v)
+				}
+			}
+			scm.InstanceMetrics[inst] = &sim
+		}
+		result[name] = scm
+	}
+	return result
+}
+
+// smoothValue returns the average or the maximum of the metric for the
+// instance, or the device if not empty, over the last N samples. The latest
+// sample is already in the history.
+func (c *Client) smoothValue(metric, inst, dev string, value int64) int64 {
+	values := c.history.get(metric, inst, dev)
+	if len(values) > c.Smooth {
+		values = values[len(values)-c.Smooth:]
+	}
+	if len(values) == 0 {
+		return value
+	}
+
+	result := values[0]
+	switch c.smoothFunc() {
+	case SmoothFuncMax:
+		for _, v := range values[1:] {
+			if v > result {
+				result = v
+			}
+		}
+	default:
+		sum := int64(0)
+		for _, v := range values {
+			sum += v
+		}
+		result = sum / int64(len(values))
+	}
+	return result
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yasker/kstat/pkg/filter"
+	pb "github.com/yasker/kstat/pkg/pb/v1"
+	"github.com/yasker/kstat/pkg/types"
+)
+
+func TestSmoothedSortAndWhere(t *testing.T) {
+	c := NewClient("", "", "", "")
+	c.metricFormatMap = map[string]*MetricFormat{
+		"cpu_user": {Name: "cpu_user", ValueType: types.ValueTypeCPU},
+	}
+	c.Smooth = 3
+	c.SortBy = "cpu_user"
+	c.SortDescending = true
+	// node-1 is busy until the last sample, node-2 is getting busy
+	samples := [][]int64{{90, 30}, {90, 30}, {10, 40}}
+	for i, values := range samples {
+		resp := &pb.GetMetricsResponse{
+			Timestamp: int64(i + 1),
+			ClusterMetrics: map[string]*pb.ClusterMetric{
+				"cpu_user": {
+					InstanceMetrics: map[string]*pb.InstanceMetric{
+						"node-1": {Average: values[0]},
+						"node-2": {Average: values[1]},
+					},
+				},
+			},
+		}
+		if err := c.update(&metricsUpdate{resp: resp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := filter.NewFilter("cpu_user > 50", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := PBToMetrics(c.lastResp)
+
+	tests := []struct {
+		name   string
+		paused bool
+		where  []string
+		sorted []string
+	}{
+		{"smoothed", false, []string{"node-1"}, []string{"node-1", "node-2"}},
+		{"raw", true, []string{}, []string{"node-2", "node-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.smoothPaused = tt.paused
+			shown := c.smoothedMetrics(metrics)
+
+			selected := []string{}
+			for inst := range f.ApplyBy(metrics, shown, c.valueTypes())["cpu_user"].InstanceMetrics {
+				selected = append(selected, inst)
+			}
+			if !reflect.DeepEqual(selected, tt.where) {
+				t.Errorf("selected %v, want %v", selected, tt.where)
+			}
+
+			sorted := []string{"node-1", "node-2"}
+			c.sortInstances(shown, sorted)
+			if !reflect.DeepEqual(sorted, tt.sorted) {
+				t.Errorf("sorted %v, want %v", sorted, tt.sorted)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+const (
+	SortOrderAscending  = "asc"
+	SortOrderDescending = "desc"
+
+	SortIndicatorAscending  = "▲"
+	SortIndicatorDescending = "▼"
+)
+
+// sortInstances sorts the instances by the sort metric, and falls back to
+// the name order. Instances without the metric are always put at the end.
+func (c *Client) sortInstances(metrics map[string]*types.ClusterMetric, instanceList []string) {
+	sort.Strings(instanceList)
+	if c.SortBy == "" {
+		return
+	}
+
+	valueType := ""
+	if cfg := c.metricFormatMap[c.SortBy]; cfg != nil {
+		valueType = cfg.ValueType
+	}
+	cm := metrics[c.SortBy]
+	value := func(inst string) (int64, bool) {
+		if cm == nil || cm.InstanceMetrics[inst] == nil {
+			return 0, false
+		}
+		return cm.InstanceMetrics[inst].Summary(valueType), true
+	}
+	c.sortByValue(instanceList, value)
+}
+
+// sortDevices sorts the devices of the instance by the sort metric, and falls
+// back to the name order
+func (c *Client) sortDevices(metrics map[string]*types.ClusterMetric, inst string, devList []string) {
+	sort.Strings(devList)
+	if c.SortBy == "" {
+		return
+	}
+
+	cm := metrics[c.SortBy]
+	value := func(dev string) (int64, bool) {
+		if cm == nil || cm.InstanceMetrics[inst] == nil {
+			return 0, false
+		}
+		v, exists := cm.InstanceMetrics[inst].DeviceMetrics[dev]
+		return v, exists
+	}
+	c.sortByValue(devList, value)
+}
+
+func (c *Client) sortByValue(list []string, value func(string) (int64, bool)) {
+	sort.SliceStable(list, func(i, j int) bool {
+		vi, iExists := value(list[i])
+		vj, jExists := value(list[j])
+		if iExists != jExists {
+			return iExists
+		}
+		if vi == vj {
+			return false
+		}
+		if c.SortDescending {
+			return vi > vj
+		}
+		return vi < vj
+	})
+}
+
+// sortColumns returns the columns can be sorted by, in which the empty name
+// stands for sorting by the instance name
+func (c *Client) sortColumns() []string {
+	columns := []string{""}
+	for name := range c.metricFormatMap {
+		columns = append(columns, name)
+	}
+	sort.Strings(columns[1:])
+	return columns
+}
+
+// cycleSortColumn moves the sort column to the next or previous one
+func (c *Client) cycleSortColumn(step int) {
+	c.rwMutex.Lock()
+	defer c.rwMutex.Unlock()
+
+	columns := c.sortColumns()
+	current := 0
+	for i, name := range columns {
+		if name == c.SortBy {
+			current = i
+			break
+		}
+	}
+	next := (current + step + len(columns)) % len(columns)
+	c.SortBy = columns[next]
+}
+
+func (c *Client) reverseSortOrder() {
+	c.rwMutex.Lock()
+	defer c.rwMutex.Unlock()
+
+	c.SortDescending = !c.SortDescending
+}
+
+func (c *Client) sortIndicator() string {
+	if c.SortDescending {
+		return SortIndicatorDescending
+	}
+	return SortIndicatorAscending
+}
+
+// sortHeader appends the indicator to the header of the column, and cuts the
+// header to keep it in the width of the column with a space before it
+func sortHeader(header, indicator string, width int) string {
+	runes := []rune(header)
+	if max := width - 1 - utf8.RuneCountInString(indicator); len(runes) > max && max > 0 {
+		runes = runes[:max]
+	}
+	return string(runes) + indicator
+}
+
+// validateSortBy checks the sort metric is one of the metrics or the windows
+// of them
+func validateSortBy(metricFormatMap map[string]*MetricFormat, sortBy string) error {
+	if sortBy == "" {
+		return nil
+	}
+	available := metricNames(metricFormatMap)
+	for _, name := range available {
+		if name == sortBy {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown sort metric %v, must be one of %v", sortBy, strings.Join(available, ","))
+}
+
+func (c *Client) sortStatus() string {
+	c.rwMutex.RLock()
+	defer c.rwMutex.RUnlock()
+
+	order := SortOrderAscending
+	if c.SortDescending {
+		order = SortOrderDescending
+	}
+	name := c.SortBy
+	if name == "" {
+		// the instance name is always sorted alphabetically
+		name = "instance"
+		order = SortOrderAscending
+	}
+	status := fmt.Sprintf("sort: %v %v", name, order)
+	if c.Limit > 0 {
+		status += fmt.Sprintf(", top %v", c.Limit)
+	}
+	return status
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"testing"
+	"unicode/utf8"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+func TestSortHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		width  int
+		want   string
+	}{
+		{"usr", 5, "usr▼"},
+		{"idle", 5, "idl▼"},
+		{"steal", 5, "ste▼"},
+		{"Δwrite", 8, "Δwrite▼"},
+		{"toolongname", 8, "toolon▼"},
+	}
+	for _, tt := range tests {
+		got := sortHeader(tt.header, SortIndicatorDescending, tt.width)
+		if got != tt.want {
+			t.Errorf("sortHeader(%q, %v) = %q, want %q", tt.header, tt.width, got, tt.want)
+		}
+		if n := utf8.RuneCountInString(got); n >= tt.width {
+			t.Errorf("sortHeader(%q, %v) = %q is wider than the column", tt.header, tt.width, got)
+		}
+	}
+}
+
+func TestValidateSortBy(t *testing.T) {
+	formats := map[string]*MetricFormat{
+		"cpu_user":  {Name: "cpu_user", ValueType: types.ValueTypeCPU, Windows: []string{"10s", "1m"}},
+		"mem_avail": {Name: "mem_avail", ValueType: types.ValueTypeSize},
+	}
+	tests := []struct {
+		sortBy string
+		valid  bool
+	}{
+		{"", true},
+		{"cpu_user", true},
+		{"cpu_user_1m", true},
+		{"mem_avail", true},
+		{"cpu_usr", false},
+		{"cpu_user_10s", false},
+	}
+	for _, tt := range tests {
+		err := validateSortBy(formats, tt.sortBy)
+		if (err == nil) != tt.valid {
+			t.Errorf("validateSortBy(%q) = %v, want valid %v", tt.sortBy, err, tt.valid)
+		}
+	}
+}
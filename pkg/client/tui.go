@@ -0,0 +1,533 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh/terminal"
+
+	"github.com/yasker/kstat/pkg/filter"
+)
+
+const (
+	escAltScreenOn  = "\033[?1049h"
+	escAltScreenOff = "\033[?1049l"
+	escCursorHide   = "\033[?25l"
+	escCursorShow   = "\033[?25h"
+	escWrapOff      = "\033[?7l"
+	escWrapOn       = "\033[?7h"
+	escCursorHome   = "\033[H"
+	escClearLine    = "\033[K"
+	escClearBelow   = "\033[J"
+	escReverse      = "\033[7m"
+	escReset        = "\033[0m"
+)
+
+const (
+	KeyUp        = "up"
+	KeyDown      = "down"
+	KeyPageUp    = "pgup"
+	KeyPageDown  = "pgdown"
+	KeyHome      = "home"
+	KeyEnd       = "end"
+	KeyEnter     = "enter"
+	KeyEscape    = "esc"
+	KeyBackspace = "backspace"
+	KeyCtrlC     = "ctrl-c"
+)
+
+var keySequences = map[string]string{
+	"\033[A":  KeyUp,
+	"\033[B":  KeyDown,
+	"\033[5~": KeyPageUp,
+	"\033[6~": KeyPageDown,
+	"\033[H":  KeyHome,
+	"\033[1~": KeyHome,
+	"\033OH":  KeyHome,
+	"\033[F":  KeyEnd,
+	"\033[4~": KeyEnd,
+	"\033OF":  KeyEnd,
+}
+
+// topScreen is the full screen terminal used by the top mode. It draws on the
+// alternate screen buffer, and restores the terminal when closed.
+type topScreen struct {
+	in       *os.File
+	out      *os.File
+	oldState *terminal.State
+
+	width  int
+	height int
+	scroll int
+	cursor int
+	// selected is the row under the cursor
+	selected tableRow
+	// hideCursor doesn't highlight the row under the cursor
+	hideCursor bool
+
+	keys    chan string
+	resize  chan os.Signal
+	signals chan os.Signal
+
+	// the input line replaces the status bar when active
+	inputActive bool
+	inputPrompt string
+	input       []rune
+	inputSubmit func(string) error
+	// message replaces the status bar until the next key
+	message string
+
+	logs *bytes.Buffer
+}
+
+func newTopScreen() (*topScreen, error) {
+	s := &topScreen{
+		in:      os.Stdin,
+		out:     os.Stdout,
+		keys:    make(chan string, 16),
+		resize:  make(chan os.Signal, 1),
+		signals: make(chan os.Signal, 1),
+		logs:    &bytes.Buffer{},
+	}
+
+	oldState, err := terminal.MakeRaw(int(s.in.Fd()))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot set the terminal to raw mode")
+	}
+	s.oldState = oldState
+	s.updateSize()
+
+	// the logs would mess up the screen, show them after the screen closed
+	logrus.SetOutput(s.logs)
+
+	notifyResize(s.resize)
+	signal.Notify(s.signals, os.Interrupt, syscall.SIGTERM)
+
+	s.out.WriteString(escAltScreenOn + escCursorHide + escWrapOff)
+
+	go s.readKeys()
+	return s, nil
+}
+
+func (s *topScreen) Close() {
+	signal.Stop(s.resize)
+	signal.Stop(s.signals)
+
+	s.out.WriteString(escWrapOn + escCursorShow + escAltScreenOff)
+	terminal.Restore(int(s.in.Fd()), s.oldState)
+
+	logrus.SetOutput(os.Stderr)
+	os.Stderr.Write(s.logs.Bytes())
+}
+
+func (s *topScreen) updateSize() {
+	width, height, err := terminal.GetSize(int(s.out.Fd()))
+	if err != nil {
+		return
+	}
+	s.width = width
+	s.height = height
+}
+
+func (s *topScreen) readKeys() {
+	buf := make([]byte, 64)
+	for {
+		n, err := s.in.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			s.keys <- key
+		}
+	}
+}
+
+func parseKeys(input []byte) []string {
+	keys := []string{}
+	for len(input) > 0 {
+		if input[0] == '\033' {
+			matched := false
+			for seq, key := range keySequences {
+				if bytes.HasPrefix(input, []byte(seq)) {
+					keys = append(keys, key)
+					input = input[len(seq):]
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				// unknown escape sequences are dropped as a whole, and
+				// the keys after them are kept
+				if n := escapeSequenceLength(input); n != 0 {
+					input = input[n:]
+					continue
+				}
+				keys = append(keys, KeyEscape)
+				input = input[1:]
+			}
+			continue
+		}
+
+		switch input[0] {
+		case 3:
+			keys = append(keys, KeyCtrlC)
+		case '\r', '\n':
+			keys = append(keys, KeyEnter)
+		case 127, 8:
+			keys = append(keys, KeyBackspace)
+		default:
+			r, size := utf8.DecodeRune(input)
+			keys = append(keys, string(r))
+			input = input[size:]
+			continue
+		}
+		input = input[1:]
+	}
+	return keys
+}
+
+// escapeSequenceLength returns the length of the CSI or SS3 sequence at the
+// start of the input, the rest of the input if it's incomplete, or 0 if it's
+// not such a sequence, e.g. a single Esc
+func escapeSequenceLength(input []byte) int {
+	if len(input) < 2 {
+		return 0
+	}
+	switch input[1] {
+	case 'O':
+		// SS3: Esc O and a single character, e.g. F1
+		if len(input) < 3 {
+			return len(input)
+		}
+		return 3
+	case '[':
+		// CSI: Esc [, the parameter and intermediate bytes, and the final
+		// byte in 0x40-0x7e
+		for i := 2; i < len(input); i++ {
+			if input[i] >= 0x40 && input[i] <= 0x7e {
+				return i + 1
+			}
+			if input[i] < 0x20 || input[i] > 0x3f {
+				// not a valid sequence, keep the byte breaking it
+				return i
+			}
+		}
+		return len(input)
+	}
+	return 0
+}
+
+// startInput shows the input line with the prompt, and calls submit with
+// the input when the user hits Enter. The input line stays if submit fails.
+func (s *topScreen) startInput(prompt, initial string, submit func(string) error) {
+	s.inputActive = true
+	s.inputPrompt = prompt
+	s.input = []rune(initial)
+	s.inputSubmit = submit
+}
+
+func (s *topScreen) handleInputKey(key string) {
+	s.message = ""
+	switch key {
+	case KeyEnter:
+		if err := s.inputSubmit(string(s.input)); err != nil {
+			s.message = err.Error()
+			return
+		}
+		s.inputActive = false
+	case KeyEscape, KeyCtrlC:
+		s.inputActive = false
+	case KeyBackspace:
+		if len(s.input) > 0 {
+			s.input = s.input[:len(s.input)-1]
+		}
+	default:
+		if utf8.RuneCountInString(key) == 1 {
+			s.input = append(s.input, []rune(key)...)
+		}
+	}
+}
+
+// bodyHeight returns how many rows can be shown under the header, with the
+// status bar at the bottom
+func (s *topScreen) bodyHeight(headerLines int) int {
+	h := s.height - headerLines - 1
+	if h < 1 {
+		return 1
+	}
+	return h
+}
+
+// moveCursor moves the cursor by the delta, and scrolls to keep the cursor
+// on the screen
+func (s *topScreen) moveCursor(delta int, rows []tableRow, headerLines int) {
+	s.cursor += delta
+	s.clampCursor(len(rows), headerLines)
+	if s.cursor < len(rows) {
+		s.selected = rows[s.cursor]
+	}
+}
+
+// followSelected moves the cursor to the row of the selected instance and
+// device, since the rows may be reordered by the new snapshot
+func (s *topScreen) followSelected(rows []tableRow) {
+	for i, row := range rows {
+		if row.instance == s.selected.instance && row.device == s.selected.device {
+			s.cursor = i
+			return
+		}
+	}
+}
+
+func (s *topScreen) clampCursor(rowCount, headerLines int) {
+	if s.cursor >= rowCount {
+		s.cursor = rowCount - 1
+	}
+	if s.cursor < 0 {
+		s.cursor = 0
+	}
+
+	bodyHeight := s.bodyHeight(headerLines)
+	if s.cursor < s.scroll {
+		s.scroll = s.cursor
+	}
+	if s.cursor >= s.scroll+bodyHeight {
+		s.scroll = s.cursor - bodyHeight + 1
+	}
+	if maxScroll := rowCount - bodyHeight; s.scroll > maxScroll {
+		s.scroll = maxScroll
+	}
+	if s.scroll < 0 {
+		s.scroll = 0
+	}
+}
+
+// draw overwrites the screen in place rather than clearing it first, to
+// avoid the flicker
+func (s *topScreen) draw(header []string, rows []tableRow, status, hint string) {
+	s.followSelected(rows)
+	s.clampCursor(len(rows), len(header))
+	bodyHeight := s.bodyHeight(len(header))
+
+	buf := &strings.Builder{}
+	buf.WriteString(escCursorHome)
+	for _, line := range header {
+		buf.WriteString(line + escReset + escClearLine + "\r\n")
+	}
+	for i := s.scroll; i < len(rows) && i < s.scroll+bodyHeight; i++ {
+		line := rows[i].line
+		if i == s.cursor && !s.hideCursor {
+			// keep the highlight through the color resets in the line
+			line = escReverse + strings.ReplaceAll(line, escReset, escReset+escReverse)
+		}
+		buf.WriteString(line + escClearLine + escReset + "\r\n")
+	}
+	buf.WriteString(escClearBelow)
+
+	if len(rows) > bodyHeight {
+		last := s.scroll + bodyHeight
+		if last > len(rows) {
+			last = len(rows)
+		}
+		status += fmt.Sprintf(" | rows %d-%d of %d", s.scroll+1, last, len(rows))
+	}
+	status += " | " + hint
+	if s.inputActive {
+		status = s.inputPrompt + string(s.input) + "_"
+	}
+	if s.message != "" {
+		status = s.message
+	}
+	buf.WriteString(fmt.Sprintf("\033[%d;1H", s.height))
+	buf.WriteString(escReverse + padLine(status, s.width) + escReset)
+
+	s.out.WriteString(buf.String())
+}
+
+func padLine(line string, width int) string {
+	runes := []rune(line)
+	if len(runes) > width {
+		return string(runes[:width])
+	}
+	return line + strings.Repeat(" ", width-len(runes))
+}
+
+func (c *Client) runTop(updates <-chan *metricsUpdate) error {
+	screen, err := newTopScreen()
+	if err != nil {
+		return err
+	}
+	defer screen.Close()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	ended := false
+	// the selected row of the table before entering the detail view
+	tableSelected := tableRow{}
+	// the content on the screen, which the keys move the cursor in. It's
+	// formatted once per event, after the event changes it.
+	header, rows := c.topContent()
+	for {
+		select {
+		case u, ok := <-updates:
+			if !ok {
+				// keep the last snapshot on the screen after the
+				// end of the recording
+				ended = true
+				updates = nil
+				break
+			}
+			if err := c.update(u); err != nil {
+				return err
+			}
+			c.reconnecting = u.err != nil
+		case key := <-screen.keys:
+			if screen.inputActive {
+				screen.handleInputKey(key)
+				break
+			}
+			screen.message = ""
+			switch key {
+			case "q", KeyCtrlC:
+				return nil
+			case KeyUp, "k":
+				screen.moveCursor(-1, rows, len(header))
+			case KeyDown, "j":
+				screen.moveCursor(1, rows, len(header))
+			case KeyPageUp, "b":
+				screen.moveCursor(-screen.bodyHeight(len(header)), rows, len(header))
+			case KeyPageDown, " ":
+				screen.moveCursor(screen.bodyHeight(len(header)), rows, len(header))
+			case KeyHome, "g":
+				screen.moveCursor(-len(rows), rows, len(header))
+			case KeyEnd, "G":
+				screen.moveCursor(len(rows), rows, len(header))
+			case KeyEnter:
+				if c.DetailInstance == "" && screen.cursor < len(rows) && rows[screen.cursor].instance != "" &&
+					!isClusterTotal(rows[screen.cursor].instance) {
+					c.setDetailInstance(rows[screen.cursor].instance)
+					tableSelected = screen.selected
+					screen.selected = tableRow{}
+					screen.cursor = 0
+				}
+			case KeyEscape, KeyBackspace, "h":
+				if c.DetailInstance != "" {
+					c.setDetailInstance("")
+					screen.selected = tableSelected
+				}
+			case "s":
+				c.cycleSortColumn(1)
+			case "S":
+				c.cycleSortColumn(-1)
+			case "r":
+				c.reverseSortOrder()
+			case "d":
+				c.ShowDelta = !c.ShowDelta
+			case "m":
+				c.toggleSmooth()
+			case "/":
+				screen.startInput("where: ", c.filterWhere(), c.setFilterWhere)
+			case "1", "2", "3", "4", "5", "6", "7", "8", "9":
+				if err := c.toggleGroup(int(key[0] - '1')); err != nil {
+					screen.message = err.Error()
+				}
+			}
+		case <-screen.resize:
+			screen.updateSize()
+		case <-screen.signals:
+			return nil
+		case <-ticker.C:
+			// also catch the resize on the platforms without SIGWINCH
+			screen.updateSize()
+		}
+
+		header, rows = c.topContent()
+		hint := "enter:detail s/S:sort r:reverse d:delta m:smooth 1-9:groups /:filter q:quit"
+		if c.DetailInstance != "" {
+			hint = "esc:back s/S:sort r:reverse d:delta m:smooth q:quit"
+		}
+		screen.draw(header, rows, c.topStatus(ended), hint)
+	}
+}
+
+func (c *Client) topContent() ([]string, []tableRow) {
+	if c.lastResp == nil {
+		return nil, []tableRow{{line: "Waiting for data..."}}
+	}
+	if c.DetailInstance != "" {
+		return c.formatDetail(PBToMetrics(c.lastResp), c.DetailInstance)
+	}
+	if c.Heatmap != "" {
+		return c.formatHeatmap(PBToMetrics(c.lastResp), c.Heatmap)
+	}
+	return c.formatMetrics(PBToMetrics(c.lastResp))
+}
+
+func (c *Client) topStatus(ended bool) string {
+	status := " kstat | " + c.source
+	if c.lastResp != nil {
+		status += " | " + time.Unix(0, c.lastResp.Timestamp).Format("2006-01-02 15:04:05")
+	}
+	status += " | " + c.sortStatus()
+	if where := c.filterWhere(); where != "" {
+		status += " | where: " + where
+	}
+	c.rwMutex.RLock()
+	grouped := len(c.Groups) != 0
+	c.rwMutex.RUnlock()
+	if grouped {
+		status += " | groups " + c.groupsStatus()
+	}
+	switch {
+	case ended:
+		status += " | end of data"
+	case c.reconnecting:
+		status += " | " + c.reconnectingStatus()
+	case c.lastResp != nil && c.live:
+		status += fmt.Sprintf(" | updated %v ago", c.staleness())
+	}
+	return status
+}
+
+func (c *Client) filterWhere() string {
+	c.rwMutex.RLock()
+	defer c.rwMutex.RUnlock()
+
+	if c.Filter == nil {
+		return ""
+	}
+	return c.Filter.Where
+}
+
+// setFilterWhere replaces the where expression, and keeps the regex filters
+// and the label selector
+func (c *Client) setFilterWhere(where string) error {
+	c.rwMutex.RLock()
+	instanceRegex, deviceRegex, labelSelector := "", "", ""
+	if c.Filter != nil {
+		instanceRegex, deviceRegex, labelSelector = c.Filter.InstanceRegex, c.Filter.DeviceRegex, c.Filter.LabelSelector
+	}
+	c.rwMutex.RUnlock()
+
+	f, err := filter.NewFilter(where, instanceRegex, deviceRegex, labelSelector)
+	if err != nil {
+		return err
+	}
+	c.rwMutex.RLock()
+	err = f.Validate(metricNames(c.metricFormatMap))
+	c.rwMutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	c.setFilter(f)
+	return nil
+}
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		keys  []string
+	}{
+		{"plain", "qs", []string{"q", "s"}},
+		{"enter and backspace", "a\r\x7f", []string{"a", KeyEnter, KeyBackspace}},
+		{"known sequences", "\033[A\033[6~\033OH", []string{KeyUp, KeyPageDown, KeyHome}},
+		{"single escape", "\033", []string{KeyEscape}},
+		{"escape then key", "\033q", []string{KeyEscape, "q"}},
+		{"unknown CSI keeps the keys after it", "\033[1;5Cq", []string{"q"}},
+		{"unknown SS3 keeps the keys after it", "\033OPjk", []string{"j", "k"}},
+		{"unknown between keys", "a\033[200~b", []string{"a", "b"}},
+		{"incomplete CSI", "a\033[1;", []string{"a"}},
+		{"utf8", "é", []string{"é"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if keys := parseKeys([]byte(tt.input)); !reflect.DeepEqual(keys, tt.keys) {
+				t.Errorf("parseKeys(%q) = %q, want %q", tt.input, keys, tt.keys)
+			}
+		})
+	}
+}
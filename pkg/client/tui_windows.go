@@ -0,0 +1,10 @@
+package client
+
+import (
+	"os"
+)
+
+// notifyResize is a no-op since there is no SIGWINCH on Windows, the top mode
+// checks the terminal size periodically instead
+func notifyResize(c chan<- os.Signal) {
+}
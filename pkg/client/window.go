@@ -0,0 +1,62 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+const (
+	WindowSeparator = "/"
+)
+
+func windowsExceptFirst(cfg *MetricFormat) []string {
+	if len(cfg.Windows) < 2 {
+		return nil
+	}
+	return cfg.Windows[1:]
+}
+
+// windowHeader returns the header of the metric with the windows as the sub
+// columns, e.g. "usr 10s/1m/5m", in the width of the combined values
+func (c *Client) windowHeader(cfg *MetricFormat, shorthand string) string {
+	labels := []string{}
+	for i, w := range cfg.Windows {
+		name := cfg.Name
+		if i != 0 {
+			name = types.WindowMetricName(cfg.Name, w)
+		}
+		if name == c.SortBy {
+			w += c.sortIndicator()
+		}
+		labels = append(labels, w)
+	}
+	header := shorthand + " " + strings.Join(labels, WindowSeparator)
+	return fmt.Sprintf("%*s", columnWidth(cfg), header)
+}
+
+// combineWindows puts the values of the other windows of the metrics into
+// the column of the metric, e.g. "12/10/8"
+func (c *Client) combineWindows(data map[string]string) {
+	for name, cfg := range c.metricFormatMap {
+		windows := windowsExceptFirst(cfg)
+		if len(windows) == 0 {
+			continue
+		}
+		values := []string{data[name]}
+		for _, w := range windows {
+			value, exists := data[types.WindowMetricName(name, w)]
+			if !exists {
+				value = blankValue(cfg)
+			}
+			values = append(values, value)
+		}
+		separator := WindowSeparator
+		if strings.TrimSpace(strings.Join(values, "")) == "" {
+			// keep the cell empty for the devices without the metric
+			separator = strings.Repeat(" ", len(WindowSeparator))
+		}
+		data[name] = strings.Join(values, separator)
+	}
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/yasker/kstat/cfg"
+	"github.com/yasker/kstat/pkg/types"
+)
+
+const (
+	MetricsConfigFile  = "metrics.yaml"
+	MetricsFormatFile  = "metrics-format.yaml"
+	HeaderTemplateFile = "header.tmpl"
+	OutputTemplateFile = "output.tmpl"
+
+	SystemConfigDir = "/etc/kstat"
+	// OverlayDirSuffix is the suffix of the directory of the overlays of
+	// the yaml file, e.g. metrics.d for metrics.yaml
+	OverlayDirSuffix = ".d"
+	BuiltinSource    = "(built-in)"
+	XDGConfigHomeEnv = "XDG_CONFIG_HOME"
+)
+
+// SearchPath returns the directories of the configuration files, the later
+// ones override the earlier ones
+func SearchPath() []string {
+	dirs := []string{SystemConfigDir}
+	if xdg := os.Getenv(XDGConfigHomeEnv); xdg != "" {
+		return append(dirs, filepath.Join(xdg, "kstat"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".config", "kstat"))
+	}
+	return dirs
+}
+
+// Load returns the content of the configuration file, and where it's from.
+// The file is the one specified, or the last one found in the search path,
+// or the built-in default. A bare file name not found in the current
+// directory is looked up in the same way, e.g. header-bar.tmpl. The yaml
+// file is then merged with the overlays in the search path.
+func Load(name, file string) ([]byte, []string, error) {
+	data, source, err := loadBase(name, file)
+	if err != nil {
+		return nil, nil, err
+	}
+	sources := []string{source}
+	if filepath.Ext(name) != ".yaml" {
+		return data, sources, nil
+	}
+
+	for _, overlay := range overlayFiles(name) {
+		overlayData, err := ioutil.ReadFile(overlay)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "cannot read the overlay %v", overlay)
+		}
+		if data, err = merge(data, overlayData); err != nil {
+			return nil, nil, errors.Wrapf(err, "cannot merge the overlay %v into %v", overlay, source)
+		}
+		sources = append(sources, overlay)
+	}
+	return data, sources, nil
+}
+
+func loadBase(name, file string) ([]byte, string, error) {
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err == nil {
+			return data, file, nil
+		}
+		if !os.IsNotExist(err) || strings.ContainsRune(file, os.PathSeparator) {
+			return nil, "", errors.Wrapf(err, "cannot read the configuration file %v", file)
+		}
+		name = file
+	}
+
+	dirs := SearchPath()
+	for i := len(dirs) - 1; i >= 0; i-- {
+		path := filepath.Join(dirs[i], name)
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			return data, path, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, "", errors.Wrapf(err, "cannot read the configuration file %v", path)
+		}
+	}
+	data, err := cfg.FS.ReadFile(name)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot find the configuration file %v in %v or the built-in defaults", name, strings.Join(dirs, ", "))
+	}
+	return data, BuiltinSource, nil
+}
+
+// overlayFiles returns the yaml files in the overlay directories of the file
+// in the search path, e.g. ~/.config/kstat/metrics.d/*.yaml, in the order of
+// the directories and the names
+func overlayFiles(name string) []string {
+	overlays := []string{}
+	dir := strings.TrimSuffix(name, filepath.Ext(name)) + OverlayDirSuffix
+	for _, d := range SearchPath() {
+		files, err := filepath.Glob(filepath.Join(d, dir, "*.yaml"))
+		if err != nil {
+			continue
+		}
+		sort.Strings(files)
+		overlays = append(overlays, files...)
+	}
+	return overlays
+}
+
+// BuiltinFiles returns the names of the built-in configuration files,
+// including the ones of the profiles, e.g. pod/metrics.yaml
+func BuiltinFiles() []string {
+	names := []string{}
+	fs.WalkDir(cfg.FS, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if ext := filepath.Ext(path); ext == ".yaml" || ext == ".tmpl" {
+			names = append(names, path)
+		}
+		return nil
+	})
+	return names
+}
+
+// ProfileFile returns the name of the configuration file of the profile. The
+// files of the default profile are at the top, and the others are in the
+// directories of the profiles, e.g. pod/metrics.yaml.
+func ProfileFile(profile, name string) string {
+	if profile == "" || profile == types.DefaultProfile {
+		return name
+	}
+	return profile + "/" + name
+}
+
+// Profiles returns the default profile and the other profiles with the
+// metrics config, built-in or in the search path, in the name order
+func Profiles() []string {
+	found := map[string]bool{}
+	if entries, err := cfg.FS.ReadDir("."); err == nil {
+		for _, e := range entries {
+			if _, err := cfg.FS.ReadFile(ProfileFile(e.Name(), MetricsConfigFile)); e.IsDir() && err == nil {
+				found[e.Name()] = true
+			}
+		}
+	}
+	for _, dir := range SearchPath() {
+		files, err := filepath.Glob(filepath.Join(dir, "*", MetricsConfigFile))
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			// skip the overlays, e.g. metrics.d/metrics.yaml
+			if profile := filepath.Base(filepath.Dir(f)); !strings.HasSuffix(profile, OverlayDirSuffix) {
+				found[profile] = true
+			}
+		}
+	}
+	delete(found, types.DefaultProfile)
+
+	profiles := []string{}
+	for p := range found {
+		profiles = append(profiles, p)
+	}
+	sort.Strings(profiles)
+	return append([]string{types.DefaultProfile}, profiles...)
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// NameKey identifies the items of the lists to replace by the overlays,
+	// e.g. the metrics
+	NameKey = "name"
+)
+
+// merge returns the yaml document with the overlay merged in. The items of a
+// list with the same name are replaced, and the new ones are appended. The
+// maps are merged by the keys, e.g. vars, and the other values are replaced.
+func merge(base, overlay []byte) ([]byte, error) {
+	var baseValue, overlayValue interface{}
+	if err := yaml.Unmarshal(base, &baseValue); err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(overlay, &overlayValue); err != nil {
+		return nil, err
+	}
+	if overlayValue == nil {
+		return base, nil
+	}
+	switch baseValue.(type) {
+	case map[interface{}]interface{}:
+		baseMap := yaml.MapSlice{}
+		overlayMap := yaml.MapSlice{}
+		if err := yaml.Unmarshal(base, &baseMap); err != nil {
+			return nil, err
+		}
+		if _, ok := overlayValue.(map[interface{}]interface{}); !ok {
+			return nil, fmt.Errorf("the overlay must be a map like the file")
+		}
+		if err := yaml.Unmarshal(overlay, &overlayMap); err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(mergeMap(baseMap, overlayMap))
+	case []interface{}:
+		baseItems := []yaml.MapSlice{}
+		overlayItems := []yaml.MapSlice{}
+		if err := yaml.Unmarshal(base, &baseItems); err != nil {
+			return nil, err
+		}
+		if _, ok := overlayValue.([]interface{}); !ok {
+			return nil, fmt.Errorf("the overlay must be a list like the file")
+		}
+		if err := yaml.Unmarshal(overlay, &overlayItems); err != nil {
+			return nil, err
+		}
+		items := []interface{}{}
+		for _, item := range baseItems {
+			items = append(items, item)
+		}
+		for _, item := range overlayItems {
+			if _, exists := itemName(item); !exists {
+				return nil, fmt.Errorf("item %v of the overlay has no %v", item, NameKey)
+			}
+			items = mergeList(items, []interface{}{item})
+		}
+		return yaml.Marshal(items)
+	}
+	return nil, fmt.Errorf("the file is neither a map nor a list")
+}
+
+func mergeMap(base, overlay yaml.MapSlice) yaml.MapSlice {
+	for _, o := range overlay {
+		found := false
+		for i := range base {
+			if base[i].Key != o.Key {
+				continue
+			}
+			found = true
+			baseValue, baseIsMap := base[i].Value.(yaml.MapSlice)
+			overlayValue, overlayIsMap := o.Value.(yaml.MapSlice)
+			baseItems, baseIsList := base[i].Value.([]interface{})
+			overlayItems, overlayIsList := o.Value.([]interface{})
+			switch {
+			case baseIsMap && overlayIsMap:
+				base[i].Value = mergeMap(baseValue, overlayValue)
+			case baseIsList && overlayIsList && namedList(baseItems) && namedList(overlayItems):
+				base[i].Value = mergeList(baseItems, overlayItems)
+			default:
+				base[i].Value = o.Value
+			}
+			break
+		}
+		if !found {
+			base = append(base, o)
+		}
+	}
+	return base
+}
+
+// mergeList replaces the items with the same names, and appends the others
+func mergeList(base, overlay []interface{}) []interface{} {
+	for _, o := range overlay {
+		name, _ := itemName(o)
+		found := false
+		for i := range base {
+			if n, _ := itemName(base[i]); n == name {
+				base[i] = o
+				found = true
+				break
+			}
+		}
+		if !found {
+			base = append(base, o)
+		}
+	}
+	return base
+}
+
+func namedList(items []interface{}) bool {
+	for _, item := range items {
+		if _, exists := itemName(item); !exists {
+			return false
+		}
+	}
+	return true
+}
+
+func itemName(item interface{}) (interface{}, bool) {
+	m, ok := item.(yaml.MapSlice)
+	if !ok {
+		return nil, false
+	}
+	for _, kv := range m {
+		if kv.Key == NameKey {
+			return kv.Value, true
+		}
+	}
+	return nil, false
+}
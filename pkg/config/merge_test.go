@@ -0,0 +1,114 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name      string
+		base      string
+		overlay   string
+		want      string
+		wantError bool
+	}{
+		{
+			name:    "empty overlay",
+			base:    "vars:\n  Job: node-exporter\n",
+			overlay: "",
+			want:    "vars:\n  Job: node-exporter\n",
+		},
+		{
+			name:    "map merged by the keys",
+			base:    "vars:\n  Job: node-exporter\n  RateInterval: 1m\nmetrics: []\n",
+			overlay: "vars:\n  Job: node\n  ExcludeDevices: loop.*\n",
+			want:    "vars:\n  Job: node\n  RateInterval: 1m\n  ExcludeDevices: loop.*\nmetrics: []\n",
+		},
+		{
+			name: "named items replaced and appended",
+			base: `metrics:
+- name: cpu_user
+  scale: 100
+- name: cpu_idle
+  scale: 100
+`,
+			overlay: `metrics:
+- name: cpu_idle
+  scale: 1
+- name: gpu_util
+`,
+			want: `metrics:
+- name: cpu_user
+  scale: 100
+- name: cpu_idle
+  scale: 1
+- name: gpu_util
+`,
+		},
+		{
+			name:    "unnamed list replaced",
+			base:    "windows: [1m, 5m]\n",
+			overlay: "windows: [15m]\n",
+			want:    "windows:\n- 15m\n",
+		},
+		{
+			name:    "value replaced by a map",
+			base:    "vars: none\n",
+			overlay: "vars:\n  Job: node\n",
+			want:    "vars:\n  Job: node\n",
+		},
+		{
+			name:    "list file",
+			base:    "- name: cpu_user\n  shorthand: usr\n- name: cpu_idle\n  shorthand: idl\n",
+			overlay: "- name: cpu_user\n  shorthand: user\n- name: gpu_util\n  shorthand: gpu\n",
+			want:    "- name: cpu_user\n  shorthand: user\n- name: cpu_idle\n  shorthand: idl\n- name: gpu_util\n  shorthand: gpu\n",
+		},
+		{
+			name:      "unnamed item in the list file",
+			base:      "- name: cpu_user\n",
+			overlay:   "- shorthand: usr\n",
+			wantError: true,
+		},
+		{
+			name:      "list overlay of a map",
+			base:      "vars: {}\n",
+			overlay:   "- name: cpu_user\n",
+			wantError: true,
+		},
+		{
+			name:      "map overlay of a list",
+			base:      "- name: cpu_user\n",
+			overlay:   "vars: {}\n",
+			wantError: true,
+		},
+		{
+			name:      "scalar file",
+			base:      "kstat\n",
+			overlay:   "kstat\n",
+			wantError: true,
+		},
+		{
+			name:      "invalid overlay",
+			base:      "vars: {}\n",
+			overlay:   "vars: [\n",
+			wantError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := merge([]byte(tt.base), []byte(tt.overlay))
+			if tt.wantError {
+				if err == nil {
+					t.Fatalf("merged into %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got\n%v\nwant\n%v", string(got), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"code.cloudfoundry.org/bytefmt"
+)
+
+// Values returns the value of the metric, or false if it's not available
+type Values func(name string) (int64, bool)
+
+// Expression is a parsed filter expression, e.g.
+// `cpu_idle < 20 || disk_write > 50M`
+type Expression interface {
+	Eval(values Values) bool
+	// Metrics returns the metric names referred by the expression
+	Metrics() []string
+}
+
+type binaryExpr struct {
+	op    string
+	left  Expression
+	right Expression
+}
+
+type notExpr struct {
+	expr Expression
+}
+
+// operand is either a metric or a constant
+type operand struct {
+	metric string
+	value  int64
+}
+
+type compareExpr struct {
+	op    string
+	left  operand
+	right operand
+}
+
+func (e *binaryExpr) Eval(values Values) bool {
+	if e.op == "&&" {
+		return e.left.Eval(values) && e.right.Eval(values)
+	}
+	return e.left.Eval(values) || e.right.Eval(values)
+}
+
+func (e *binaryExpr) Metrics() []string {
+	return append(e.left.Metrics(), e.right.Metrics()...)
+}
+
+func (e *notExpr) Eval(values Values) bool {
+	return !e.expr.Eval(values)
+}
+
+func (e *notExpr) Metrics() []string {
+	return e.expr.Metrics()
+}
+
+func (o operand) get(values Values) (int64, bool) {
+	if o.metric == "" {
+		return o.value, true
+	}
+	return values(o.metric)
+}
+
+// Eval of a comparison is false if any of the metric is not available
+func (e *compareExpr) Eval(values Values) bool {
+	l, ok := e.left.get(values)
+	if !ok {
+		return false
+	}
+	r, ok := e.right.get(values)
+	if !ok {
+		return false
+	}
+	switch e.op {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	case ">=":
+		return l >= r
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	}
+	return false
+}
+
+func (e *compareExpr) Metrics() []string {
+	metrics := []string{}
+	for _, o := range []operand{e.left, e.right} {
+		if o.metric != "" {
+			metrics = append(metrics, o.metric)
+		}
+	}
+	return metrics
+}
+
+type token struct {
+	kind  string
+	value string
+	pos   int
+}
+
+const (
+	tokenIdent  = "identifier"
+	tokenNumber = "number"
+	tokenOp     = "operator"
+	tokenEOF    = "end of expression"
+)
+
+var operators = []string{"&&", "||", "<=", ">=", "==", "!=", "<", ">", "!", "(", ")"}
+
+func tokenize(s string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(s); {
+		r := rune(s[i])
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(s) && (unicode.IsLetter(rune(s[i])) || unicode.IsDigit(rune(s[i])) || s[i] == '_' || s[i] == ':') {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, s[start:i], start})
+		case unicode.IsDigit(r) || r == '.':
+			start := i
+			for i < len(s) && (unicode.IsDigit(rune(s[i])) || s[i] == '.') {
+				i++
+			}
+			// the unit suffix, e.g. 50M, 1.5GiB or 20%
+			for i < len(s) && (unicode.IsLetter(rune(s[i])) || s[i] == '%') {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, s[start:i], start})
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(s[i:], op) {
+					tokens = append(tokens, token{tokenOp, op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at %d", s[i], i)
+			}
+		}
+	}
+	return append(tokens, token{tokenEOF, "", len(s)}), nil
+}
+
+// parseNumber parses the number with the optional unit. The size units are
+// in power of 1024 as the rest of kstat.
+func parseNumber(s string) (int64, error) {
+	s = strings.TrimSuffix(s, "%")
+	end := 0
+	for end < len(s) && (unicode.IsDigit(rune(s[end])) || s[end] == '.') {
+		end++
+	}
+	if end == len(s) {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, err
+		}
+		return int64(v), nil
+	}
+	v, err := bytefmt.ToBytes(s)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses the expression. The grammar is:
+//
+//	expr       := and ( "||" and )*
+//	and        := unary ( "&&" unary )*
+//	unary      := "!" unary | "(" expr ")" | comparison
+//	comparison := operand ( "<" | "<=" | ">" | ">=" | "==" | "!=" ) operand
+//	operand    := metric | number[unit]
+func Parse(s string) (Expression, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", s, err)
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %v", s, err)
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("invalid expression %q: unexpected %q at %d", s, t.value, t.pos)
+	}
+	return expr, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) parseOr() (Expression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOp && p.peek().value == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokenOp && p.peek().value == "&&" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Expression, error) {
+	t := p.peek()
+	if t.kind == tokenOp && t.value == "!" {
+		p.next()
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{expr: expr}, nil
+	}
+	if t.kind == tokenOp && t.value == "(" {
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenOp || t.value != ")" {
+			return nil, fmt.Errorf("expect ) at %d", t.pos)
+		}
+		return expr, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (Expression, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.next()
+	switch t.value {
+	case "<", "<=", ">", ">=", "==", "!=":
+	default:
+		return nil, fmt.Errorf("expect comparison operator at %d", t.pos)
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return &compareExpr{op: t.value, left: left, right: right}, nil
+}
+
+func (p *parser) parseOperand() (operand, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenIdent:
+		return operand{metric: t.value}, nil
+	case tokenNumber:
+		v, err := parseNumber(t.value)
+		if err != nil {
+			return operand{}, fmt.Errorf("invalid number %q at %d", t.value, t.pos)
+		}
+		return operand{value: v}, nil
+	}
+	return operand{}, fmt.Errorf("expect metric or number at %d", t.pos)
+}
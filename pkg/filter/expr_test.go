@@ -0,0 +1,97 @@
+package filter
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestParseEval(t *testing.T) {
+	values := func(name string) (int64, bool) {
+		v, exists := map[string]int64{
+			"cpu_idle":   15,
+			"cpu_user":   60,
+			"disk_write": 60 * 1024 * 1024,
+		}[name]
+		return v, exists
+	}
+	tests := []struct {
+		expr    string
+		want    bool
+		metrics []string
+	}{
+		{"cpu_idle < 20", true, []string{"cpu_idle"}},
+		{"cpu_idle >= 20", false, []string{"cpu_idle"}},
+		{"20 > cpu_idle", true, []string{"cpu_idle"}},
+		{"cpu_user == 60 && cpu_idle != 15", false, []string{"cpu_idle", "cpu_user"}},
+		{"cpu_idle < 20 || disk_write > 50M", true, []string{"cpu_idle", "disk_write"}},
+		{"disk_write > 1G", false, []string{"disk_write"}},
+		{"cpu_user > 50%", true, []string{"cpu_user"}},
+		{"!(cpu_idle < 20)", false, []string{"cpu_idle"}},
+		{"!cpu_idle < 20 || cpu_user <= 60", true, []string{"cpu_idle", "cpu_user"}},
+		// && binds tighter than ||
+		{"cpu_user > 100 && cpu_idle < 20 || cpu_user > 50", true, []string{"cpu_idle", "cpu_user", "cpu_user"}},
+		{"cpu_user > 100 && (cpu_idle < 20 || cpu_user > 50)", false, []string{"cpu_idle", "cpu_user", "cpu_user"}},
+		// a comparison of the metric not available is false
+		{"mem_avail < 1G", false, []string{"mem_avail"}},
+		{"!(mem_avail < 1G)", true, []string{"mem_avail"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := expr.Eval(values); got != tt.want {
+				t.Errorf("Eval() = %v, want %v", got, tt.want)
+			}
+			metrics := expr.Metrics()
+			sort.Strings(metrics)
+			if !reflect.DeepEqual(metrics, tt.metrics) {
+				t.Errorf("Metrics() = %v, want %v", metrics, tt.metrics)
+			}
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"cpu_idle",
+		"cpu_idle <",
+		"cpu_idle < 20 &&",
+		"(cpu_idle < 20",
+		"cpu_idle < 20)",
+		"cpu_idle = 20",
+		"cpu_idle < 20 cpu_user > 1",
+		"cpu_idle < 1X",
+		"cpu_idle < 20 # comment",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	names := []string{"cpu_idle", "cpu_user", "disk_write"}
+	tests := []struct {
+		where string
+		valid bool
+	}{
+		{"", true},
+		{"cpu_idle < 20", true},
+		{"cpu_idle < 20 || disk_write > 50M", true},
+		{"cpu_idel < 20", false},
+		{"cpu_idle < 20 && !(mem_avail < 1G)", false},
+	}
+	for _, tt := range tests {
+		f, err := NewFilter(tt.where, "", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Validate(names); (err == nil) != tt.valid {
+			t.Errorf("Validate(%q) = %v, want valid %v", tt.where, err, tt.valid)
+		}
+	}
+}
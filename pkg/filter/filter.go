@@ -0,0 +1,303 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	pb "github.com/yasker/kstat/pkg/pb/v1"
+	"github.com/yasker/kstat/pkg/types"
+)
+
+// Filter selects the instances and devices to show. An instance is selected
+// if its name matches the instance regex and its summarized values satisfy
+// the where expression. A device of the selected instance is selected if its
+// name matches the device regex and its values satisfy the where expression,
+// in which the metrics not available for the device use the values of the
+// instance. The label selector selects the devices by the labels of their
+// series, and the instances without devices by the labels of the instances.
+type Filter struct {
+	Where         string
+	InstanceRegex string
+	DeviceRegex   string
+	LabelSelector string
+
+	where    Expression
+	instance *regexp.Regexp
+	device   *regexp.Regexp
+	selector Selector
+}
+
+func NewFilter(where, instanceRegex, deviceRegex, labelSelector string) (*Filter, error) {
+	var err error
+
+	f := &Filter{
+		Where:         where,
+		InstanceRegex: instanceRegex,
+		DeviceRegex:   deviceRegex,
+		LabelSelector: labelSelector,
+	}
+	if where != "" {
+		if f.where, err = Parse(where); err != nil {
+			return nil, err
+		}
+	}
+	if instanceRegex != "" {
+		if f.instance, err = regexp.Compile(instanceRegex); err != nil {
+			return nil, errors.Wrapf(err, "invalid instance regex %v", instanceRegex)
+		}
+	}
+	if deviceRegex != "" {
+		if f.device, err = regexp.Compile(deviceRegex); err != nil {
+			return nil, errors.Wrapf(err, "invalid device regex %v", deviceRegex)
+		}
+	}
+	if labelSelector != "" {
+		if f.selector, err = ParseSelector(labelSelector); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func FromPB(f *pb.Filter) (*Filter, error) {
+	if f == nil {
+		return NewFilter("", "", "", "")
+	}
+	return NewFilter(f.Where, f.InstanceRegex, f.DeviceRegex, f.LabelSelector)
+}
+
+func (f *Filter) ToPB() *pb.Filter {
+	if f == nil {
+		return nil
+	}
+	return &pb.Filter{
+		Where:         f.Where,
+		InstanceRegex: f.InstanceRegex,
+		DeviceRegex:   f.DeviceRegex,
+		LabelSelector: f.LabelSelector,
+	}
+}
+
+// WithoutInstanceRegex returns the copy of the filter without the instance
+// regex, e.g. for the servers which don't know the full instance names
+func (f *Filter) WithoutInstanceRegex() *Filter {
+	if f == nil {
+		return nil
+	}
+	result := *f
+	result.InstanceRegex = ""
+	result.instance = nil
+	return &result
+}
+
+// WithoutWhere returns the copy of the filter without the where expression,
+// e.g. for the client to evaluate it on the smoothed values
+func (f *Filter) WithoutWhere() *Filter {
+	if f == nil {
+		return nil
+	}
+	result := *f
+	result.Where = ""
+	result.where = nil
+	return &result
+}
+
+// WithInstance returns the copy of the filter which only selects the
+// instance, e.g. for the detail view of it
+func (f *Filter) WithInstance(inst string) *Filter {
+	result := Filter{}
+	if f != nil {
+		result = *f
+	}
+	result.InstanceRegex = "^" + regexp.QuoteMeta(inst) + "$"
+	result.instance = regexp.MustCompile(result.InstanceRegex)
+	return &result
+}
+
+// Validate checks the metrics in the where expression are in the names, so
+// a typo doesn't silently hide everything
+func (f *Filter) Validate(names []string) error {
+	if f == nil || f.where == nil {
+		return nil
+	}
+	known := map[string]bool{}
+	for _, name := range names {
+		known[name] = true
+	}
+	for _, name := range f.where.Metrics() {
+		if !known[name] {
+			sorted := append([]string{}, names...)
+			sort.Strings(sorted)
+			return fmt.Errorf("unknown metric %v in the where expression, must be one of %v", name, strings.Join(sorted, ","))
+		}
+	}
+	return nil
+}
+
+// ValidateLabels checks the labels in the selector are in the names of the
+// labels of the series, so a label the series don't have doesn't silently
+// hide everything. The labels cannot be checked without any names, e.g. if
+// there is no series.
+func (f *Filter) ValidateLabels(names []string) error {
+	if f == nil || f.selector == nil || len(names) == 0 {
+		return nil
+	}
+	known := map[string]bool{}
+	for _, name := range names {
+		known[name] = true
+	}
+	for _, name := range f.selector.Labels() {
+		if !known[name] {
+			sorted := append([]string{}, names...)
+			sort.Strings(sorted)
+			return fmt.Errorf("unknown label %v in the selector, must be one of %v", name, strings.Join(sorted, ","))
+		}
+	}
+	return nil
+}
+
+func (f *Filter) IsEmpty() bool {
+	return f == nil || (f.where == nil && f.instance == nil && f.device == nil && f.selector == nil)
+}
+
+// Apply returns the selected metrics without modifying the original ones.
+// The value types of the metrics decide the summarized instance values.
+func (f *Filter) Apply(metrics map[string]*types.ClusterMetric, valueTypes map[string]string) map[string]*types.ClusterMetric {
+	return f.ApplyBy(metrics, metrics, valueTypes)
+}
+
+// ApplyBy returns the selected metrics like Apply, but evaluates the where
+// expression by the values of the same instances and devices in the other
+// metrics, e.g. the smoothed values shown by the client
+func (f *Filter) ApplyBy(metrics, values map[string]*types.ClusterMetric, valueTypes map[string]string) map[string]*types.ClusterMetric {
+	if f.IsEmpty() {
+		return metrics
+	}
+
+	instanceValues := func(inst string) Values {
+		return func(name string) (int64, bool) {
+			cm := values[name]
+			if cm == nil || cm.InstanceMetrics[inst] == nil {
+				return 0, false
+			}
+			return cm.InstanceMetrics[inst].Summary(valueTypes[name]), true
+		}
+	}
+	deviceValues := func(inst, dev string) Values {
+		fallback := instanceValues(inst)
+		return func(name string) (int64, bool) {
+			if cm := values[name]; cm != nil && cm.InstanceMetrics[inst] != nil {
+				if v, exists := cm.InstanceMetrics[inst].DeviceMetrics[dev]; exists {
+					return v, true
+				}
+			}
+			return fallback(name)
+		}
+	}
+
+	selectedInstances := map[string]bool{}
+	selectedDevices := map[string]bool{}
+	for _, cm := range metrics {
+		for inst, im := range cm.InstanceMetrics {
+			selected, checked := selectedInstances[inst]
+			if !checked {
+				selected = f.instance == nil || f.instance.MatchString(inst)
+				if selected && f.where != nil {
+					selected = f.where.Eval(instanceValues(inst))
+				}
+				selectedInstances[inst] = selected
+			}
+			if !selected {
+				continue
+			}
+			for dev := range im.DeviceMetrics {
+				key := inst + "/" + dev
+				if _, checked := selectedDevices[key]; checked {
+					continue
+				}
+				selected := f.device == nil || f.device.MatchString(dev)
+				if selected && f.where != nil {
+					selected = f.where.Eval(deviceValues(inst, dev))
+				}
+				selectedDevices[key] = selected
+			}
+		}
+	}
+	if f.selector != nil {
+		f.applySelector(metrics, selectedInstances, selectedDevices)
+	}
+
+	result := map[string]*types.ClusterMetric{}
+	for name, cm := range metrics {
+		fcm := &types.ClusterMetric{
+			InstanceMetrics: map[string]*types.InstanceMetric{},
+		}
+		for inst, im := range cm.InstanceMetrics {
+			if !selectedInstances[inst] {
+				continue
+			}
+			fim := *im
+			if im.DeviceMetrics != nil {
+				fim.DeviceMetrics = map[string]int64{}
+				fim.DeviceLabels = map[string]map[string]string{}
+				for dev, v := range im.DeviceMetrics {
+					if selectedDevices[inst+"/"+dev] {
+						fim.DeviceMetrics[dev] = v
+						fim.DeviceLabels[dev] = im.DeviceLabels[dev]
+					}
+				}
+			}
+			fcm.InstanceMetrics[inst] = &fim
+		}
+		result[name] = fcm
+	}
+	return result
+}
+
+// applySelector unselects the devices of which the labels don't match the
+// selector in any metric, and the instances without the matched devices. The
+// instances without any device are matched by their own labels.
+func (f *Filter) applySelector(metrics map[string]*types.ClusterMetric, selectedInstances, selectedDevices map[string]bool) {
+	matchedInstances := map[string]bool{}
+	matchedDevices := map[string]bool{}
+	for _, cm := range metrics {
+		for inst, im := range cm.InstanceMetrics {
+			if !selectedInstances[inst] {
+				continue
+			}
+			if len(im.DeviceMetrics) == 0 {
+				if f.selector.Matches(im.Labels) {
+					matchedInstances[inst] = true
+				}
+				continue
+			}
+			for dev := range im.DeviceMetrics {
+				key := inst + "/" + dev
+				if matchedDevices[key] || !selectedDevices[key] {
+					continue
+				}
+				labels := map[string]string{}
+				for k, v := range im.Labels {
+					labels[k] = v
+				}
+				for k, v := range im.DeviceLabels[dev] {
+					labels[k] = v
+				}
+				if f.selector.Matches(labels) {
+					matchedDevices[key] = true
+					matchedInstances[inst] = true
+				}
+			}
+		}
+	}
+	for key := range selectedDevices {
+		selectedDevices[key] = matchedDevices[key]
+	}
+	for inst := range selectedInstances {
+		selectedInstances[inst] = matchedInstances[inst]
+	}
+}
@@ -0,0 +1,51 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+func TestWithInstance(t *testing.T) {
+	metrics := map[string]*types.ClusterMetric{
+		"cpu_user": {
+			InstanceMetrics: map[string]*types.InstanceMetric{
+				"node-1":  {Value: 10, Average: 10},
+				"node-10": {Value: 20, Average: 20},
+				"node.1":  {Value: 30, Average: 30},
+			},
+		},
+	}
+	where, err := NewFilter("cpu_user > 15", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		f    *Filter
+		inst string
+		want []string
+	}{
+		{"no filter", nil, "node-1", []string{"node-1"}},
+		{"not a regex", nil, "node.1", []string{"node.1"}},
+		{"with where", where, "node-10", []string{"node-10"}},
+		{"unselected by where", where, "node-1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.f.WithInstance(tt.inst).Apply(metrics, map[string]string{"cpu_user": types.ValueTypeCPU})
+			got := result["cpu_user"].InstanceMetrics
+			if len(got) != len(tt.want) {
+				t.Fatalf("got the instances %v, want %v", got, tt.want)
+			}
+			for _, inst := range tt.want {
+				if got[inst] == nil {
+					t.Errorf("instance %v is not selected", inst)
+				}
+			}
+		})
+	}
+	if where.InstanceRegex != "" {
+		t.Errorf("the original filter is modified")
+	}
+}
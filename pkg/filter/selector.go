@@ -0,0 +1,107 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	MatchEqual     = "="
+	MatchNotEqual  = "!="
+	MatchRegex     = "=~"
+	MatchNotRegex  = "!~"
+	MatchSeparator = ","
+)
+
+// Selector matches the labels of the series of the instances and devices,
+// e.g. `namespace=kube-system,pod=~coredns.*`. The operators are the same as
+// the label matchers of Prometheus, and the regex must match the whole value.
+// A label which doesn't exist has the empty value.
+type Selector []*matcher
+
+type matcher struct {
+	name  string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+// ParseSelector parses the comma separated label matchers
+func ParseSelector(s string) (Selector, error) {
+	selector := Selector{}
+	for _, part := range strings.Split(s, MatchSeparator) {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		m, err := parseMatcher(part)
+		if err != nil {
+			return nil, err
+		}
+		selector = append(selector, m)
+	}
+	if len(selector) == 0 {
+		return nil, fmt.Errorf("empty selector %q", s)
+	}
+	return selector, nil
+}
+
+func parseMatcher(s string) (*matcher, error) {
+	i := strings.IndexAny(s, "=!")
+	if i <= 0 {
+		return nil, fmt.Errorf("invalid label matcher %q, e.g. namespace=default", s)
+	}
+	m := &matcher{name: strings.TrimSpace(s[:i])}
+	rest := s[i:]
+	for _, op := range []string{MatchNotEqual, MatchRegex, MatchNotRegex, MatchEqual} {
+		if strings.HasPrefix(rest, op) {
+			m.op = op
+			break
+		}
+	}
+	if m.op == "" {
+		return nil, fmt.Errorf("invalid operator in the label matcher %q", s)
+	}
+	m.value = strings.Trim(strings.TrimSpace(rest[len(m.op):]), `"`)
+	if m.op == MatchRegex || m.op == MatchNotRegex {
+		re, err := regexp.Compile("^(?:" + m.value + ")$")
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid regex in the label matcher %q", s)
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+// Labels returns the names of the labels in the matchers
+func (s Selector) Labels() []string {
+	names := []string{}
+	for _, m := range s {
+		names = append(names, m.name)
+	}
+	return names
+}
+
+// Matches returns true if the labels satisfy all the matchers
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, m := range s {
+		value := labels[m.name]
+		matched := false
+		switch m.op {
+		case MatchEqual:
+			matched = value == m.value
+		case MatchNotEqual:
+			matched = value != m.value
+		case MatchRegex:
+			matched = m.re.MatchString(value)
+		case MatchNotRegex:
+			matched = !m.re.MatchString(value)
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
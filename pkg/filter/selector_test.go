@@ -0,0 +1,167 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{
+		"namespace": "kube-system",
+		"pod":       "coredns-558bd4d5db-7x9zq",
+	}
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{"namespace=kube-system", true},
+		{"namespace=default", false},
+		{"namespace!=default", true},
+		{"namespace!=kube-system", false},
+		{"pod=~coredns.*", true},
+		{"pod=~coredns", false},
+		{"pod!~coredns.*", false},
+		{"pod!~etcd.*", true},
+		{`namespace="kube-system"`, true},
+		{" namespace = kube-system ", true},
+		{"namespace=kube-system,pod=~coredns.*", true},
+		{"namespace=kube-system,pod=~etcd.*", false},
+		{"namespace=kube-system,,", true},
+		{"app=", true},
+		{"app=nginx", false},
+		{"app!=nginx", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			s, err := ParseSelector(tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Matches(labels); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSelectorInvalid(t *testing.T) {
+	for _, selector := range []string{
+		"",
+		",",
+		"namespace",
+		"=default",
+		"namespace=~(",
+		"namespace!default",
+	} {
+		t.Run(selector, func(t *testing.T) {
+			if s, err := ParseSelector(selector); err == nil {
+				t.Errorf("got the selector %v, want the error", s)
+			}
+		})
+	}
+}
+
+func TestFilterValidateLabels(t *testing.T) {
+	names := []string{"instance", "namespace", "namespace_pod", "pod"}
+	tests := []struct {
+		name     string
+		selector string
+		names    []string
+		wantErr  bool
+	}{
+		{"no selector", "", names, false},
+		{"known", "namespace=kube-system,pod=~coredns.*", names, false},
+		{"unknown", "app=nginx", names, true},
+		{"one unknown", "namespace=default,app!=nginx", names, true},
+		{"no series", "app=nginx", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFilter("", "", "", tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := f.ValidateLabels(tt.names); (err != nil) != tt.wantErr {
+				t.Errorf("got the error %v, want the error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterApplySelector(t *testing.T) {
+	metrics := map[string]*types.ClusterMetric{
+		"pod_cpu": {
+			InstanceMetrics: map[string]*types.InstanceMetric{
+				"node-1": {
+					Labels: map[string]string{"instance": "node-1"},
+					DeviceMetrics: map[string]int64{
+						"pod: kube-system/coredns": 10,
+						"pod: default/nginx":       20,
+					},
+					DeviceLabels: map[string]map[string]string{
+						"pod: kube-system/coredns": {"namespace": "kube-system", "pod": "coredns"},
+						"pod: default/nginx":       {"namespace": "default", "pod": "nginx"},
+					},
+				},
+				"node-2": {
+					Labels:        map[string]string{"instance": "node-2"},
+					DeviceMetrics: map[string]int64{"pod: default/web": 30},
+					DeviceLabels: map[string]map[string]string{
+						"pod: default/web": {"namespace": "default", "pod": "web"},
+					},
+				},
+			},
+		},
+		"cpu_user": {
+			InstanceMetrics: map[string]*types.InstanceMetric{
+				"node-1": {Value: 40, Labels: map[string]string{"instance": "node-1"}},
+				"node-2": {Value: 50, Labels: map[string]string{"instance": "node-2"}},
+			},
+		},
+	}
+	valueTypes := map[string]string{"pod_cpu": types.ValueTypeCPU, "cpu_user": types.ValueTypeCPU}
+	tests := []struct {
+		selector string
+		want     map[string][]string
+	}{
+		{"namespace=kube-system", map[string][]string{"node-1": {"pod: kube-system/coredns"}}},
+		{"namespace=default", map[string][]string{
+			"node-1": {"pod: default/nginx"},
+			"node-2": {"pod: default/web"},
+		}},
+		{"instance=node-2", map[string][]string{"node-2": {"pod: default/web"}}},
+		{"namespace=monitoring", map[string][]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			f, err := NewFilter("", "", "", tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := f.Apply(metrics, valueTypes)
+			got := result["pod_cpu"].InstanceMetrics
+			if len(got) != len(tt.want) {
+				t.Fatalf("got the instances %v, want %v", got, tt.want)
+			}
+			for inst, devs := range tt.want {
+				if got[inst] == nil {
+					t.Fatalf("instance %v is not selected", inst)
+				}
+				if len(got[inst].DeviceMetrics) != len(devs) {
+					t.Errorf("got the devices %v of %v, want %v", got[inst].DeviceMetrics, inst, devs)
+				}
+				for _, dev := range devs {
+					if _, exists := got[inst].DeviceMetrics[dev]; !exists {
+						t.Errorf("device %v of %v is not selected", dev, inst)
+					}
+				}
+				// the instance without devices is selected with the
+				// instance selected by the devices
+				if result["cpu_user"].InstanceMetrics[inst] == nil {
+					t.Errorf("instance %v of the metric without devices is not selected", inst)
+				}
+			}
+		})
+	}
+}
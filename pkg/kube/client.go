@@ -0,0 +1,318 @@
+package kube
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+const (
+	DefaultNamespace = "kstat-system"
+	DefaultService   = "kstat"
+	// DefaultPortName is the port of the service to use if the service has
+	// more than one port
+	DefaultPortName = "grpc"
+
+	PodPhaseRunning    = "Running"
+	PodConditionReady  = "Ready"
+	ConditionTrue      = "True"
+	maxErrorBodyLength = 1024
+)
+
+// Client is a minimal client of the Kubernetes apiserver, to find the kstat
+// server without depending on client-go
+type Client struct {
+	cfg        *Config
+	tlsConfig  *tls.Config
+	httpClient *http.Client
+}
+
+// Service is the part of the Kubernetes Service used to find the pods
+type Service struct {
+	Metadata ObjectMeta `json:"metadata"`
+	Spec     struct {
+		Selector map[string]string `json:"selector"`
+		Ports    []ServicePort     `json:"ports"`
+	} `json:"spec"`
+}
+
+type ServicePort struct {
+	Name       string      `json:"name"`
+	Port       int         `json:"port"`
+	TargetPort IntOrString `json:"targetPort"`
+}
+
+// Pod is the part of the Kubernetes Pod used to forward the port to
+type Pod struct {
+	Metadata ObjectMeta `json:"metadata"`
+	Spec     struct {
+		Containers []struct {
+			Name  string `json:"name"`
+			Ports []struct {
+				Name          string `json:"name"`
+				ContainerPort int    `json:"containerPort"`
+			} `json:"ports"`
+		} `json:"containers"`
+	} `json:"spec"`
+	Status struct {
+		Phase      string `json:"phase"`
+		Conditions []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+type PodList struct {
+	Items []Pod `json:"items"`
+}
+
+type ObjectMeta struct {
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace"`
+	Labels    map[string]string `json:"labels"`
+}
+
+// IntOrString is the port number or the port name
+type IntOrString struct {
+	IntVal int
+	StrVal string
+}
+
+func (v *IntOrString) UnmarshalJSON(data []byte) error {
+	if len(data) != 0 && data[0] == '"' {
+		return json.Unmarshal(data, &v.StrVal)
+	}
+	return json.Unmarshal(data, &v.IntVal)
+}
+
+func (v IntOrString) MarshalJSON() ([]byte, error) {
+	if v.StrVal != "" {
+		return json.Marshal(v.StrVal)
+	}
+	return json.Marshal(v.IntVal)
+}
+
+func NewClient(cfg *Config) *Client {
+	tlsConfig := cfg.tlsConfig.Clone()
+	if cfg.exec != nil {
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			_, cert, err := cfg.exec.get()
+			if err != nil {
+				return nil, err
+			}
+			if cert == nil {
+				cert = &tls.Certificate{}
+			}
+			return cert, nil
+		}
+	}
+	return &Client{
+		cfg:       cfg,
+		tlsConfig: tlsConfig,
+		httpClient: &http.Client{
+			Timeout: types.KubeRequestTimeout,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}
+}
+
+// authorize sets the credential of the user on the request headers
+func (c *Client) authorize(header http.Header) error {
+	token := c.cfg.token
+	if c.cfg.exec != nil {
+		execToken, _, err := c.cfg.exec.get()
+		if err != nil {
+			return err
+		}
+		if execToken != "" {
+			token = execToken
+		}
+	}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	} else if c.cfg.username != "" {
+		req := &http.Request{Header: header}
+		req.SetBasicAuth(c.cfg.username, c.cfg.password)
+	}
+	return nil
+}
+
+// Do sends the request to the API path, and decodes the response into the
+// result if not nil
+func (c *Client) Do(ctx context.Context, method, path string, query url.Values, body, result interface{}) error {
+	return c.do(ctx, method, path, query, "application/json", body, result)
+}
+
+// do sends the body encoded in json, which is valid yaml as well, with the
+// content type, e.g. the apply patch
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, contentType string, body, result interface{}) error {
+	u := c.cfg.Server + path
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return errors.Wrapf(err, "cannot encode the request to %v", path)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return errors.Wrapf(err, "invalid request to %v", path)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if err := c.authorize(req.Header); err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to %v %v", method, path)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read the response of %v %v", method, path)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &StatusError{Code: resp.StatusCode, Message: statusMessage(data)}
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, result); err != nil {
+		return errors.Wrapf(err, "cannot decode the response of %v %v", method, path)
+	}
+	return nil
+}
+
+// StatusError is the error returned by the apiserver
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%v: %v", http.StatusText(e.Code), e.Message)
+}
+
+// IsNotFound returns if the object doesn't exist
+func IsNotFound(err error) bool {
+	statusErr, ok := errors.Cause(err).(*StatusError)
+	return ok && statusErr.Code == http.StatusNotFound
+}
+
+// statusMessage returns the message of the Status returned by the apiserver,
+// or the beginning of the body if it's not a Status
+func statusMessage(data []byte) string {
+	status := struct {
+		Message string `json:"message"`
+	}{}
+	if err := json.Unmarshal(data, &status); err == nil && status.Message != "" {
+		return status.Message
+	}
+	if len(data) > maxErrorBodyLength {
+		data = data[:maxErrorBodyLength]
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func (c *Client) GetService(ctx context.Context, namespace, name string) (*Service, error) {
+	svc := &Service{}
+	path := fmt.Sprintf("/api/v1/namespaces/%v/services/%v", url.PathEscape(namespace), url.PathEscape(name))
+	if err := c.Do(ctx, http.MethodGet, path, nil, nil, svc); err != nil {
+		return nil, errors.Wrapf(err, "cannot get service %v/%v", namespace, name)
+	}
+	return svc, nil
+}
+
+// ListPods returns the pods matching all the labels, in the order of the
+// names
+func (c *Client) ListPods(ctx context.Context, namespace string, labels map[string]string) ([]Pod, error) {
+	selector := []string{}
+	for k, v := range labels {
+		selector = append(selector, k+"="+v)
+	}
+	sort.Strings(selector)
+
+	pods := &PodList{}
+	path := fmt.Sprintf("/api/v1/namespaces/%v/pods", url.PathEscape(namespace))
+	query := url.Values{"labelSelector": []string{strings.Join(selector, ",")}}
+	if err := c.Do(ctx, http.MethodGet, path, query, nil, pods); err != nil {
+		return nil, errors.Wrapf(err, "cannot list pods in namespace %v", namespace)
+	}
+	sort.Slice(pods.Items, func(i, j int) bool { return pods.Items[i].Metadata.Name < pods.Items[j].Metadata.Name })
+	return pods.Items, nil
+}
+
+// isReady returns if the pod is running and ready to serve
+func (p *Pod) isReady() bool {
+	if p.Status.Phase != PodPhaseRunning {
+		return false
+	}
+	for _, cond := range p.Status.Conditions {
+		if cond.Type == PodConditionReady {
+			return cond.Status == ConditionTrue
+		}
+	}
+	return false
+}
+
+// containerPort returns the number of the target port of the service in the
+// pod, which can be a named container port
+func (p *Pod) containerPort(port ServicePort) (int, error) {
+	if port.TargetPort.StrVal != "" {
+		if n, err := strconv.Atoi(port.TargetPort.StrVal); err == nil {
+			return n, nil
+		}
+		for _, container := range p.Spec.Containers {
+			for _, cp := range container.Ports {
+				if cp.Name == port.TargetPort.StrVal {
+					return cp.ContainerPort, nil
+				}
+			}
+		}
+		return 0, fmt.Errorf("pod %v has no port named %v", p.Metadata.Name, port.TargetPort.StrVal)
+	}
+	if port.TargetPort.IntVal != 0 {
+		return port.TargetPort.IntVal, nil
+	}
+	return port.Port, nil
+}
+
+// servicePort returns the port of the service named DefaultPortName, or the
+// only port of the service
+func servicePort(svc *Service) (ServicePort, error) {
+	for _, port := range svc.Spec.Ports {
+		if port.Name == DefaultPortName {
+			return port, nil
+		}
+	}
+	if len(svc.Spec.Ports) == 1 {
+		return svc.Spec.Ports[0], nil
+	}
+	return ServicePort{}, fmt.Errorf("service %v/%v has no port named %v", svc.Metadata.Namespace, svc.Metadata.Name, DefaultPortName)
+}
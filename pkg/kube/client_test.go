@@ -0,0 +1,169 @@
+package kube
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+const testToken = "test-token"
+
+// newTestClient returns the client of the fake apiserver, loaded from the
+// kubeconfig like the real one
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+	apiserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"message": "Unauthorized"})
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(apiserver.Close)
+
+	dir := t.TempDir()
+	file := writeKubeconfig(t, dir, "config", `
+current-context: test
+clusters:
+- name: test
+  cluster: {server: "`+apiserver.URL+`"}
+users:
+- name: test
+  user: {token: `+testToken+`}
+contexts:
+- name: test
+  context: {cluster: test, user: test}
+`)
+	cfg, err := LoadConfig([]string{filepath.Join(dir, "missing"), file}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewClient(cfg)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func TestGetService(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/namespaces/kstat-system/services/kstat", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"metadata": map[string]string{"name": "kstat", "namespace": "kstat-system"},
+			"spec": map[string]interface{}{
+				"selector": map[string]string{"app": "kstat"},
+				"ports": []map[string]interface{}{
+					{"name": "metrics", "port": 9090, "targetPort": 9090},
+					{"name": "grpc", "port": 80, "targetPort": "grpc"},
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/api/v1/namespaces/kstat-system/services/missing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		writeJSON(w, map[string]string{"kind": "Status", "message": `services "missing" not found`})
+	})
+	c := newTestClient(t, mux)
+
+	svc, err := c.GetService(context.Background(), DefaultNamespace, DefaultService)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(svc.Spec.Selector, map[string]string{"app": "kstat"}) {
+		t.Errorf("got the selector %v", svc.Spec.Selector)
+	}
+	port, err := servicePort(svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port.Port != 80 || port.TargetPort.StrVal != "grpc" {
+		t.Errorf("got the port %+v, want the grpc port", port)
+	}
+
+	_, err = c.GetService(context.Background(), DefaultNamespace, "missing")
+	if !IsNotFound(err) {
+		t.Errorf("got %v, want not found", err)
+	}
+	if err != nil && err.Error() != `cannot get service kstat-system/missing: Not Found: services "missing" not found` {
+		t.Errorf("got the error %q without the message of the status", err)
+	}
+}
+
+func TestUnauthorized(t *testing.T) {
+	c := newTestClient(t, http.NotFoundHandler())
+	c.cfg.token = "wrong"
+	_, err := c.GetService(context.Background(), DefaultNamespace, DefaultService)
+	if err == nil || IsNotFound(err) {
+		t.Errorf("got %v, want unauthorized", err)
+	}
+}
+
+func TestListPods(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/namespaces/kstat-system/pods", func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("labelSelector"); got != "app=kstat,tier=server" {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"message": "unexpected selector " + got})
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"items": []map[string]interface{}{
+				{"metadata": map[string]string{"name": "kstat-b"}, "status": map[string]string{"phase": "Pending"}},
+				{"metadata": map[string]string{"name": "kstat-a"}, "status": map[string]interface{}{
+					"phase":      "Running",
+					"conditions": []map[string]string{{"type": "Ready", "status": "True"}},
+				}},
+			},
+		})
+	})
+	c := newTestClient(t, mux)
+
+	pods, err := c.ListPods(context.Background(), DefaultNamespace, map[string]string{"tier": "server", "app": "kstat"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, pod := range pods {
+		names = append(names, pod.Metadata.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"kstat-a", "kstat-b"}) {
+		t.Errorf("got the pods %v, want them in the name order", names)
+	}
+	if !pods[0].isReady() || pods[1].isReady() {
+		t.Errorf("got the ready pods %v %v, want only kstat-a", pods[0].isReady(), pods[1].isReady())
+	}
+}
+
+func TestContainerPort(t *testing.T) {
+	pod := &Pod{}
+	if err := json.Unmarshal([]byte(`{"spec":{"containers":[{"name":"kstat","ports":[{"name":"grpc","containerPort":8080}]}]}}`), pod); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		port      string
+		want      int
+		wantError bool
+	}{
+		{`{"port":80,"targetPort":"grpc"}`, 8080, false},
+		{`{"port":80,"targetPort":"9000"}`, 9000, false},
+		{`{"port":80,"targetPort":9001}`, 9001, false},
+		{`{"port":80}`, 80, false},
+		{`{"port":80,"targetPort":"http"}`, 0, true},
+	}
+	for _, tt := range tests {
+		port := ServicePort{}
+		if err := json.Unmarshal([]byte(tt.port), &port); err != nil {
+			t.Fatal(err)
+		}
+		got, err := pod.containerPort(port)
+		if (err != nil) != tt.wantError || got != tt.want {
+			t.Errorf("containerPort(%v) = %v, %v, want %v", tt.port, got, err, tt.want)
+		}
+	}
+}
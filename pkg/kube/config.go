@@ -0,0 +1,351 @@
+package kube
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// ErrNoKubeconfig is returned if none of the kubeconfig files exists
+	ErrNoKubeconfig = errors.New("no kubeconfig found")
+)
+
+const (
+	KubeconfigEnv = "KUBECONFIG"
+
+	execInfoEnv = "KUBERNETES_EXEC_INFO"
+	// execCredentialRefresh runs the credential plugin again a bit before
+	// the credential expires
+	execCredentialRefresh = time.Minute
+)
+
+// kubeconfig is the part of the kubeconfig file used to talk to the
+// apiserver
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string            `yaml:"name"`
+		Cluster kubeconfigCluster `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Users []struct {
+		Name string         `yaml:"name"`
+		User kubeconfigUser `yaml:"user"`
+	} `yaml:"users"`
+	Contexts []struct {
+		Name    string            `yaml:"name"`
+		Context kubeconfigContext `yaml:"context"`
+	} `yaml:"contexts"`
+}
+
+type kubeconfigCluster struct {
+	Server                   string `yaml:"server"`
+	CertificateAuthority     string `yaml:"certificate-authority"`
+	CertificateAuthorityData string `yaml:"certificate-authority-data"`
+	InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+	TLSServerName            string `yaml:"tls-server-name"`
+}
+
+type kubeconfigUser struct {
+	Token                 string      `yaml:"token"`
+	TokenFile             string      `yaml:"tokenFile"`
+	ClientCertificate     string      `yaml:"client-certificate"`
+	ClientCertificateData string      `yaml:"client-certificate-data"`
+	ClientKey             string      `yaml:"client-key"`
+	ClientKeyData         string      `yaml:"client-key-data"`
+	Username              string      `yaml:"username"`
+	Password              string      `yaml:"password"`
+	Exec                  *execConfig `yaml:"exec"`
+	AuthProvider          *struct{}   `yaml:"auth-provider"`
+}
+
+type kubeconfigContext struct {
+	Cluster   string `yaml:"cluster"`
+	User      string `yaml:"user"`
+	Namespace string `yaml:"namespace"`
+}
+
+// execConfig runs the credential plugin, e.g. for the cloud providers
+type execConfig struct {
+	Command    string   `yaml:"command"`
+	Args       []string `yaml:"args"`
+	APIVersion string   `yaml:"apiVersion"`
+	Env        []struct {
+		Name  string `yaml:"name"`
+		Value string `yaml:"value"`
+	} `yaml:"env"`
+}
+
+// Config is how to reach and authenticate to the apiserver of the context
+type Config struct {
+	Context   string
+	Server    string
+	Namespace string
+
+	tlsConfig *tls.Config
+	token     string
+	username  string
+	password  string
+	exec      *execCredential
+}
+
+// DefaultKubeconfig returns the kubeconfig files in $KUBECONFIG, or
+// ~/.kube/config if not set
+func DefaultKubeconfig() []string {
+	if env := os.Getenv(KubeconfigEnv); env != "" {
+		files := []string{}
+		for _, f := range filepath.SplitList(env) {
+			if f != "" {
+				files = append(files, f)
+			}
+		}
+		return files
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	return []string{filepath.Join(home, ".kube", "config")}
+}
+
+// LoadConfig loads the context, or the current context if empty, from the
+// kubeconfig files. The first file setting a cluster, user or context wins,
+// same as kubectl.
+func LoadConfig(files []string, context string) (*Config, error) {
+	merged := &kubeconfig{}
+	found := false
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read kubeconfig %v", file)
+		}
+		kc := &kubeconfig{}
+		if err := yaml.Unmarshal(data, kc); err != nil {
+			return nil, errors.Wrapf(err, "cannot decode kubeconfig %v", file)
+		}
+		kc.resolvePaths(filepath.Dir(file))
+		found = true
+		if merged.CurrentContext == "" {
+			merged.CurrentContext = kc.CurrentContext
+		}
+		merged.Clusters = append(merged.Clusters, kc.Clusters...)
+		merged.Users = append(merged.Users, kc.Users...)
+		merged.Contexts = append(merged.Contexts, kc.Contexts...)
+	}
+	if !found {
+		return nil, ErrNoKubeconfig
+	}
+
+	if context == "" {
+		context = merged.CurrentContext
+	}
+	if context == "" {
+		return nil, fmt.Errorf("no current context in kubeconfig %v", files)
+	}
+	var kctx *kubeconfigContext
+	for i := range merged.Contexts {
+		if merged.Contexts[i].Name == context {
+			kctx = &merged.Contexts[i].Context
+			break
+		}
+	}
+	if kctx == nil {
+		return nil, fmt.Errorf("context %v not found in kubeconfig %v", context, files)
+	}
+	var cluster *kubeconfigCluster
+	for i := range merged.Clusters {
+		if merged.Clusters[i].Name == kctx.Cluster {
+			cluster = &merged.Clusters[i].Cluster
+			break
+		}
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("cluster %v of context %v not found in kubeconfig", kctx.Cluster, context)
+	}
+	user := &kubeconfigUser{}
+	for i := range merged.Users {
+		if merged.Users[i].Name == kctx.User {
+			user = &merged.Users[i].User
+			break
+		}
+	}
+
+	cfg := &Config{
+		Context:   context,
+		Server:    strings.TrimSuffix(cluster.Server, "/"),
+		Namespace: kctx.Namespace,
+		username:  user.Username,
+		password:  user.Password,
+	}
+	if err := cfg.loadTLS(cluster, user); err != nil {
+		return nil, errors.Wrapf(err, "invalid kubeconfig for context %v", context)
+	}
+	if err := cfg.loadCredential(user); err != nil {
+		return nil, errors.Wrapf(err, "invalid kubeconfig for context %v", context)
+	}
+	return cfg, nil
+}
+
+// resolvePaths makes the relative paths of the files in the kubeconfig
+// relative to the directory of it instead of the working directory, same as
+// kubectl, e.g. for the kubeconfigs of minikube and kind
+func (kc *kubeconfig) resolvePaths(dir string) {
+	resolve := func(path *string) {
+		if *path != "" && !filepath.IsAbs(*path) {
+			*path = filepath.Join(dir, *path)
+		}
+	}
+	for i := range kc.Clusters {
+		resolve(&kc.Clusters[i].Cluster.CertificateAuthority)
+	}
+	for i := range kc.Users {
+		user := &kc.Users[i].User
+		resolve(&user.TokenFile)
+		resolve(&user.ClientCertificate)
+		resolve(&user.ClientKey)
+		// the command without the path is looked up in $PATH
+		if user.Exec != nil && strings.ContainsRune(user.Exec.Command, filepath.Separator) {
+			resolve(&user.Exec.Command)
+		}
+	}
+}
+
+// readData returns the base64 encoded data, or the content of the file
+func readData(data, file string) ([]byte, error) {
+	if data != "" {
+		return base64.StdEncoding.DecodeString(data)
+	}
+	if file != "" {
+		return ioutil.ReadFile(file)
+	}
+	return nil, nil
+}
+
+func (cfg *Config) loadTLS(cluster *kubeconfigCluster, user *kubeconfigUser) error {
+	cfg.tlsConfig = &tls.Config{
+		InsecureSkipVerify: cluster.InsecureSkipTLSVerify,
+		ServerName:         cluster.TLSServerName,
+	}
+	ca, err := readData(cluster.CertificateAuthorityData, cluster.CertificateAuthority)
+	if err != nil {
+		return errors.Wrap(err, "cannot read the certificate authority")
+	}
+	if len(ca) != 0 {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("no valid certificate authority found")
+		}
+		cfg.tlsConfig.RootCAs = pool
+	}
+
+	cert, err := readData(user.ClientCertificateData, user.ClientCertificate)
+	if err != nil {
+		return errors.Wrap(err, "cannot read the client certificate")
+	}
+	key, err := readData(user.ClientKeyData, user.ClientKey)
+	if err != nil {
+		return errors.Wrap(err, "cannot read the client key")
+	}
+	if len(cert) != 0 {
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return errors.Wrap(err, "invalid client certificate")
+		}
+		cfg.tlsConfig.Certificates = []tls.Certificate{pair}
+	}
+	return nil
+}
+
+func (cfg *Config) loadCredential(user *kubeconfigUser) error {
+	if user.AuthProvider != nil {
+		return fmt.Errorf("auth-provider is not supported, please use the exec credential plugin instead")
+	}
+	cfg.token = user.Token
+	if cfg.token == "" && user.TokenFile != "" {
+		token, err := ioutil.ReadFile(user.TokenFile)
+		if err != nil {
+			return errors.Wrapf(err, "cannot read the token file %v", user.TokenFile)
+		}
+		cfg.token = strings.TrimSpace(string(token))
+	}
+	if user.Exec != nil {
+		cfg.exec = &execCredential{config: user.Exec}
+	}
+	return nil
+}
+
+// execCredential is the credential from the credential plugin, which runs
+// again when the credential expires
+type execCredential struct {
+	config *execConfig
+
+	mutex      sync.Mutex
+	token      string
+	cert       *tls.Certificate
+	expiration time.Time
+}
+
+// execCredentialOutput is the ExecCredential printed by the plugin
+type execCredentialOutput struct {
+	Status struct {
+		Token                 string    `json:"token"`
+		ClientCertificateData string    `json:"clientCertificateData"`
+		ClientKeyData         string    `json:"clientKeyData"`
+		ExpirationTimestamp   time.Time `json:"expirationTimestamp"`
+	} `json:"status"`
+}
+
+func (e *execCredential) get() (string, *tls.Certificate, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if (e.token != "" || e.cert != nil) && (e.expiration.IsZero() || time.Now().Add(execCredentialRefresh).Before(e.expiration)) {
+		return e.token, e.cert, nil
+	}
+
+	cmd := exec.Command(e.config.Command, e.config.Args...)
+	cmd.Env = os.Environ()
+	for _, env := range e.config.Env {
+		cmd.Env = append(cmd.Env, env.Name+"="+env.Value)
+	}
+	info := fmt.Sprintf(`{"apiVersion":%q,"kind":"ExecCredential","spec":{"interactive":false}}`, e.config.APIVersion)
+	cmd.Env = append(cmd.Env, execInfoEnv+"="+info)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "failed to run the credential plugin %v: %v", e.config.Command, strings.TrimSpace(stderr.String()))
+	}
+
+	output := &execCredentialOutput{}
+	if err := json.Unmarshal(out, output); err != nil {
+		return "", nil, errors.Wrapf(err, "invalid output of the credential plugin %v", e.config.Command)
+	}
+	e.token = output.Status.Token
+	e.cert = nil
+	if output.Status.ClientCertificateData != "" {
+		pair, err := tls.X509KeyPair([]byte(output.Status.ClientCertificateData), []byte(output.Status.ClientKeyData))
+		if err != nil {
+			return "", nil, errors.Wrapf(err, "invalid client certificate from the credential plugin %v", e.config.Command)
+		}
+		e.cert = &pair
+	}
+	e.expiration = output.Status.ExpirationTimestamp
+	return e.token, e.cert, nil
+}
@@ -0,0 +1,192 @@
+package kube
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeKubeconfig(t *testing.T, dir, name, content string) string {
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := writeKubeconfig(t, dir, "token", "file-token\n")
+	first := writeKubeconfig(t, dir, "first", `
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com:6443/
+    insecure-skip-tls-verify: true
+- name: prod
+  cluster:
+    server: https://prod.example.com
+users:
+- name: alice
+  user:
+    token: alice-token
+contexts:
+- name: dev
+  context: {cluster: dev, user: alice, namespace: team}
+`)
+	second := writeKubeconfig(t, dir, "second", `
+current-context: prod
+clusters:
+- name: dev
+  cluster:
+    server: https://shadowed.example.com
+users:
+- name: bob
+  user:
+    tokenFile: `+tokenFile+`
+- name: carol
+  user:
+    username: carol
+    password: secret
+- name: gke
+  user:
+    auth-provider: {}
+contexts:
+- name: prod
+  context: {cluster: prod, user: bob}
+- name: basic
+  context: {cluster: prod, user: carol}
+- name: provider
+  context: {cluster: prod, user: gke}
+- name: orphan
+  context: {cluster: missing, user: bob}
+`)
+	files := []string{filepath.Join(dir, "missing"), first, second}
+
+	tests := []struct {
+		name      string
+		files     []string
+		context   string
+		want      Config
+		insecure  bool
+		wantError bool
+	}{
+		{
+			name:     "current context of the first file",
+			files:    files,
+			want:     Config{Context: "dev", Server: "https://dev.example.com:6443", Namespace: "team", token: "alice-token"},
+			insecure: true,
+		},
+		{
+			name:    "token file",
+			files:   files,
+			context: "prod",
+			want:    Config{Context: "prod", Server: "https://prod.example.com", token: "file-token"},
+		},
+		{
+			name:    "basic auth",
+			files:   files,
+			context: "basic",
+			want:    Config{Context: "basic", Server: "https://prod.example.com", username: "carol", password: "secret"},
+		},
+		{
+			name:  "current context of the first file in the order",
+			files: []string{second, first},
+			want:  Config{Context: "prod", Server: "https://prod.example.com", token: "file-token"},
+		},
+		{
+			name:    "cluster of the first file in the order",
+			files:   []string{second, first},
+			context: "dev",
+			want:    Config{Context: "dev", Server: "https://shadowed.example.com", Namespace: "team", token: "alice-token"},
+		},
+		{name: "unknown context", files: files, context: "staging", wantError: true},
+		{name: "unknown cluster", files: files, context: "orphan", wantError: true},
+		{name: "auth provider", files: files, context: "provider", wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfig(tt.files, tt.context)
+			if tt.wantError {
+				if err == nil {
+					t.Fatalf("loaded %+v, want an error", cfg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Context != tt.want.Context || cfg.Server != tt.want.Server || cfg.Namespace != tt.want.Namespace {
+				t.Errorf("got context %v server %v namespace %v, want %v %v %v",
+					cfg.Context, cfg.Server, cfg.Namespace, tt.want.Context, tt.want.Server, tt.want.Namespace)
+			}
+			if cfg.token != tt.want.token || cfg.username != tt.want.username || cfg.password != tt.want.password {
+				t.Errorf("got the credential %q %q %q, want %q %q %q",
+					cfg.token, cfg.username, cfg.password, tt.want.token, tt.want.username, tt.want.password)
+			}
+			if cfg.tlsConfig.InsecureSkipVerify != tt.insecure {
+				t.Errorf("got insecure %v, want %v", cfg.tlsConfig.InsecureSkipVerify, tt.insecure)
+			}
+		})
+	}
+}
+
+func TestLoadConfigRelativePath(t *testing.T) {
+	// the paths are relative to the kubeconfig, not the working directory
+	dir := filepath.Join(t.TempDir(), "minikube")
+	if err := os.MkdirAll(filepath.Join(dir, "profiles"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeKubeconfig(t, filepath.Join(dir, "profiles"), "token", "relative-token\n")
+	file := writeKubeconfig(t, dir, "config", `
+current-context: minikube
+clusters:
+- name: minikube
+  cluster: {server: "https://192.168.49.2:8443"}
+users:
+- name: minikube
+  user: {tokenFile: profiles/token}
+contexts:
+- name: minikube
+  context: {cluster: minikube, user: minikube}
+`)
+	cfg, err := LoadConfig([]string{file}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.token != "relative-token" {
+		t.Errorf("got the token %q, want relative-token", cfg.token)
+	}
+}
+
+func TestLoadConfigNotFound(t *testing.T) {
+	_, err := LoadConfig([]string{filepath.Join(t.TempDir(), "config")}, "")
+	if err != ErrNoKubeconfig {
+		t.Errorf("got %v, want %v", err, ErrNoKubeconfig)
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]string{
+		"not yaml":           "clusters: [",
+		"no current context": "contexts: []",
+		"invalid ca": `
+current-context: dev
+clusters:
+- name: dev
+  cluster: {server: "https://dev.example.com", certificate-authority-data: bm90IGEgY2VydA==}
+contexts:
+- name: dev
+  context: {cluster: dev}
+`,
+	}
+	for name, content := range tests {
+		file := writeKubeconfig(t, dir, "config", content)
+		if cfg, err := LoadConfig([]string{file}, ""); err == nil {
+			t.Errorf("%v: loaded %+v, want an error", name, cfg)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package kube
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+const (
+	FieldManager = "kstat"
+
+	applyPatchContentType = "application/apply-patch+yaml"
+)
+
+// Object is a Kubernetes object in the generic form
+type Object map[string]interface{}
+
+// resource is where the objects of the kind are in the API
+type resource struct {
+	name       string
+	namespaced bool
+}
+
+// resources are the kinds in the manifests of kstat, by the group version
+// and the kind
+var resources = map[string]resource{
+	"v1/Namespace":       {"namespaces", false},
+	"v1/ServiceAccount":  {"serviceaccounts", true},
+	"v1/Service":         {"services", true},
+	"v1/ConfigMap":       {"configmaps", true},
+	"apps/v1/DaemonSet":  {"daemonsets", true},
+	"apps/v1/Deployment": {"deployments", true},
+	"rbac.authorization.k8s.io/v1/ClusterRole":        {"clusterroles", false},
+	"rbac.authorization.k8s.io/v1/ClusterRoleBinding": {"clusterrolebindings", false},
+}
+
+func (o Object) field(path ...string) string {
+	var value interface{} = map[string]interface{}(o)
+	for _, p := range path {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		value = m[p]
+	}
+	s, _ := value.(string)
+	return s
+}
+
+func (o Object) APIVersion() string {
+	return o.field("apiVersion")
+}
+
+func (o Object) Kind() string {
+	return o.field("kind")
+}
+
+func (o Object) Name() string {
+	return o.field("metadata", "name")
+}
+
+func (o Object) Namespace() string {
+	return o.field("metadata", "namespace")
+}
+
+// Annotation returns the value of the annotation, or empty if not set
+func (o Object) Annotation(key string) string {
+	return o.field("metadata", "annotations", key)
+}
+
+// WithAnnotation returns the copy of the object with the annotation set, the
+// original object is not changed
+func (o Object) WithAnnotation(key, value string) Object {
+	copied := Object{}
+	for k, v := range o {
+		copied[k] = v
+	}
+	metadata := map[string]interface{}{}
+	if m, ok := o["metadata"].(map[string]interface{}); ok {
+		for k, v := range m {
+			metadata[k] = v
+		}
+	}
+	annotations := map[string]interface{}{}
+	if a, ok := metadata["annotations"].(map[string]interface{}); ok {
+		for k, v := range a {
+			annotations[k] = v
+		}
+	}
+	annotations[key] = value
+	metadata["annotations"] = annotations
+	copied["metadata"] = metadata
+	return copied
+}
+
+// String returns the kind and the name of the object, e.g.
+// Deployment kstat-system/kstat
+func (o Object) String() string {
+	if o.Namespace() != "" {
+		return fmt.Sprintf("%v %v/%v", o.Kind(), o.Namespace(), o.Name())
+	}
+	return fmt.Sprintf("%v %v", o.Kind(), o.Name())
+}
+
+// path returns the API path of the object
+func (o Object) path() (string, error) {
+	r, exists := resources[o.APIVersion()+"/"+o.Kind()]
+	if !exists {
+		return "", fmt.Errorf("unsupported kind %v of %v", o.Kind(), o.APIVersion())
+	}
+	if o.Name() == "" {
+		return "", fmt.Errorf("no name for %v", o.Kind())
+	}
+	prefix := "/apis/" + o.APIVersion()
+	if !strings.Contains(o.APIVersion(), "/") {
+		prefix = "/api/" + o.APIVersion()
+	}
+	if r.namespaced {
+		if o.Namespace() == "" {
+			return "", fmt.Errorf("no namespace for %v", o)
+		}
+		prefix += "/namespaces/" + url.PathEscape(o.Namespace())
+	}
+	return prefix + "/" + r.name + "/" + url.PathEscape(o.Name()), nil
+}
+
+// ConvertYAML converts the maps decoded from yaml into the maps with the
+// string keys, which can be encoded in json
+func ConvertYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, item := range v {
+			m[fmt.Sprint(k)] = ConvertYAML(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = ConvertYAML(item)
+		}
+		return v
+	}
+	return value
+}
+
+// Get returns the current object in the cluster, or nil if it doesn't exist
+func (c *Client) Get(ctx context.Context, obj Object) (Object, error) {
+	path, err := obj.path()
+	if err != nil {
+		return nil, err
+	}
+	current := Object{}
+	if err := c.Do(ctx, http.MethodGet, path, nil, nil, &current); err != nil {
+		if IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "cannot get %v", obj)
+	}
+	return current, nil
+}
+
+// Apply creates or updates the object by the server side apply, and returns
+// the object in the cluster. The object is not changed in the dry run.
+func (c *Client) Apply(ctx context.Context, obj Object, dryRun bool) (Object, error) {
+	path, err := obj.path()
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{
+		"fieldManager": []string{FieldManager},
+		"force":        []string{"true"},
+	}
+	if dryRun {
+		query.Set("dryRun", "All")
+	}
+	result := Object{}
+	if err := c.do(ctx, http.MethodPatch, path, query, applyPatchContentType, obj, &result); err != nil {
+		return nil, errors.Wrapf(err, "cannot apply %v", obj)
+	}
+	return result, nil
+}
+
+// Delete deletes the object with its dependents, it's not an error if the
+// object doesn't exist
+func (c *Client) Delete(ctx context.Context, obj Object) error {
+	path, err := obj.path()
+	if err != nil {
+		return err
+	}
+	query := url.Values{"propagationPolicy": []string{"Background"}}
+	if err := c.Do(ctx, http.MethodDelete, path, query, nil, nil); err != nil && !IsNotFound(err) {
+		return errors.Wrapf(err, "cannot delete %v", obj)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package kube
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// PortForwardProtocol is the websocket subprotocol of the port forward,
+	// every message starts with the byte of the channel. The port has the
+	// data channel and the error channel, and the first message of each
+	// channel from the server is the port number.
+	PortForwardProtocol = "v4.channel.k8s.io"
+
+	portForwardDataChannel  = 0
+	portForwardErrorChannel = 1
+	portForwardPortLength   = 2
+)
+
+// PortForward opens a connection to the port of the pod through the
+// apiserver
+func (c *Client) PortForward(ctx context.Context, namespace, pod string, port int) (net.Conn, error) {
+	server, err := url.Parse(c.cfg.Server)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid apiserver address %v", c.cfg.Server)
+	}
+	location := *server
+	switch server.Scheme {
+	case "https":
+		location.Scheme = "wss"
+	case "http":
+		location.Scheme = "ws"
+	default:
+		return nil, fmt.Errorf("invalid apiserver address %v, must be http or https", c.cfg.Server)
+	}
+	location.Path = strings.TrimSuffix(server.Path, "/") +
+		fmt.Sprintf("/api/v1/namespaces/%v/pods/%v/portforward", url.PathEscape(namespace), url.PathEscape(pod))
+	location.RawQuery = url.Values{"ports": []string{strconv.Itoa(port)}}.Encode()
+
+	config, err := websocket.NewConfig(location.String(), server.Scheme+"://"+server.Host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid port forward address %v", location.String())
+	}
+	config.Protocol = []string{PortForwardProtocol}
+	if err := c.authorize(config.Header); err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", hostPort(&location))
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot connect to apiserver %v", c.cfg.Server)
+	}
+	if location.Scheme == "wss" {
+		tlsConfig := c.tlsConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = location.Hostname()
+		}
+		conn = tls.Client(conn, tlsConfig)
+	}
+	// the handshake has the deadline of the dial
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "cannot forward port %v of pod %v/%v", port, namespace, pod)
+	}
+	conn.SetDeadline(time.Time{})
+	ws.PayloadType = websocket.BinaryFrame
+	return &portForwardConn{ws: ws, name: fmt.Sprintf("%v/%v:%v", namespace, pod, port)}, nil
+}
+
+// hostPort returns the address to dial for the websocket location
+func hostPort(location *url.URL) string {
+	if location.Port() != "" {
+		return location.Host
+	}
+	if location.Scheme == "wss" {
+		return net.JoinHostPort(location.Hostname(), "443")
+	}
+	return net.JoinHostPort(location.Hostname(), "80")
+}
+
+// portForwardConn is the connection to the forwarded port, over the channels
+// of the websocket
+type portForwardConn struct {
+	ws   *websocket.Conn
+	name string
+
+	readMutex sync.Mutex
+	buf       []byte
+	// portRead is if the port number at the beginning of the channel has
+	// been skipped
+	portRead map[byte]bool
+	err      error
+
+	writeMutex sync.Mutex
+}
+
+func (pc *portForwardConn) Read(p []byte) (int, error) {
+	pc.readMutex.Lock()
+	defer pc.readMutex.Unlock()
+
+	for len(pc.buf) == 0 {
+		if pc.err != nil {
+			return 0, pc.err
+		}
+		msg := []byte{}
+		if err := websocket.Message.Receive(pc.ws, &msg); err != nil {
+			if err == io.EOF {
+				return 0, io.EOF
+			}
+			return 0, errors.Wrapf(err, "failed to read from port forward %v", pc.name)
+		}
+		if len(msg) == 0 {
+			continue
+		}
+		channel, data := msg[0], msg[1:]
+		if pc.portRead == nil {
+			pc.portRead = map[byte]bool{}
+		}
+		if !pc.portRead[channel] {
+			if len(data) < portForwardPortLength {
+				return 0, fmt.Errorf("invalid port forward %v, no port number on channel %v", pc.name, channel)
+			}
+			data = data[portForwardPortLength:]
+			pc.portRead[channel] = true
+		}
+		switch channel {
+		case portForwardDataChannel:
+			pc.buf = data
+		case portForwardErrorChannel:
+			if len(data) != 0 {
+				// return the data received before the error first
+				pc.err = fmt.Errorf("port forward %v failed: %v", pc.name, string(data))
+			}
+		}
+	}
+	n := copy(p, pc.buf)
+	pc.buf = pc.buf[n:]
+	return n, nil
+}
+
+func (pc *portForwardConn) Write(p []byte) (int, error) {
+	pc.writeMutex.Lock()
+	defer pc.writeMutex.Unlock()
+
+	msg := make([]byte, len(p)+1)
+	msg[0] = portForwardDataChannel
+	copy(msg[1:], p)
+	if err := websocket.Message.Send(pc.ws, msg); err != nil {
+		return 0, errors.Wrapf(err, "failed to write to port forward %v", pc.name)
+	}
+	return len(p), nil
+}
+
+func (pc *portForwardConn) Close() error {
+	return pc.ws.Close()
+}
+
+func (pc *portForwardConn) LocalAddr() net.Addr {
+	return pc.ws.LocalAddr()
+}
+
+func (pc *portForwardConn) RemoteAddr() net.Addr {
+	return pc.ws.RemoteAddr()
+}
+
+func (pc *portForwardConn) SetDeadline(t time.Time) error {
+	return pc.ws.SetDeadline(t)
+}
+
+func (pc *portForwardConn) SetReadDeadline(t time.Time) error {
+	return pc.ws.SetReadDeadline(t)
+}
+
+func (pc *portForwardConn) SetWriteDeadline(t time.Time) error {
+	return pc.ws.SetWriteDeadline(t)
+}
+
+// ServiceDialer returns the dialer connecting to the service through the
+// port forward. Every dial finds a ready pod of the service again, so the
+// connection fails over to another pod after the pod is gone.
+func (c *Client) ServiceDialer(namespace, service string) func(context.Context, string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		svc, err := c.GetService(ctx, namespace, service)
+		if err != nil {
+			return nil, err
+		}
+		port, err := servicePort(svc)
+		if err != nil {
+			return nil, err
+		}
+		if len(svc.Spec.Selector) == 0 {
+			return nil, fmt.Errorf("service %v/%v has no selector", namespace, service)
+		}
+		pods, err := c.ListPods(ctx, namespace, svc.Spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+		for i := range pods {
+			pod := &pods[i]
+			if !pod.isReady() {
+				continue
+			}
+			podPort, err := pod.containerPort(port)
+			if err != nil {
+				return nil, err
+			}
+			return c.PortForward(ctx, namespace, pod.Metadata.Name, podPort)
+		}
+		return nil, fmt.Errorf("no ready pod of service %v/%v", namespace, service)
+	}
+}
@@ -0,0 +1,126 @@
+package kube
+
+import (
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"golang.org/x/net/websocket"
+)
+
+const testPodPort = 8080
+
+// portForwardHandler is the port forward of the apiserver, which echoes the
+// data back in two messages, and fails the port on the data "fail"
+func portForwardHandler(t *testing.T) http.Handler {
+	return websocket.Server{
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			for _, p := range config.Protocol {
+				if p == PortForwardProtocol {
+					config.Protocol = []string{PortForwardProtocol}
+					return nil
+				}
+			}
+			return websocket.ErrBadWebSocketProtocol
+		},
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+			if got := ws.Request().URL.Query().Get("ports"); got != "8080" {
+				t.Errorf("forwarding the port %v, want %v", got, testPodPort)
+				return
+			}
+			send := func(channel byte, data []byte) bool {
+				return websocket.Message.Send(ws, append([]byte{channel}, data...)) == nil
+			}
+			// every channel starts with the port number
+			port := make([]byte, portForwardPortLength)
+			binary.LittleEndian.PutUint16(port, testPodPort)
+			if !send(portForwardDataChannel, port) || !send(portForwardErrorChannel, port) {
+				return
+			}
+			for {
+				msg := []byte{}
+				if err := websocket.Message.Receive(ws, &msg); err != nil {
+					return
+				}
+				if len(msg) < 2 || msg[0] != portForwardDataChannel {
+					t.Errorf("got the invalid message %v", msg)
+					return
+				}
+				data := msg[1:]
+				if string(data) == "fail" {
+					send(portForwardErrorChannel, []byte("connection refused"))
+					return
+				}
+				if websocket.Message.Send(ws, []byte{}) != nil ||
+					!send(portForwardDataChannel, data[:1]) || !send(portForwardDataChannel, data[1:]) {
+					return
+				}
+			}
+		},
+	}
+}
+
+// newPortForwardClient returns the client of the apiserver with the service
+// of the pods, and the port forward of the pod named ready
+func newPortForwardClient(t *testing.T, pods string) *Client {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/namespaces/kstat-system/services/kstat", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"metadata":{"name":"kstat"},"spec":{"selector":{"app":"kstat"},"ports":[{"name":"grpc","port":80,"targetPort":"grpc"}]}}`))
+	})
+	mux.HandleFunc("/api/v1/namespaces/kstat-system/pods", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(pods))
+	})
+	mux.Handle("/api/v1/namespaces/kstat-system/pods/ready/portforward", portForwardHandler(t))
+	return newTestClient(t, mux)
+}
+
+const testPods = `{"items":[
+	{"metadata":{"name":"pending"},"status":{"phase":"Pending"}},
+	{"metadata":{"name":"ready"},
+	 "spec":{"containers":[{"name":"kstat","ports":[{"name":"grpc","containerPort":8080}]}]},
+	 "status":{"phase":"Running","conditions":[{"type":"Ready","status":"True"}]}}
+]}`
+
+func TestServiceDialer(t *testing.T) {
+	c := newPortForwardClient(t, testPods)
+	conn, err := c.ServiceDialer(DefaultNamespace, DefaultService)(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, msg := range []string{"hello", "kstat"} {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, len(msg))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != msg {
+			t.Errorf("got %q, want %q", buf, msg)
+		}
+	}
+
+	if _, err := conn.Write([]byte("fail")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Read(make([]byte, 1))
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("got %v, want the error of the port forward", err)
+	}
+	if _, again := conn.Read(make([]byte, 1)); again == nil || again.Error() != err.Error() {
+		t.Errorf("got %v reading again, want the same error", again)
+	}
+}
+
+func TestServiceDialerNoReadyPod(t *testing.T) {
+	c := newPortForwardClient(t, `{"items":[{"metadata":{"name":"pending"},"status":{"phase":"Pending"}}]}`)
+	if _, err := c.ServiceDialer(DefaultNamespace, DefaultService)(context.Background(), ""); err == nil {
+		t.Errorf("dialed without a ready pod")
+	}
+}
@@ -0,0 +1,87 @@
+package manifest
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// DiffContext is the number of the unchanged lines around the changes
+	DiffContext = 3
+)
+
+// diffLines returns the changes from a to b in the unified diff style, with
+// the unchanged lines around the changes
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := []string{}
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, " "+a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "-"+a[i])
+			i++
+		default:
+			lines = append(lines, "+"+b[j])
+			j++
+		}
+	}
+
+	// only keep the context of the changes
+	keep := make([]bool, len(lines))
+	for i, line := range lines {
+		if line[0] == ' ' {
+			continue
+		}
+		for k := i - DiffContext; k <= i+DiffContext; k++ {
+			if k >= 0 && k < len(lines) {
+				keep[k] = true
+			}
+		}
+	}
+	result := []string{}
+	skipped := false
+	for i, line := range lines {
+		if !keep[i] {
+			skipped = true
+			continue
+		}
+		if skipped && len(result) != 0 {
+			result = append(result, "@@")
+		}
+		skipped = false
+		result = append(result, line)
+	}
+	return result
+}
+
+// diff returns the changes between the yaml of the objects, or empty if they
+// are the same
+func diff(current, desired string) string {
+	if current == desired {
+		return ""
+	}
+	lines := diffLines(strings.Split(strings.TrimSuffix(current, "\n"), "\n"),
+		strings.Split(strings.TrimSuffix(desired, "\n"), "\n"))
+	return fmt.Sprintln(strings.Join(lines, "\n"))
+}
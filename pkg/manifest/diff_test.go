@@ -0,0 +1,71 @@
+package manifest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []string
+	}{
+		{
+			name: "same",
+			a:    "a b c",
+			b:    "a b c",
+			want: []string{},
+		},
+		{
+			name: "changed",
+			a:    "a b c",
+			b:    "a x c",
+			want: []string{" a", "-b", "+x", " c"},
+		},
+		{
+			name: "added and removed",
+			a:    "a b c",
+			b:    "b c d",
+			want: []string{"-a", " b", " c", "+d"},
+		},
+		{
+			name: "from empty",
+			a:    "",
+			b:    "a",
+			want: []string{"+a"},
+		},
+		{
+			name: "context",
+			a:    "1 2 3 4 5 6 7 8 9",
+			b:    "1 2 3 4 x 6 7 8 9",
+			want: []string{" 2", " 3", " 4", "-5", "+x", " 6", " 7", " 8"},
+		},
+		{
+			name: "separated changes",
+			a:    "1 2 3 4 5 6 7 8 9 10",
+			b:    "x 2 3 4 5 6 7 8 9 y",
+			want: []string{"-1", "+x", " 2", " 3", " 4", "@@", " 7", " 8", " 9", "-10", "+y"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffLines(strings.Fields(tt.a), strings.Fields(tt.b))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	current := "metadata:\n  name: kstat\nspec:\n  replicas: 1\n"
+	if got := diff(current, current); got != "" {
+		t.Errorf("got the diff %q of the same objects", got)
+	}
+	want := " metadata:\n   name: kstat\n spec:\n-  replicas: 1\n+  replicas: 2\n"
+	if got := diff(current, strings.Replace(current, "1", "2", 1)); got != want {
+		t.Errorf("got the diff %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,200 @@
+package manifest
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+	"gopkg.in/yaml.v2"
+
+	"github.com/yasker/kstat/pkg/kube"
+)
+
+const (
+	ChangeCreate    = "create"
+	ChangeUpdate    = "update"
+	ChangeUnchanged = "unchanged"
+	ChangeDelete    = "delete"
+	// ChangeKeep is the object not deleted on uninstall, e.g. the namespace
+	// existed before the install
+	ChangeKeep = "keep"
+
+	// ServerDeployment is the object telling if kstat is installed
+	ServerDeployment = "kstat"
+
+	// CreatedAnnotation marks the namespace created by the install, which
+	// is deleted on uninstall. The namespace existed before is kept.
+	CreatedAnnotation = "kstat.io/created"
+	createdValue      = "true"
+	kindNamespace     = "Namespace"
+)
+
+var (
+	// generatedMetadata is set by the cluster, and ignored in the diff
+	generatedMetadata = []string{"managedFields", "resourceVersion", "uid", "creationTimestamp", "generation", "selfLink"}
+	// generatedAnnotations are set by the controllers
+	generatedAnnotations = []string{"deployment.kubernetes.io/revision", "deprecated.daemonset.template.generation"}
+)
+
+// Change is what happens to the object when applying the manifests
+type Change struct {
+	Object kube.Object
+	Type   string
+	// Diff is the change of the existing object, in the unified diff style
+	Diff string
+}
+
+// Installed returns if the kstat server is in the namespace of the values
+func Installed(ctx context.Context, kc *kube.Client, values *Values) (bool, error) {
+	current, err := kc.Get(ctx, kube.Object{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":      ServerDeployment,
+			"namespace": values.Namespace,
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return current != nil, nil
+}
+
+// Plan compares the objects with the ones in the cluster. The existing
+// objects are applied in the dry run, so the diff only has the changes
+// after the defaults of the cluster are filled.
+func Plan(ctx context.Context, kc *kube.Client, objects []kube.Object) ([]*Change, error) {
+	changes := []*Change{}
+	for _, obj := range objects {
+		current, err := kc.Get(ctx, obj)
+		if err != nil {
+			return nil, err
+		}
+		// keep marking the namespace created by kstat on upgrade, or the
+		// apply would remove the annotation
+		if obj.Kind() == kindNamespace && (current == nil || current.Annotation(CreatedAnnotation) == createdValue) {
+			obj = obj.WithAnnotation(CreatedAnnotation, createdValue)
+		}
+		if current == nil {
+			changes = append(changes, &Change{Object: obj, Type: ChangeCreate})
+			continue
+		}
+		desired, err := kc.Apply(ctx, obj, true)
+		if err != nil {
+			return nil, err
+		}
+		currentYAML, err := normalizedYAML(current)
+		if err != nil {
+			return nil, err
+		}
+		desiredYAML, err := normalizedYAML(desired)
+		if err != nil {
+			return nil, err
+		}
+		change := &Change{Object: obj, Type: ChangeUnchanged, Diff: diff(currentYAML, desiredYAML)}
+		if change.Diff != "" {
+			change.Type = ChangeUpdate
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// PlanUninstall returns the objects to delete in the reverse order of the
+// creation, the ones not in the cluster are skipped. The namespace is kept
+// unless it's created by kstat, or deleteNamespace.
+func PlanUninstall(ctx context.Context, kc *kube.Client, objects []kube.Object, deleteNamespace bool) ([]*Change, error) {
+	changes := []*Change{}
+	for i := len(objects) - 1; i >= 0; i-- {
+		current, err := kc.Get(ctx, objects[i])
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			continue
+		}
+		change := &Change{Object: objects[i], Type: ChangeDelete}
+		if objects[i].Kind() == kindNamespace && !deleteNamespace && current.Annotation(CreatedAnnotation) != createdValue {
+			change.Type = ChangeKeep
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// normalizedYAML returns the object without the fields set by the cluster
+func normalizedYAML(obj kube.Object) (string, error) {
+	copied := kube.Object{}
+	for k, v := range obj {
+		if k != "status" {
+			copied[k] = v
+		}
+	}
+	if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+		m := map[string]interface{}{}
+		for k, v := range metadata {
+			m[k] = v
+		}
+		for _, field := range generatedMetadata {
+			delete(m, field)
+		}
+		if annotations, ok := m["annotations"].(map[string]interface{}); ok {
+			a := map[string]interface{}{}
+			for k, v := range annotations {
+				a[k] = v
+			}
+			for _, annotation := range generatedAnnotations {
+				delete(a, annotation)
+			}
+			m["annotations"] = a
+			if len(a) == 0 {
+				delete(m, "annotations")
+			}
+		}
+		copied["metadata"] = m
+	}
+	data, err := yaml.Marshal(map[string]interface{}(copied))
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot encode %v", obj)
+	}
+	return string(data), nil
+}
+
+// PrintChanges prints the changes in the style of kubectl, with the diff of
+// the updated objects
+func PrintChanges(w io.Writer, changes []*Change) {
+	for _, change := range changes {
+		switch change.Type {
+		case ChangeCreate:
+			fmt.Fprintf(w, "+ %v will be created\n", change.Object)
+		case ChangeUpdate:
+			fmt.Fprintf(w, "~ %v will be updated\n%v", change.Object, change.Diff)
+		case ChangeUnchanged:
+			fmt.Fprintf(w, "= %v is unchanged\n", change.Object)
+		case ChangeDelete:
+			fmt.Fprintf(w, "- %v will be deleted\n", change.Object)
+		case ChangeKeep:
+			fmt.Fprintf(w, "= %v is kept since it's not created by kstat\n", change.Object)
+		}
+	}
+}
+
+// Execute applies or deletes the objects of the changes in the order
+func Execute(ctx context.Context, kc *kube.Client, changes []*Change, w io.Writer) error {
+	for _, change := range changes {
+		switch change.Type {
+		case ChangeCreate, ChangeUpdate:
+			if _, err := kc.Apply(ctx, change.Object, false); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%v %vd\n", change.Object, change.Type)
+		case ChangeDelete:
+			if err := kc.Delete(ctx, change.Object); err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%v deleted\n", change.Object)
+		}
+	}
+	return nil
+}
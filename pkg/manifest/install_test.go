@@ -0,0 +1,182 @@
+package manifest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/yasker/kstat/pkg/kube"
+)
+
+// fakeAPIServer keeps the objects by the API path, and applies or deletes
+// them like the apiserver
+type fakeAPIServer struct {
+	mutex   sync.Mutex
+	objects map[string]kube.Object
+}
+
+func (s *fakeAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		obj, exists := s.objects[r.URL.Path]
+		if !exists {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"kind":"Status","message":"not found"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(obj)
+	case http.MethodPatch:
+		obj := kube.Object{}
+		if err := json.NewDecoder(r.Body).Decode(&obj); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Get("dryRun") == "" {
+			s.objects[r.URL.Path] = obj
+		}
+		json.NewEncoder(w).Encode(obj)
+	case http.MethodDelete:
+		delete(s.objects, r.URL.Path)
+		w.Write([]byte(`{}`))
+	}
+}
+
+func newFakeCluster(t *testing.T, objects map[string]kube.Object) (*kube.Client, *fakeAPIServer) {
+	fake := &fakeAPIServer{objects: objects}
+	apiserver := httptest.NewServer(fake)
+	t.Cleanup(apiserver.Close)
+
+	file := filepath.Join(t.TempDir(), "config")
+	kubeconfig := `
+current-context: test
+clusters:
+- name: test
+  cluster: {server: "` + apiserver.URL + `"}
+contexts:
+- name: test
+  context: {cluster: test}
+`
+	if err := ioutil.WriteFile(file, []byte(kubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := kube.LoadConfig([]string{file}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kube.NewClient(cfg), fake
+}
+
+const (
+	namespacePath  = "/api/v1/namespaces/kstat-system"
+	deploymentPath = "/apis/apps/v1/namespaces/kstat-system/deployments/kstat"
+)
+
+func testObjects() []kube.Object {
+	return []kube.Object{
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": "kstat-system"},
+		},
+		{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": ServerDeployment, "namespace": "kstat-system"},
+		},
+	}
+}
+
+// changeTypes returns the type of the change by the kind of the object
+func changeTypes(changes []*Change) map[string]string {
+	types := map[string]string{}
+	for _, change := range changes {
+		types[change.Object.Kind()] = change.Type
+	}
+	return types
+}
+
+func TestInstallUninstall(t *testing.T) {
+	tests := []struct {
+		name            string
+		existing        map[string]kube.Object
+		deleteNamespace bool
+		namespaceChange string
+	}{
+		{
+			name:            "namespace created by kstat",
+			existing:        map[string]kube.Object{},
+			namespaceChange: ChangeDelete,
+		},
+		{
+			name:            "namespace existed before",
+			existing:        map[string]kube.Object{namespacePath: testObjects()[0]},
+			namespaceChange: ChangeKeep,
+		},
+		{
+			name:            "delete the namespace existed before",
+			existing:        map[string]kube.Object{namespacePath: testObjects()[0]},
+			deleteNamespace: true,
+			namespaceChange: ChangeDelete,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			kc, fake := newFakeCluster(t, tt.existing)
+			values := DefaultValues()
+
+			changes, err := Plan(ctx, kc, testObjects())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := Execute(ctx, kc, changes, ioutil.Discard); err != nil {
+				t.Fatal(err)
+			}
+			if installed, err := Installed(ctx, kc, values); err != nil || !installed {
+				t.Fatalf("installed %v %v after the install", installed, err)
+			}
+
+			// upgrade keeps the annotation of the namespace created
+			changes, err = Plan(ctx, kc, testObjects())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, change := range changes {
+				if change.Type != ChangeUnchanged {
+					t.Errorf("%v is changed on upgrade:\n%v", change.Object, change.Diff)
+				}
+			}
+
+			changes, err = PlanUninstall(ctx, kc, testObjects(), tt.deleteNamespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			types := changeTypes(changes)
+			if types["Deployment"] != ChangeDelete || types["Namespace"] != tt.namespaceChange {
+				t.Errorf("got the changes %v, want the namespace to %v", types, tt.namespaceChange)
+			}
+			if err := Execute(ctx, kc, changes, ioutil.Discard); err != nil {
+				t.Fatal(err)
+			}
+			if _, exists := fake.objects[deploymentPath]; exists {
+				t.Errorf("the deployment is not deleted")
+			}
+			if _, exists := fake.objects[namespacePath]; exists != (tt.namespaceChange == ChangeKeep) {
+				t.Errorf("the namespace exists %v after uninstall, want %v", exists, tt.namespaceChange == ChangeKeep)
+			}
+			if installed, err := Installed(ctx, kc, values); err != nil || installed {
+				t.Errorf("installed %v %v after the uninstall", installed, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package manifest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/yasker/kstat/cfg"
+	"github.com/yasker/kstat/deploy"
+	"github.com/yasker/kstat/pkg/kube"
+)
+
+const (
+	DocumentSeparator = "---\n"
+)
+
+// Render returns the manifests of all the objects of kstat with the values,
+// in the order to create them
+func Render(values *Values) ([]byte, error) {
+	if err := values.Validate(); err != nil {
+		return nil, err
+	}
+	files, err := fs.Glob(deploy.FS, "*.yaml")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot find the manifest templates")
+	}
+	sort.Strings(files)
+
+	output := &bytes.Buffer{}
+	for _, file := range files {
+		data, err := deploy.FS.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read the manifest template %v", file)
+		}
+		tmpl, err := template.New(file).Funcs(templateFuncs()).Option("missingkey=error").Parse(string(data))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid manifest template %v", file)
+		}
+		if err := tmpl.Execute(output, values); err != nil {
+			return nil, errors.Wrapf(err, "cannot render the manifest template %v", file)
+		}
+		output.WriteString(DocumentSeparator)
+	}
+	return output.Bytes(), nil
+}
+
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"config":    configFile,
+		"resources": resourcesYAML,
+		"nindent":   nindent,
+	}
+}
+
+// configFile returns the default configuration file compiled into the binary
+func configFile(name string) (string, error) {
+	data, err := cfg.FS.ReadFile(name)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot read the default configuration file %v", name)
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+// resourcesYAML returns the resources field of the container, or empty if
+// there is no request or limit
+func resourcesYAML(r Resources) string {
+	lines := []string{}
+	for _, section := range []struct {
+		name   string
+		values map[string]string
+	}{
+		{"limits", r.Limits},
+		{"requests", r.Requests},
+	} {
+		keys := []string{}
+		for k, v := range section.values {
+			if v != "" {
+				keys = append(keys, k)
+			}
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		sort.Strings(keys)
+		lines = append(lines, "  "+section.name+":")
+		for _, k := range keys {
+			lines = append(lines, fmt.Sprintf("    %v: %v", k, section.values[k]))
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(append([]string{"resources:"}, lines...), "\n")
+}
+
+// nindent starts the text on a new line, with every non-empty line indented
+func nindent(spaces int, text string) string {
+	if text == "" {
+		return ""
+	}
+	prefix := strings.Repeat(" ", spaces)
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return "\n" + strings.Join(lines, "\n")
+}
+
+// Objects parses the manifests into the objects, in the same order
+func Objects(manifests []byte) ([]kube.Object, error) {
+	objects := []kube.Object{}
+	decoder := yaml.NewDecoder(bytes.NewReader(manifests))
+	for {
+		doc := map[interface{}]interface{}{}
+		if err := decoder.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "invalid manifests")
+		}
+		if len(doc) == 0 {
+			continue
+		}
+		obj, ok := kube.ConvertYAML(doc).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid object in manifests")
+		}
+		objects = append(objects, kube.Object(obj))
+	}
+	return objects, nil
+}
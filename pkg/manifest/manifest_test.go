@@ -0,0 +1,36 @@
+package manifest
+
+import (
+	"testing"
+)
+
+func TestRenderClusterScopedNames(t *testing.T) {
+	// the cluster scoped objects of the installs in different namespaces
+	// must not overwrite each other
+	owners := map[string]string{}
+	for _, namespace := range []string{"kstat-system", "monitoring"} {
+		values := DefaultValues()
+		values.Namespace = namespace
+		manifests, err := Render(values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		objects, err := Objects(manifests)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range objects {
+			if obj.Namespace() != "" {
+				continue
+			}
+			key := obj.Kind() + "/" + obj.Name()
+			if owner, exists := owners[key]; exists {
+				t.Errorf("%v is in both the installs in %v and %v", key, owner, namespace)
+			}
+			owners[key] = namespace
+		}
+	}
+	if len(owners) == 0 {
+		t.Errorf("no cluster scoped object is rendered")
+	}
+}
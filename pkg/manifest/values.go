@@ -0,0 +1,189 @@
+package manifest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/yasker/kstat/pkg/version"
+)
+
+const (
+	DefaultNamespace = "kstat-system"
+	DefaultImageRepo = "yasker/kstat"
+	// DevImageTag is the image of the development builds, which are not
+	// released with a version
+	DevImageTag = "dev"
+	DevVersion  = "v0.0.0-dev"
+
+	PullPolicyAlways       = "Always"
+	PullPolicyIfNotPresent = "IfNotPresent"
+	PullPolicyNever        = "Never"
+)
+
+var (
+	namespaceRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+)
+
+// Values are the options of the manifests, which can be set by a values
+// file and --set, e.g. --set prometheus.retention_size=1GB
+type Values struct {
+	Namespace string `yaml:"namespace"`
+	Image     string `yaml:"image"`
+	// ImagePullPolicy is Always for the development image and IfNotPresent
+	// for the others if not set
+	ImagePullPolicy string    `yaml:"image_pull_policy"`
+	Resources       Resources `yaml:"resources"`
+
+	Prometheus     PrometheusValues `yaml:"prometheus"`
+	ConfigReloader ComponentValues  `yaml:"config_reloader"`
+	NodeExporter   ComponentValues  `yaml:"node_exporter"`
+}
+
+type PrometheusValues struct {
+	Image string `yaml:"image"`
+	// RetentionSize is the maximum size of the storage, e.g. 100MB
+	RetentionSize string `yaml:"retention_size"`
+	// RetentionTime is how long to keep the metrics, e.g. 7d, no limit if
+	// empty
+	RetentionTime string    `yaml:"retention_time"`
+	Resources     Resources `yaml:"resources"`
+}
+
+type ComponentValues struct {
+	Image     string    `yaml:"image"`
+	Resources Resources `yaml:"resources"`
+}
+
+// Resources are the resource requests and limits of the container, e.g.
+// {cpu: 100m, memory: 50Mi}. The empty values are left out.
+type Resources struct {
+	Limits   map[string]string `yaml:"limits"`
+	Requests map[string]string `yaml:"requests"`
+}
+
+// DefaultImage returns the kstat image of the version of the binary, or the
+// development image if the binary is not released
+func DefaultImage() string {
+	if version.Version == DevVersion {
+		return DefaultImageRepo + ":" + DevImageTag
+	}
+	return DefaultImageRepo + ":" + version.Version
+}
+
+func DefaultValues() *Values {
+	return &Values{
+		Namespace: DefaultNamespace,
+		Image:     DefaultImage(),
+		Prometheus: PrometheusValues{
+			Image:         "prom/prometheus:v2.28.1",
+			RetentionSize: "100MB",
+			Resources: Resources{
+				Limits:   map[string]string{"cpu": "1000m", "memory": "500Mi"},
+				Requests: map[string]string{"cpu": "500m", "memory": "300Mi"},
+			},
+		},
+		ConfigReloader: ComponentValues{
+			Image: "ghcr.io/prometheus-operator/prometheus-config-reloader:v0.49.0",
+			Resources: Resources{
+				Limits:   map[string]string{"cpu": "100m", "memory": "50Mi"},
+				Requests: map[string]string{"cpu": "100m", "memory": "50Mi"},
+			},
+		},
+		NodeExporter: ComponentValues{
+			Image: "prom/node-exporter:v1.2.1",
+			Resources: Resources{
+				Limits:   map[string]string{"cpu": "200m", "memory": "50Mi"},
+				Requests: map[string]string{"cpu": "100m", "memory": "30Mi"},
+			},
+		},
+	}
+}
+
+// PullPolicy returns the image pull policy of the kstat image
+func (v *Values) PullPolicy() string {
+	if v.ImagePullPolicy != "" {
+		return v.ImagePullPolicy
+	}
+	// the development image is updated in place
+	if strings.HasSuffix(v.Image, ":"+DevImageTag) {
+		return PullPolicyAlways
+	}
+	return PullPolicyIfNotPresent
+}
+
+// LoadValuesFile overrides the values by the yaml file, the maps of the
+// resources are merged
+func (v *Values) LoadValuesFile(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.Wrapf(err, "cannot read the values file %v", file)
+	}
+	if err := v.merge(data); err != nil {
+		return errors.Wrapf(err, "invalid values file %v", file)
+	}
+	return nil
+}
+
+// Set overrides the value at the dotted path, e.g.
+// prometheus.resources.limits.memory=1Gi
+func (v *Values) Set(assignment string) error {
+	parts := strings.SplitN(assignment, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("invalid value %v, must be path=value, e.g. prometheus.retention_size=1GB", assignment)
+	}
+	keys := strings.Split(parts[0], ".")
+	var value interface{} = parts[1]
+	for i := len(keys) - 1; i >= 0; i-- {
+		if keys[i] == "" {
+			return fmt.Errorf("invalid path %v", parts[0])
+		}
+		value = map[string]interface{}{keys[i]: value}
+	}
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return errors.Wrapf(err, "invalid value %v", assignment)
+	}
+	if err := v.merge(data); err != nil {
+		return errors.Wrapf(err, "invalid value %v", assignment)
+	}
+	return nil
+}
+
+// merge overrides the values by the yaml, which cannot have unknown fields
+func (v *Values) merge(data []byte) error {
+	// the strict mode doesn't allow overriding the keys of the maps, so
+	// only check the fields with it
+	if err := yaml.UnmarshalStrict(data, &Values{}); err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, v)
+}
+
+func (v *Values) Validate() error {
+	if !namespaceRegex.MatchString(v.Namespace) {
+		return fmt.Errorf("invalid namespace %v", v.Namespace)
+	}
+	for name, value := range map[string]string{
+		"image":                     v.Image,
+		"prometheus.image":          v.Prometheus.Image,
+		"prometheus.retention_size": v.Prometheus.RetentionSize,
+		"config_reloader.image":     v.ConfigReloader.Image,
+		"node_exporter.image":       v.NodeExporter.Image,
+	} {
+		if value == "" {
+			return fmt.Errorf("%v is required", name)
+		}
+	}
+	switch v.ImagePullPolicy {
+	case "", PullPolicyAlways, PullPolicyIfNotPresent, PullPolicyNever:
+	default:
+		return fmt.Errorf("invalid image_pull_policy %v, must be %v, %v or %v",
+			v.ImagePullPolicy, PullPolicyAlways, PullPolicyIfNotPresent, PullPolicyNever)
+	}
+	return nil
+}
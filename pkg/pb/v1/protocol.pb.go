@@ -24,7 +24,81 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
+// Filter selects the instances and devices in the response
+type Filter struct {
+	// where is the expression over the metrics, e.g. `cpu_idle < 20`
+	Where         string `protobuf:"bytes,1,opt,name=where,proto3" json:"where,omitempty"`
+	InstanceRegex string `protobuf:"bytes,2,opt,name=instance_regex,json=instanceRegex,proto3" json:"instance_regex,omitempty"`
+	DeviceRegex   string `protobuf:"bytes,3,opt,name=device_regex,json=deviceRegex,proto3" json:"device_regex,omitempty"`
+	// label_selector matches the labels of the series, e.g.
+	// `namespace=default,pod=~nginx.*`
+	LabelSelector        string   `protobuf:"bytes,4,opt,name=label_selector,json=labelSelector,proto3" json:"label_selector,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Filter) Reset()         { *m = Filter{} }
+func (m *Filter) String() string { return proto.CompactTextString(m) }
+func (*Filter) ProtoMessage()    {}
+func (*Filter) Descriptor() ([]byte, []int) {
+	return fileDescriptor_47abfcb77a0ae7f5, []int{0}
+}
+
+func (m *Filter) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Filter.Unmarshal(m, b)
+}
+func (m *Filter) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Filter.Marshal(b, m, deterministic)
+}
+func (m *Filter) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Filter.Merge(m, src)
+}
+func (m *Filter) XXX_Size() int {
+	return xxx_messageInfo_Filter.Size(m)
+}
+func (m *Filter) XXX_DiscardUnknown() {
+	xxx_messageInfo_Filter.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Filter proto.InternalMessageInfo
+
+func (m *Filter) GetWhere() string {
+	if m != nil {
+		return m.Where
+	}
+	return ""
+}
+
+func (m *Filter) GetInstanceRegex() string {
+	if m != nil {
+		return m.InstanceRegex
+	}
+	return ""
+}
+
+func (m *Filter) GetDeviceRegex() string {
+	if m != nil {
+		return m.DeviceRegex
+	}
+	return ""
+}
+
+func (m *Filter) GetLabelSelector() string {
+	if m != nil {
+		return m.LabelSelector
+	}
+	return ""
+}
+
 type WatchRequest struct {
+	Filter *Filter `protobuf:"bytes,1,opt,name=filter,proto3" json:"filter,omitempty"`
+	// profile is the named set of the metrics, e.g. pod, the default
+	// profile if empty
+	Profile string `protobuf:"bytes,2,opt,name=profile,proto3" json:"profile,omitempty"`
+	// with_labels asks for the labels of the instances and devices, e.g.
+	// for the label selector and the detail view on the client
+	WithLabels           bool     `protobuf:"varint,3,opt,name=with_labels,json=withLabels,proto3" json:"with_labels,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -34,7 +108,7 @@ func (m *WatchRequest) Reset()         { *m = WatchRequest{} }
 func (m *WatchRequest) String() string { return proto.CompactTextString(m) }
 func (*WatchRequest) ProtoMessage()    {}
 func (*WatchRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_47abfcb77a0ae7f5, []int{0}
+	return fileDescriptor_47abfcb77a0ae7f5, []int{1}
 }
 
 func (m *WatchRequest) XXX_Unmarshal(b []byte) error {
@@ -55,17 +129,39 @@ func (m *WatchRequest) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_WatchRequest proto.InternalMessageInfo
 
+func (m *WatchRequest) GetFilter() *Filter {
+	if m != nil {
+		return m.Filter
+	}
+	return nil
+}
+
+func (m *WatchRequest) GetProfile() string {
+	if m != nil {
+		return m.Profile
+	}
+	return ""
+}
+
+func (m *WatchRequest) GetWithLabels() bool {
+	if m != nil {
+		return m.WithLabels
+	}
+	return false
+}
+
 type WatchResponse struct {
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	Metrics              *GetMetricsResponse `protobuf:"bytes,1,opt,name=metrics,proto3" json:"metrics,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}            `json:"-"`
+	XXX_unrecognized     []byte              `json:"-"`
+	XXX_sizecache        int32               `json:"-"`
 }
 
 func (m *WatchResponse) Reset()         { *m = WatchResponse{} }
 func (m *WatchResponse) String() string { return proto.CompactTextString(m) }
 func (*WatchResponse) ProtoMessage()    {}
 func (*WatchResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_47abfcb77a0ae7f5, []int{1}
+	return fileDescriptor_47abfcb77a0ae7f5, []int{2}
 }
 
 func (m *WatchResponse) XXX_Unmarshal(b []byte) error {
@@ -86,7 +182,17 @@ func (m *WatchResponse) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_WatchResponse proto.InternalMessageInfo
 
+func (m *WatchResponse) GetMetrics() *GetMetricsResponse {
+	if m != nil {
+		return m.Metrics
+	}
+	return nil
+}
+
 type GetMetricsRequest struct {
+	Filter               *Filter  `protobuf:"bytes,1,opt,name=filter,proto3" json:"filter,omitempty"`
+	Profile              string   `protobuf:"bytes,2,opt,name=profile,proto3" json:"profile,omitempty"`
+	WithLabels           bool     `protobuf:"varint,3,opt,name=with_labels,json=withLabels,proto3" json:"with_labels,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -96,7 +202,7 @@ func (m *GetMetricsRequest) Reset()         { *m = GetMetricsRequest{} }
 func (m *GetMetricsRequest) String() string { return proto.CompactTextString(m) }
 func (*GetMetricsRequest) ProtoMessage()    {}
 func (*GetMetricsRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_47abfcb77a0ae7f5, []int{2}
+	return fileDescriptor_47abfcb77a0ae7f5, []int{3}
 }
 
 func (m *GetMetricsRequest) XXX_Unmarshal(b []byte) error {
@@ -117,18 +223,162 @@ func (m *GetMetricsRequest) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_GetMetricsRequest proto.InternalMessageInfo
 
+func (m *GetMetricsRequest) GetFilter() *Filter {
+	if m != nil {
+		return m.Filter
+	}
+	return nil
+}
+
+func (m *GetMetricsRequest) GetProfile() string {
+	if m != nil {
+		return m.Profile
+	}
+	return ""
+}
+
+func (m *GetMetricsRequest) GetWithLabels() bool {
+	if m != nil {
+		return m.WithLabels
+	}
+	return false
+}
+
+// QueryRangeRequest asks for the snapshots of a past window, the times are in
+// Unix nanoseconds and the step is in nanoseconds
+type QueryRangeRequest struct {
+	Start                int64    `protobuf:"varint,1,opt,name=start,proto3" json:"start,omitempty"`
+	End                  int64    `protobuf:"varint,2,opt,name=end,proto3" json:"end,omitempty"`
+	Step                 int64    `protobuf:"varint,3,opt,name=step,proto3" json:"step,omitempty"`
+	Filter               *Filter  `protobuf:"bytes,4,opt,name=filter,proto3" json:"filter,omitempty"`
+	Profile              string   `protobuf:"bytes,5,opt,name=profile,proto3" json:"profile,omitempty"`
+	WithLabels           bool     `protobuf:"varint,6,opt,name=with_labels,json=withLabels,proto3" json:"with_labels,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *QueryRangeRequest) Reset()         { *m = QueryRangeRequest{} }
+func (m *QueryRangeRequest) String() string { return proto.CompactTextString(m) }
+func (*QueryRangeRequest) ProtoMessage()    {}
+func (*QueryRangeRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_47abfcb77a0ae7f5, []int{4}
+}
+
+func (m *QueryRangeRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_QueryRangeRequest.Unmarshal(m, b)
+}
+func (m *QueryRangeRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_QueryRangeRequest.Marshal(b, m, deterministic)
+}
+func (m *QueryRangeRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_QueryRangeRequest.Merge(m, src)
+}
+func (m *QueryRangeRequest) XXX_Size() int {
+	return xxx_messageInfo_QueryRangeRequest.Size(m)
+}
+func (m *QueryRangeRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_QueryRangeRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_QueryRangeRequest proto.InternalMessageInfo
+
+func (m *QueryRangeRequest) GetStart() int64 {
+	if m != nil {
+		return m.Start
+	}
+	return 0
+}
+
+func (m *QueryRangeRequest) GetEnd() int64 {
+	if m != nil {
+		return m.End
+	}
+	return 0
+}
+
+func (m *QueryRangeRequest) GetStep() int64 {
+	if m != nil {
+		return m.Step
+	}
+	return 0
+}
+
+func (m *QueryRangeRequest) GetFilter() *Filter {
+	if m != nil {
+		return m.Filter
+	}
+	return nil
+}
+
+func (m *QueryRangeRequest) GetProfile() string {
+	if m != nil {
+		return m.Profile
+	}
+	return ""
+}
+
+func (m *QueryRangeRequest) GetWithLabels() bool {
+	if m != nil {
+		return m.WithLabels
+	}
+	return false
+}
+
+// QueryRangeResponse is one snapshot of the window, sent in the time order
+type QueryRangeResponse struct {
+	Metrics              *GetMetricsResponse `protobuf:"bytes,1,opt,name=metrics,proto3" json:"metrics,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}            `json:"-"`
+	XXX_unrecognized     []byte              `json:"-"`
+	XXX_sizecache        int32               `json:"-"`
+}
+
+func (m *QueryRangeResponse) Reset()         { *m = QueryRangeResponse{} }
+func (m *QueryRangeResponse) String() string { return proto.CompactTextString(m) }
+func (*QueryRangeResponse) ProtoMessage()    {}
+func (*QueryRangeResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_47abfcb77a0ae7f5, []int{5}
+}
+
+func (m *QueryRangeResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_QueryRangeResponse.Unmarshal(m, b)
+}
+func (m *QueryRangeResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_QueryRangeResponse.Marshal(b, m, deterministic)
+}
+func (m *QueryRangeResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_QueryRangeResponse.Merge(m, src)
+}
+func (m *QueryRangeResponse) XXX_Size() int {
+	return xxx_messageInfo_QueryRangeResponse.Size(m)
+}
+func (m *QueryRangeResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_QueryRangeResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_QueryRangeResponse proto.InternalMessageInfo
+
+func (m *QueryRangeResponse) GetMetrics() *GetMetricsResponse {
+	if m != nil {
+		return m.Metrics
+	}
+	return nil
+}
+
 type GetMetricsResponse struct {
-	ClusterMetrics       map[string]*ClusterMetric `protobuf:"bytes,1,rep,name=cluster_metrics,json=clusterMetrics,proto3" json:"cluster_metrics,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
-	XXX_NoUnkeyedLiteral struct{}                  `json:"-"`
-	XXX_unrecognized     []byte                    `json:"-"`
-	XXX_sizecache        int32                     `json:"-"`
+	ClusterMetrics map[string]*ClusterMetric `protobuf:"bytes,1,rep,name=cluster_metrics,json=clusterMetrics,proto3" json:"cluster_metrics,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// timestamp is the collection time in Unix nanoseconds
+	Timestamp            int64    `protobuf:"varint,2,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *GetMetricsResponse) Reset()         { *m = GetMetricsResponse{} }
 func (m *GetMetricsResponse) String() string { return proto.CompactTextString(m) }
 func (*GetMetricsResponse) ProtoMessage()    {}
 func (*GetMetricsResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_47abfcb77a0ae7f5, []int{3}
+	return fileDescriptor_47abfcb77a0ae7f5, []int{6}
 }
 
 func (m *GetMetricsResponse) XXX_Unmarshal(b []byte) error {
@@ -156,6 +406,13 @@ func (m *GetMetricsResponse) GetClusterMetrics() map[string]*ClusterMetric {
 	return nil
 }
 
+func (m *GetMetricsResponse) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
 type ClusterMetric struct {
 	InstanceMetrics      map[string]*InstanceMetric `protobuf:"bytes,1,rep,name=instance_metrics,json=instanceMetrics,proto3" json:"instance_metrics,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
 	XXX_NoUnkeyedLiteral struct{}                   `json:"-"`
@@ -167,7 +424,7 @@ func (m *ClusterMetric) Reset()         { *m = ClusterMetric{} }
 func (m *ClusterMetric) String() string { return proto.CompactTextString(m) }
 func (*ClusterMetric) ProtoMessage()    {}
 func (*ClusterMetric) Descriptor() ([]byte, []int) {
-	return fileDescriptor_47abfcb77a0ae7f5, []int{4}
+	return fileDescriptor_47abfcb77a0ae7f5, []int{7}
 }
 
 func (m *ClusterMetric) XXX_Unmarshal(b []byte) error {
@@ -196,20 +453,24 @@ func (m *ClusterMetric) GetInstanceMetrics() map[string]*InstanceMetric {
 }
 
 type InstanceMetric struct {
-	DeviceMetrics        map[string]int64 `protobuf:"bytes,1,rep,name=device_metrics,json=deviceMetrics,proto3" json:"device_metrics,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
-	Total                int64            `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
-	Average              int64            `protobuf:"varint,3,opt,name=average,proto3" json:"average,omitempty"`
-	Value                int64            `protobuf:"varint,4,opt,name=value,proto3" json:"value,omitempty"`
-	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
-	XXX_unrecognized     []byte           `json:"-"`
-	XXX_sizecache        int32            `json:"-"`
+	DeviceMetrics map[string]int64 `protobuf:"bytes,1,rep,name=device_metrics,json=deviceMetrics,proto3" json:"device_metrics,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+	Total         int64            `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
+	Average       int64            `protobuf:"varint,3,opt,name=average,proto3" json:"average,omitempty"`
+	Value         int64            `protobuf:"varint,4,opt,name=value,proto3" json:"value,omitempty"`
+	// labels are shared by all the series of the instance
+	Labels map[string]string `protobuf:"bytes,5,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// device_labels are the labels of each device besides the shared ones
+	DeviceLabels         map[string]*Labels `protobuf:"bytes,6,rep,name=device_labels,json=deviceLabels,proto3" json:"device_labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}           `json:"-"`
+	XXX_unrecognized     []byte             `json:"-"`
+	XXX_sizecache        int32              `json:"-"`
 }
 
 func (m *InstanceMetric) Reset()         { *m = InstanceMetric{} }
 func (m *InstanceMetric) String() string { return proto.CompactTextString(m) }
 func (*InstanceMetric) ProtoMessage()    {}
 func (*InstanceMetric) Descriptor() ([]byte, []int) {
-	return fileDescriptor_47abfcb77a0ae7f5, []int{5}
+	return fileDescriptor_47abfcb77a0ae7f5, []int{8}
 }
 
 func (m *InstanceMetric) XXX_Unmarshal(b []byte) error {
@@ -258,46 +519,258 @@ func (m *InstanceMetric) GetValue() int64 {
 	return 0
 }
 
+func (m *InstanceMetric) GetLabels() map[string]string {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
+func (m *InstanceMetric) GetDeviceLabels() map[string]*Labels {
+	if m != nil {
+		return m.DeviceLabels
+	}
+	return nil
+}
+
+type Labels struct {
+	Labels               map[string]string `protobuf:"bytes,1,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *Labels) Reset()         { *m = Labels{} }
+func (m *Labels) String() string { return proto.CompactTextString(m) }
+func (*Labels) ProtoMessage()    {}
+func (*Labels) Descriptor() ([]byte, []int) {
+	return fileDescriptor_47abfcb77a0ae7f5, []int{9}
+}
+
+func (m *Labels) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Labels.Unmarshal(m, b)
+}
+func (m *Labels) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Labels.Marshal(b, m, deterministic)
+}
+func (m *Labels) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Labels.Merge(m, src)
+}
+func (m *Labels) XXX_Size() int {
+	return xxx_messageInfo_Labels.Size(m)
+}
+func (m *Labels) XXX_DiscardUnknown() {
+	xxx_messageInfo_Labels.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Labels proto.InternalMessageInfo
+
+func (m *Labels) GetLabels() map[string]string {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
+// RecordHeader is the first frame of a session recording, followed by
+// RecordFrame frames
+type RecordHeader struct {
+	Version        string `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	MetricsFormat  string `protobuf:"bytes,2,opt,name=metrics_format,json=metricsFormat,proto3" json:"metrics_format,omitempty"`
+	HeaderTemplate string `protobuf:"bytes,3,opt,name=header_template,json=headerTemplate,proto3" json:"header_template,omitempty"`
+	OutputTemplate string `protobuf:"bytes,4,opt,name=output_template,json=outputTemplate,proto3" json:"output_template,omitempty"`
+	// format is the format of the frames, the readers reject the formats
+	// they don't know
+	Format               int32    `protobuf:"varint,5,opt,name=format,proto3" json:"format,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *RecordHeader) Reset()         { *m = RecordHeader{} }
+func (m *RecordHeader) String() string { return proto.CompactTextString(m) }
+func (*RecordHeader) ProtoMessage()    {}
+func (*RecordHeader) Descriptor() ([]byte, []int) {
+	return fileDescriptor_47abfcb77a0ae7f5, []int{10}
+}
+
+func (m *RecordHeader) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RecordHeader.Unmarshal(m, b)
+}
+func (m *RecordHeader) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RecordHeader.Marshal(b, m, deterministic)
+}
+func (m *RecordHeader) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RecordHeader.Merge(m, src)
+}
+func (m *RecordHeader) XXX_Size() int {
+	return xxx_messageInfo_RecordHeader.Size(m)
+}
+func (m *RecordHeader) XXX_DiscardUnknown() {
+	xxx_messageInfo_RecordHeader.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RecordHeader proto.InternalMessageInfo
+
+func (m *RecordHeader) GetVersion() string {
+	if m != nil {
+		return m.Version
+	}
+	return ""
+}
+
+func (m *RecordHeader) GetMetricsFormat() string {
+	if m != nil {
+		return m.MetricsFormat
+	}
+	return ""
+}
+
+func (m *RecordHeader) GetHeaderTemplate() string {
+	if m != nil {
+		return m.HeaderTemplate
+	}
+	return ""
+}
+
+func (m *RecordHeader) GetOutputTemplate() string {
+	if m != nil {
+		return m.OutputTemplate
+	}
+	return ""
+}
+
+func (m *RecordHeader) GetFormat() int32 {
+	if m != nil {
+		return m.Format
+	}
+	return 0
+}
+
+// RecordFrame is either a snapshot, or the new configuration of the snapshots
+// after it, e.g. after the configuration files are reloaded
+type RecordFrame struct {
+	Metrics              *GetMetricsResponse `protobuf:"bytes,1,opt,name=metrics,proto3" json:"metrics,omitempty"`
+	Header               *RecordHeader       `protobuf:"bytes,2,opt,name=header,proto3" json:"header,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}            `json:"-"`
+	XXX_unrecognized     []byte              `json:"-"`
+	XXX_sizecache        int32               `json:"-"`
+}
+
+func (m *RecordFrame) Reset()         { *m = RecordFrame{} }
+func (m *RecordFrame) String() string { return proto.CompactTextString(m) }
+func (*RecordFrame) ProtoMessage()    {}
+func (*RecordFrame) Descriptor() ([]byte, []int) {
+	return fileDescriptor_47abfcb77a0ae7f5, []int{11}
+}
+
+func (m *RecordFrame) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_RecordFrame.Unmarshal(m, b)
+}
+func (m *RecordFrame) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_RecordFrame.Marshal(b, m, deterministic)
+}
+func (m *RecordFrame) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_RecordFrame.Merge(m, src)
+}
+func (m *RecordFrame) XXX_Size() int {
+	return xxx_messageInfo_RecordFrame.Size(m)
+}
+func (m *RecordFrame) XXX_DiscardUnknown() {
+	xxx_messageInfo_RecordFrame.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_RecordFrame proto.InternalMessageInfo
+
+func (m *RecordFrame) GetMetrics() *GetMetricsResponse {
+	if m != nil {
+		return m.Metrics
+	}
+	return nil
+}
+
+func (m *RecordFrame) GetHeader() *RecordHeader {
+	if m != nil {
+		return m.Header
+	}
+	return nil
+}
+
 func init() {
+	proto.RegisterType((*Filter)(nil), "pb.v1.Filter")
 	proto.RegisterType((*WatchRequest)(nil), "pb.v1.WatchRequest")
 	proto.RegisterType((*WatchResponse)(nil), "pb.v1.WatchResponse")
 	proto.RegisterType((*GetMetricsRequest)(nil), "pb.v1.GetMetricsRequest")
+	proto.RegisterType((*QueryRangeRequest)(nil), "pb.v1.QueryRangeRequest")
+	proto.RegisterType((*QueryRangeResponse)(nil), "pb.v1.QueryRangeResponse")
 	proto.RegisterType((*GetMetricsResponse)(nil), "pb.v1.GetMetricsResponse")
 	proto.RegisterMapType((map[string]*ClusterMetric)(nil), "pb.v1.GetMetricsResponse.ClusterMetricsEntry")
 	proto.RegisterType((*ClusterMetric)(nil), "pb.v1.ClusterMetric")
 	proto.RegisterMapType((map[string]*InstanceMetric)(nil), "pb.v1.ClusterMetric.InstanceMetricsEntry")
 	proto.RegisterType((*InstanceMetric)(nil), "pb.v1.InstanceMetric")
+	proto.RegisterMapType((map[string]*Labels)(nil), "pb.v1.InstanceMetric.DeviceLabelsEntry")
 	proto.RegisterMapType((map[string]int64)(nil), "pb.v1.InstanceMetric.DeviceMetricsEntry")
+	proto.RegisterMapType((map[string]string)(nil), "pb.v1.InstanceMetric.LabelsEntry")
+	proto.RegisterType((*Labels)(nil), "pb.v1.Labels")
+	proto.RegisterMapType((map[string]string)(nil), "pb.v1.Labels.LabelsEntry")
+	proto.RegisterType((*RecordHeader)(nil), "pb.v1.RecordHeader")
+	proto.RegisterType((*RecordFrame)(nil), "pb.v1.RecordFrame")
 }
 
 func init() { proto.RegisterFile("pb/v1/protocol.proto", fileDescriptor_47abfcb77a0ae7f5) }
 
 var fileDescriptor_47abfcb77a0ae7f5 = []byte{
-	// 383 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x74, 0x53, 0xc1, 0x4a, 0xeb, 0x40,
-	0x14, 0xed, 0x34, 0x2f, 0xef, 0xf1, 0x6e, 0x6d, 0x5a, 0x6f, 0x23, 0xc4, 0xac, 0x4a, 0x56, 0x51,
-	0x31, 0xb5, 0x15, 0x44, 0x5c, 0x09, 0x55, 0xc4, 0x85, 0x08, 0x51, 0x2c, 0xae, 0x24, 0x4d, 0x07,
-	0x0d, 0xc6, 0x24, 0x26, 0xd3, 0x40, 0x3f, 0xc2, 0x7f, 0x72, 0xe3, 0xc7, 0xf8, 0x17, 0xd2, 0x99,
-	0x29, 0x66, 0x6c, 0xdc, 0xcd, 0x3d, 0xf7, 0x9c, 0x7b, 0xe7, 0x0c, 0x67, 0xc0, 0xcc, 0xa6, 0x83,
-	0x72, 0x38, 0xc8, 0xf2, 0x94, 0xa5, 0x61, 0x1a, 0x7b, 0xfc, 0x80, 0x7a, 0x36, 0xf5, 0xca, 0xa1,
-	0x63, 0xc0, 0xc6, 0x24, 0x60, 0xe1, 0x93, 0x4f, 0x5f, 0xe7, 0xb4, 0x60, 0x4e, 0x07, 0xda, 0xb2,
-	0x2e, 0xb2, 0x34, 0x29, 0xa8, 0xd3, 0x83, 0xcd, 0x0b, 0xca, 0xae, 0x28, 0xcb, 0xa3, 0xb0, 0x58,
-	0xb1, 0x3e, 0x08, 0x60, 0x15, 0x15, 0x5c, 0xbc, 0x83, 0x4e, 0x18, 0xcf, 0x0b, 0x46, 0xf3, 0x87,
-	0x17, 0xd1, 0xb2, 0x48, 0x5f, 0x73, 0x5b, 0xa3, 0x7d, 0x8f, 0x6f, 0xf3, 0xd6, 0x35, 0xde, 0x58,
-	0x08, 0x24, 0x7c, 0x9e, 0xb0, 0x7c, 0xe1, 0x1b, 0xa1, 0x02, 0xda, 0x13, 0xe8, 0xd5, 0xd0, 0xb0,
-	0x0b, 0xda, 0x33, 0x5d, 0x58, 0xa4, 0x4f, 0xdc, 0xff, 0xfe, 0xf2, 0x88, 0xbb, 0xa0, 0x97, 0x41,
-	0x3c, 0xa7, 0x56, 0xb3, 0x4f, 0xdc, 0xd6, 0xc8, 0x94, 0x6b, 0x15, 0xb1, 0x2f, 0x28, 0x27, 0xcd,
-	0x63, 0xe2, 0xbc, 0x13, 0x68, 0x2b, 0x4d, 0xbc, 0x85, 0x6e, 0x94, 0x14, 0x2c, 0x48, 0x42, 0xfa,
-	0xc3, 0xc3, 0x4e, 0xdd, 0x30, 0xef, 0x52, 0x92, 0x95, 0xfb, 0x77, 0x22, 0x15, 0xb5, 0xef, 0xc1,
-	0xac, 0x23, 0xd6, 0x38, 0xd8, 0x53, 0x1d, 0x6c, 0xc9, 0xa5, 0xaa, 0xba, 0x6a, 0xe1, 0x93, 0x80,
-	0xa1, 0x76, 0xf1, 0x1a, 0x8c, 0x19, 0x2d, 0xa3, 0x35, 0x07, 0x6e, 0xed, 0x30, 0xef, 0x8c, 0x73,
-	0x15, 0x03, 0xed, 0x59, 0x15, 0x43, 0x13, 0x74, 0x96, 0xb2, 0x20, 0xe6, 0x97, 0xd2, 0x7c, 0x51,
-	0xa0, 0x05, 0xff, 0x82, 0x92, 0xe6, 0xc1, 0x23, 0xb5, 0x34, 0x8e, 0xaf, 0xca, 0x25, 0x5f, 0x98,
-	0xf8, 0x23, 0xf8, 0xbc, 0xb0, 0x4f, 0x01, 0xd7, 0x57, 0xd5, 0x3c, 0x81, 0x59, 0x7d, 0x02, 0xad,
-	0xe2, 0x75, 0xf4, 0x46, 0xc0, 0x90, 0xe2, 0x1b, 0x9a, 0x2f, 0x47, 0xe1, 0x11, 0xe8, 0x3c, 0xaf,
-	0xd8, 0x93, 0xe6, 0xaa, 0x69, 0xb6, 0x4d, 0x15, 0x94, 0x91, 0x6e, 0x1c, 0x10, 0x1c, 0x03, 0x7c,
-	0x87, 0x11, 0xad, 0x9a, 0x7c, 0x8a, 0x09, 0xdb, 0xbf, 0x26, 0xd7, 0x69, 0x4c, 0xff, 0xf2, 0xaf,
-	0x74, 0xf8, 0x15, 0x00, 0x00, 0xff, 0xff, 0x4a, 0xee, 0xec, 0xe4, 0x62, 0x03, 0x00, 0x00,
+	// 797 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xbc, 0x56, 0xdd, 0x6e, 0xd3, 0x4a,
+	0x10, 0xae, 0xeb, 0xd8, 0x3d, 0x9d, 0x24, 0x6e, 0xbb, 0xcd, 0x39, 0x4a, 0xa3, 0x23, 0x41, 0x8d,
+	0xaa, 0x16, 0x2a, 0x52, 0x9a, 0x4a, 0x88, 0x72, 0x85, 0xd4, 0x1f, 0xa8, 0x54, 0x40, 0xb8, 0x15,
+	0x15, 0x57, 0x91, 0xe3, 0x4c, 0x1b, 0x0b, 0x27, 0x36, 0xeb, 0x4d, 0x4a, 0x9f, 0x82, 0x67, 0xe1,
+	0x92, 0x3b, 0x1e, 0x82, 0x37, 0xe0, 0x39, 0x90, 0x90, 0x77, 0xc7, 0x89, 0xad, 0xb8, 0x05, 0x51,
+	0x89, 0x3b, 0xef, 0x37, 0x33, 0xdf, 0x7c, 0xb3, 0x33, 0xd9, 0x09, 0xd4, 0xa2, 0xce, 0xd6, 0x68,
+	0x7b, 0x2b, 0xe2, 0xa1, 0x08, 0xbd, 0x30, 0x68, 0xca, 0x0f, 0x66, 0x44, 0x9d, 0xe6, 0x68, 0xdb,
+	0xfe, 0xa4, 0x81, 0x79, 0xe8, 0x07, 0x02, 0x39, 0xab, 0x81, 0x71, 0xd9, 0x43, 0x8e, 0x75, 0xed,
+	0xae, 0xb6, 0x31, 0xef, 0xa8, 0x03, 0x5b, 0x03, 0xcb, 0x1f, 0xc4, 0xc2, 0x1d, 0x78, 0xd8, 0xe6,
+	0x78, 0x81, 0x1f, 0xeb, 0xb3, 0xd2, 0x5c, 0x4d, 0x51, 0x27, 0x01, 0xd9, 0x2a, 0x54, 0xba, 0x38,
+	0xf2, 0xc7, 0x4e, 0xba, 0x74, 0x2a, 0x2b, 0x4c, 0xb9, 0xac, 0x81, 0x15, 0xb8, 0x1d, 0x0c, 0xda,
+	0x31, 0x06, 0xe8, 0x89, 0x90, 0xd7, 0x4b, 0x8a, 0x49, 0xa2, 0x27, 0x04, 0xda, 0x11, 0x54, 0xce,
+	0x5c, 0xe1, 0xf5, 0x1c, 0xfc, 0x30, 0xc4, 0x58, 0xb0, 0x35, 0x30, 0xcf, 0xa5, 0x40, 0xa9, 0xab,
+	0xdc, 0xaa, 0x36, 0xa5, 0xf2, 0xa6, 0x52, 0xed, 0x90, 0x91, 0xd5, 0x61, 0x2e, 0xe2, 0xe1, 0xb9,
+	0x1f, 0x20, 0x09, 0x4c, 0x8f, 0xec, 0x0e, 0x94, 0x2f, 0x7d, 0xd1, 0x6b, 0xcb, 0x34, 0xb1, 0x54,
+	0xf6, 0x8f, 0x03, 0x09, 0x74, 0x2c, 0x11, 0x7b, 0x1f, 0xaa, 0x94, 0x31, 0x8e, 0xc2, 0x41, 0x8c,
+	0x6c, 0x07, 0xe6, 0xfa, 0x28, 0xb8, 0xef, 0xc5, 0x94, 0x73, 0x85, 0x72, 0x3e, 0x47, 0xf1, 0x52,
+	0x19, 0x52, 0x5f, 0x27, 0xf5, 0xb4, 0x87, 0xb0, 0x94, 0x35, 0xff, 0x2d, 0xf1, 0x9f, 0x35, 0x58,
+	0x7a, 0x33, 0x44, 0x7e, 0xe5, 0xb8, 0x83, 0x0b, 0x4c, 0xf3, 0xd6, 0xc0, 0x88, 0x85, 0xcb, 0x85,
+	0x4c, 0xab, 0x3b, 0xea, 0xc0, 0x16, 0x41, 0xc7, 0x41, 0x57, 0xa6, 0xd0, 0x9d, 0xe4, 0x93, 0x31,
+	0x28, 0xc5, 0x02, 0x23, 0xc9, 0xab, 0x3b, 0xf2, 0x3b, 0xa3, 0xb9, 0xf4, 0x9b, 0x9a, 0x8d, 0x1b,
+	0x35, 0x9b, 0x53, 0x9a, 0x8f, 0x80, 0x65, 0x25, 0xdf, 0xe6, 0xd6, 0xbf, 0x6b, 0xc0, 0xa6, 0xed,
+	0xec, 0x2d, 0x2c, 0x78, 0xc1, 0x30, 0x16, 0xc8, 0xdb, 0x13, 0x4e, 0x7d, 0xa3, 0xdc, 0x7a, 0x78,
+	0x2d, 0x67, 0x73, 0x4f, 0x05, 0x10, 0x7c, 0x30, 0x10, 0xfc, 0xca, 0xb1, 0xbc, 0x1c, 0xc8, 0xfe,
+	0x87, 0x79, 0xe1, 0xf7, 0x31, 0x16, 0x6e, 0x3f, 0xa2, 0x7b, 0x9c, 0x00, 0x8d, 0x33, 0x58, 0x2e,
+	0x20, 0x49, 0xae, 0xfd, 0x3d, 0x5e, 0xd1, 0xcf, 0x2a, 0xf9, 0x64, 0x0f, 0xc0, 0x18, 0xb9, 0xc1,
+	0x50, 0x75, 0xbb, 0xdc, 0xaa, 0x91, 0xa8, 0x5c, 0xb0, 0xa3, 0x5c, 0x9e, 0xce, 0x3e, 0xd1, 0xec,
+	0xaf, 0x1a, 0x54, 0x73, 0x46, 0x76, 0x0a, 0x8b, 0xe3, 0x9f, 0x65, 0xbe, 0xc2, 0xfb, 0x45, 0x64,
+	0xcd, 0x23, 0x72, 0xce, 0x55, 0xb7, 0xe0, 0xe7, 0xd1, 0xc6, 0x3b, 0xa8, 0x15, 0x39, 0x16, 0x54,
+	0xb0, 0x99, 0xaf, 0xe0, 0x5f, 0x4a, 0x9a, 0x8f, 0xce, 0x96, 0xf0, 0x43, 0x07, 0x2b, 0x6f, 0x65,
+	0xaf, 0xc1, 0xa2, 0x37, 0x23, 0x5f, 0xc1, 0x46, 0x21, 0x59, 0x73, 0x5f, 0xfa, 0xe6, 0x0a, 0xa8,
+	0x76, 0xb3, 0x58, 0x32, 0xf5, 0x22, 0x14, 0x6e, 0x40, 0x9d, 0x51, 0x87, 0x64, 0x50, 0xdd, 0x11,
+	0x72, 0xf7, 0x02, 0x69, 0xcc, 0xd3, 0x63, 0xe2, 0xaf, 0x8a, 0x28, 0x29, 0x7f, 0x79, 0x60, 0xbb,
+	0x60, 0xd2, 0xe4, 0x1a, 0x52, 0xce, 0x6a, 0xb1, 0x1c, 0x35, 0xcb, 0x4a, 0x07, 0x05, 0xb0, 0x63,
+	0x20, 0x45, 0x93, 0xd9, 0x4f, 0x18, 0xd6, 0x6f, 0x2a, 0x28, 0xcb, 0x53, 0xe9, 0x66, 0xa0, 0xc6,
+	0x33, 0x60, 0xd3, 0x35, 0x17, 0xf4, 0xa2, 0x96, 0xed, 0x85, 0x9e, 0xb9, 0xf4, 0xc6, 0x2e, 0x94,
+	0x33, 0xf4, 0xbf, 0x0a, 0x9d, 0xcf, 0x86, 0xbe, 0x82, 0xa5, 0x29, 0x7d, 0x05, 0x04, 0xf7, 0xf2,
+	0x73, 0x90, 0xbe, 0x15, 0x2a, 0x28, 0xdb, 0xff, 0x11, 0x98, 0x0a, 0x64, 0xdb, 0xe3, 0xfb, 0x55,
+	0xed, 0x5e, 0xc9, 0xc5, 0x14, 0xdd, 0xeb, 0x2d, 0xea, 0xb0, 0xbf, 0x68, 0x50, 0x71, 0xd0, 0x0b,
+	0x79, 0xf7, 0x05, 0xba, 0x5d, 0xf5, 0x6e, 0x8d, 0x90, 0xc7, 0x7e, 0x38, 0x20, 0x82, 0xf4, 0x98,
+	0x2c, 0x28, 0x1a, 0xc4, 0xf6, 0x79, 0xc8, 0xfb, 0xae, 0x48, 0x57, 0x1d, 0xa1, 0x87, 0x12, 0x64,
+	0xeb, 0xb0, 0xd0, 0x93, 0x54, 0x6d, 0x81, 0xfd, 0x28, 0x70, 0x05, 0xd2, 0xb6, 0xb3, 0x14, 0x7c,
+	0x4a, 0x68, 0xe2, 0x18, 0x0e, 0x45, 0x34, 0x14, 0x13, 0x47, 0xb5, 0xf1, 0x2c, 0x05, 0x8f, 0x1d,
+	0xff, 0x03, 0x93, 0x12, 0x26, 0x2f, 0xa9, 0xe1, 0xd0, 0xc9, 0x0e, 0xa1, 0xac, 0xa4, 0x1f, 0x72,
+	0xb7, 0xff, 0x67, 0x0f, 0x24, 0xdb, 0x04, 0x53, 0xc9, 0xa2, 0x0e, 0x2d, 0x53, 0x4c, 0xf6, 0x4e,
+	0x1c, 0x72, 0x69, 0x7d, 0xd3, 0xc0, 0x22, 0xa6, 0x13, 0xe4, 0x49, 0xf7, 0xd9, 0x63, 0x30, 0xe4,
+	0x72, 0x64, 0x69, 0x60, 0x76, 0x39, 0x37, 0x6a, 0x79, 0x50, 0x25, 0xb7, 0x67, 0x1e, 0x69, 0x6c,
+	0x0f, 0x60, 0x22, 0x8b, 0xd5, 0x0b, 0x94, 0x2a, 0x86, 0xeb, 0x6b, 0xb0, 0x67, 0xd8, 0x01, 0xc0,
+	0x64, 0x51, 0x8c, 0x49, 0xa6, 0xd6, 0x5d, 0x63, 0xa5, 0xc0, 0x32, 0xd1, 0xd2, 0x31, 0xe5, 0x5f,
+	0x9e, 0x9d, 0x9f, 0x03, 0x00, 0x4a, 0x61, 0xbf, 0x8c, 0x0a, 0x09, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -314,6 +787,7 @@ const _ = grpc.SupportPackageIsVersion4
 type MetricsServiceClient interface {
 	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (MetricsService_WatchClient, error)
 	GetMetrics(ctx context.Context, in *GetMetricsRequest, opts ...grpc.CallOption) (*GetMetricsResponse, error)
+	QueryRange(ctx context.Context, in *QueryRangeRequest, opts ...grpc.CallOption) (MetricsService_QueryRangeClient, error)
 }
 
 type metricsServiceClient struct {
@@ -365,10 +839,43 @@ func (c *metricsServiceClient) GetMetrics(ctx context.Context, in *GetMetricsReq
 	return out, nil
 }
 
+func (c *metricsServiceClient) QueryRange(ctx context.Context, in *QueryRangeRequest, opts ...grpc.CallOption) (MetricsService_QueryRangeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_MetricsService_serviceDesc.Streams[1], "/pb.v1.MetricsService/QueryRange", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &metricsServiceQueryRangeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MetricsService_QueryRangeClient interface {
+	Recv() (*QueryRangeResponse, error)
+	grpc.ClientStream
+}
+
+type metricsServiceQueryRangeClient struct {
+	grpc.ClientStream
+}
+
+func (x *metricsServiceQueryRangeClient) Recv() (*QueryRangeResponse, error) {
+	m := new(QueryRangeResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // MetricsServiceServer is the server API for MetricsService service.
 type MetricsServiceServer interface {
 	Watch(*WatchRequest, MetricsService_WatchServer) error
 	GetMetrics(context.Context, *GetMetricsRequest) (*GetMetricsResponse, error)
+	QueryRange(*QueryRangeRequest, MetricsService_QueryRangeServer) error
 }
 
 // UnimplementedMetricsServiceServer can be embedded to have forward compatible implementations.
@@ -381,6 +888,9 @@ func (*UnimplementedMetricsServiceServer) Watch(req *WatchRequest, srv MetricsSe
 func (*UnimplementedMetricsServiceServer) GetMetrics(ctx context.Context, req *GetMetricsRequest) (*GetMetricsResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetMetrics not implemented")
 }
+func (*UnimplementedMetricsServiceServer) QueryRange(req *QueryRangeRequest, srv MetricsService_QueryRangeServer) error {
+	return status.Errorf(codes.Unimplemented, "method QueryRange not implemented")
+}
 
 func RegisterMetricsServiceServer(s *grpc.Server, srv MetricsServiceServer) {
 	s.RegisterService(&_MetricsService_serviceDesc, srv)
@@ -425,6 +935,27 @@ func _MetricsService_GetMetrics_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MetricsService_QueryRange_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(QueryRangeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MetricsServiceServer).QueryRange(m, &metricsServiceQueryRangeServer{stream})
+}
+
+type MetricsService_QueryRangeServer interface {
+	Send(*QueryRangeResponse) error
+	grpc.ServerStream
+}
+
+type metricsServiceQueryRangeServer struct {
+	grpc.ServerStream
+}
+
+func (x *metricsServiceQueryRangeServer) Send(m *QueryRangeResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _MetricsService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "pb.v1.MetricsService",
 	HandlerType: (*MetricsServiceServer)(nil),
@@ -440,6 +971,11 @@ var _MetricsService_serviceDesc = grpc.ServiceDesc{
 			Handler:       _MetricsService_Watch_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "QueryRange",
+			Handler:       _MetricsService_QueryRange_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "pb/v1/protocol.proto",
 }
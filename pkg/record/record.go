@@ -0,0 +1,140 @@
+package record
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+
+	pb "github.com/yasker/kstat/pkg/pb/v1"
+)
+
+const (
+	// FrameFormat is the format of the frames after the header
+	FrameFormat = 1
+)
+
+// Writer writes a session recording as length-delimited protobuf frames.
+// The first frame is the RecordHeader, and the rest are the RecordFrames of
+// the snapshots, or of the new headers when the configuration changes.
+type Writer struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+// Reader reads a session recording written by Writer
+type Reader struct {
+	f *os.File
+	r *bufio.Reader
+
+	Header *pb.RecordHeader
+}
+
+func NewWriter(file string, header *pb.RecordHeader) (*Writer, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot create the record file %v", file)
+	}
+	w := &Writer{
+		f: f,
+		w: bufio.NewWriter(f),
+	}
+	header.Format = FrameFormat
+	if err := w.writeFrame(header); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "cannot write the record header to %v", file)
+	}
+	return w, nil
+}
+
+func (w *Writer) writeFrame(m proto.Message) error {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(len(data)))
+	if _, err := w.w.Write(buf[:n]); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(data); err != nil {
+		return err
+	}
+	// flush every frame so the recording is usable even if kstat is killed
+	return w.w.Flush()
+}
+
+func (w *Writer) Write(resp *pb.GetMetricsResponse) error {
+	return w.writeFrame(&pb.RecordFrame{Metrics: resp})
+}
+
+// WriteHeader writes the configuration of the snapshots after it
+func (w *Writer) WriteHeader(header *pb.RecordHeader) error {
+	header.Format = FrameFormat
+	return w.writeFrame(&pb.RecordFrame{Header: header})
+}
+
+func (w *Writer) Close() error {
+	if err := w.w.Flush(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+func NewReader(file string) (*Reader, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open the record file %v", file)
+	}
+	r := &Reader{
+		f:      f,
+		r:      bufio.NewReader(f),
+		Header: &pb.RecordHeader{},
+	}
+	if err := r.readFrame(r.Header); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "cannot read the record header from %v", file)
+	}
+	if r.Header.Format != FrameFormat {
+		f.Close()
+		return nil, fmt.Errorf("unsupported format %v of the record file %v, must be %v", r.Header.Format, file, FrameFormat)
+	}
+	return r, nil
+}
+
+func (r *Reader) readFrame(m proto.Message) error {
+	size, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return err
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// Read returns the next frame in the recording, or io.EOF at the end
+func (r *Reader) Read() (*pb.RecordFrame, error) {
+	frame := &pb.RecordFrame{}
+	if err := r.readFrame(frame); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			// the recording was interrupted in the middle of a frame
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	if frame.Metrics == nil && frame.Header == nil {
+		return nil, fmt.Errorf("invalid empty frame in the recording")
+	}
+	return frame, nil
+}
+
+func (r *Reader) Close() error {
+	return r.f.Close()
+}
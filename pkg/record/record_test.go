@@ -0,0 +1,169 @@
+package record
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/yasker/kstat/pkg/pb/v1"
+)
+
+func snapshot(ts int64) *pb.GetMetricsResponse {
+	return &pb.GetMetricsResponse{
+		Timestamp: ts,
+		ClusterMetrics: map[string]*pb.ClusterMetric{
+			"cpu_user": {
+				InstanceMetrics: map[string]*pb.InstanceMetric{
+					"node-1": {Value: ts * 10, Total: ts * 10},
+				},
+			},
+		},
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	tests := []struct {
+		name   string
+		frames []*pb.RecordFrame
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "snapshots",
+			frames: []*pb.RecordFrame{
+				{Metrics: snapshot(1)},
+				{Metrics: snapshot(2)},
+			},
+		},
+		{
+			name: "header after reload",
+			frames: []*pb.RecordFrame{
+				{Metrics: snapshot(1)},
+				{Header: &pb.RecordHeader{Version: "v1", MetricsFormat: "reloaded"}},
+				{Metrics: snapshot(2)},
+			},
+		},
+		{
+			name: "empty snapshot",
+			frames: []*pb.RecordFrame{
+				{Metrics: &pb.GetMetricsResponse{}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "session.kstat")
+			header := &pb.RecordHeader{Version: "v1", MetricsFormat: "format", HeaderTemplate: "header", OutputTemplate: "output"}
+			w, err := NewWriter(file, header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, f := range tt.frames {
+				if f.Header != nil {
+					err = w.WriteHeader(f.Header)
+				} else {
+					err = w.Write(f.Metrics)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := NewReader(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if !proto.Equal(r.Header, header) {
+				t.Errorf("header %v, want %v", r.Header, header)
+			}
+			for i, want := range tt.frames {
+				got, err := r.Read()
+				if err != nil {
+					t.Fatalf("frame %v: %v", i, err)
+				}
+				if !proto.Equal(got, want) {
+					t.Errorf("frame %v is %v, want %v", i, got, want)
+				}
+			}
+			if _, err := r.Read(); err != io.EOF {
+				t.Errorf("got %v after the last frame, want EOF", err)
+			}
+		})
+	}
+}
+
+// writeRaw writes the messages as the frames
+func writeRaw(t *testing.T, file string, messages ...proto.Message) {
+	f, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, m := range messages {
+		data, err := proto.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, binary.MaxVarintLen64)
+		n := binary.PutUvarint(buf, uint64(len(data)))
+		if _, err := f.Write(append(buf[:n], data...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadUnknownFormat(t *testing.T) {
+	for _, format := range []int32{0, FrameFormat + 1} {
+		file := filepath.Join(t.TempDir(), "session.kstat")
+		writeRaw(t, file, &pb.RecordHeader{Version: "v1", Format: format}, &pb.RecordFrame{Metrics: snapshot(1)})
+		if r, err := NewReader(file); err == nil {
+			r.Close()
+			t.Errorf("read the recording of the format %v, want an error", format)
+		}
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "session.kstat")
+	w, err := NewWriter(file, &pb.RecordHeader{Version: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ts := range []int64{1, 2} {
+		if err := w.Write(snapshot(ts)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cut the last frame in the middle as if kstat was killed
+	if err := os.Truncate(file, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Read(); err != nil {
+		t.Fatalf("the first frame: %v", err)
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("got %v for the truncated frame, want EOF", err)
+	}
+}
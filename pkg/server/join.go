@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+// JoinConfig is the ratio of two metrics of the profile, matched by the
+// instances and the devices, e.g. the CPU usage against the requests
+type JoinConfig struct {
+	Numerator   string `yaml:"numerator"`
+	Denominator string `yaml:"denominator"`
+}
+
+// validateJoins checks the metrics joined exist and are queried
+func validateJoins(configs map[string]*MetricConfig) error {
+	for _, c := range configs {
+		if c.Join == nil {
+			continue
+		}
+		if c.QueryString != "" || len(c.Windows) != 0 {
+			return fmt.Errorf("invalid config for metric %v: join cannot be used with query_string or windows", c.Name)
+		}
+		for _, name := range []string{c.Join.Numerator, c.Join.Denominator} {
+			joined, exists := configs[name]
+			if !exists {
+				return fmt.Errorf("invalid config for metric %v: cannot find the joined metric %q", c.Name, name)
+			}
+			if joined.Join != nil {
+				return fmt.Errorf("invalid config for metric %v: cannot join the joined metric %v", c.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// addJoins adds the joined metrics of the profile to the queried metrics
+func addJoins(configs map[string]*MetricConfig, metrics map[string]*types.ClusterMetric) {
+	valueTypes := map[string]string{}
+	for name, c := range configs {
+		valueTypes[name] = c.ValueType
+	}
+	for _, c := range configs {
+		if c.Join != nil {
+			metrics[c.Name] = joinMetric(c, metrics, valueTypes)
+		}
+	}
+}
+
+// joinMetric divides the numerator by the denominator for the instances and
+// the devices in both of them. The value of the instance is the ratio of the
+// summarized values, not the average of the devices. If both have devices, the
+// numerator is only summarized from the devices in the denominator, e.g. the
+// usage of the pods with the requests in the namespace against all the
+// requests, without the BestEffort pods.
+func joinMetric(cfg *MetricConfig, metrics map[string]*types.ClusterMetric, valueTypes map[string]string) *types.ClusterMetric {
+	report := &types.ClusterMetric{
+		InstanceMetrics: map[string]*types.InstanceMetric{},
+	}
+	numerator, denominator := metrics[cfg.Join.Numerator], metrics[cfg.Join.Denominator]
+	if numerator == nil || denominator == nil {
+		return report
+	}
+	ratio := func(n, d int64) int64 {
+		return int64(float64(n) / float64(d) * cfg.Scale)
+	}
+	for inst, nm := range numerator.InstanceMetrics {
+		dm := denominator.InstanceMetrics[inst]
+		if dm == nil {
+			continue
+		}
+		d := dm.Summary(valueTypes[cfg.Join.Denominator])
+		if d == 0 {
+			continue
+		}
+		n := nm.Summary(valueTypes[cfg.Join.Numerator])
+		if len(nm.DeviceMetrics) != 0 && len(dm.DeviceMetrics) != 0 {
+			n = matchedSummary(nm, dm, valueTypes[cfg.Join.Numerator])
+		}
+		value := ratio(n, d)
+		m := &types.InstanceMetric{
+			Value:   value,
+			Total:   value,
+			Average: value,
+			Labels:  nm.Labels,
+		}
+		for dev, v := range nm.DeviceMetrics {
+			if dv := dm.DeviceMetrics[dev]; dv != 0 {
+				if m.DeviceMetrics == nil {
+					m.DeviceMetrics = map[string]int64{}
+					m.DeviceLabels = map[string]map[string]string{}
+				}
+				m.DeviceMetrics[dev] = ratio(v, dv)
+				m.DeviceLabels[dev] = nm.DeviceLabels[dev]
+			}
+		}
+		report.InstanceMetrics[inst] = m
+	}
+	return report
+}
+
+// matchedSummary summarizes the devices of the numerator which are also in
+// the denominator, like InstanceMetric.Summary
+func matchedSummary(nm, dm *types.InstanceMetric, valueType string) int64 {
+	total, count := int64(0), int64(0)
+	for dev, v := range nm.DeviceMetrics {
+		if _, exists := dm.DeviceMetrics[dev]; exists {
+			total += v
+			count++
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	m := &types.InstanceMetric{Total: total, Average: total / count}
+	return m.Summary(valueType)
+}
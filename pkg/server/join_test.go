@@ -0,0 +1,89 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+func TestJoinMetric(t *testing.T) {
+	cfg := &MetricConfig{
+		Name:  "ns_cpu_requested",
+		Scale: 100,
+		Join:  &JoinConfig{Numerator: "ns_cpu", Denominator: "ns_cpu_requests"},
+	}
+	valueTypes := map[string]string{
+		"ns_cpu":          types.ValueTypeCount,
+		"ns_cpu_requests": types.ValueTypeCount,
+	}
+	device := func(values map[string]int64) *types.InstanceMetric {
+		m := &types.InstanceMetric{DeviceMetrics: values}
+		for _, v := range values {
+			m.Total += v
+		}
+		m.Average = m.Total / int64(len(values))
+		return m
+	}
+	tests := []struct {
+		name        string
+		numerator   *types.InstanceMetric
+		denominator *types.InstanceMetric
+		want        int64
+		wantDevices map[string]int64
+	}{
+		{
+			name:        "without devices",
+			numerator:   &types.InstanceMetric{Value: 100, Total: 100},
+			denominator: &types.InstanceMetric{Value: 400, Total: 400},
+			want:        25,
+		},
+		{
+			name:        "all devices requested",
+			numerator:   device(map[string]int64{"pod: a": 100, "pod: b": 300}),
+			denominator: device(map[string]int64{"pod: a": 200, "pod: b": 600}),
+			want:        50,
+			wantDevices: map[string]int64{"pod: a": 50, "pod: b": 50},
+		},
+		{
+			name:        "best effort device",
+			numerator:   device(map[string]int64{"pod: a": 100, "pod: b": 300}),
+			denominator: device(map[string]int64{"pod: a": 200}),
+			want:        50,
+			wantDevices: map[string]int64{"pod: a": 50},
+		},
+		{
+			name:        "requested device without usage",
+			numerator:   device(map[string]int64{"pod: a": 100}),
+			denominator: device(map[string]int64{"pod: a": 200, "pod: b": 200}),
+			want:        25,
+			wantDevices: map[string]int64{"pod: a": 50},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := map[string]*types.ClusterMetric{
+				"ns_cpu": {
+					InstanceMetrics: map[string]*types.InstanceMetric{"default": tt.numerator},
+				},
+				"ns_cpu_requests": {
+					InstanceMetrics: map[string]*types.InstanceMetric{"default": tt.denominator},
+				},
+			}
+			got := joinMetric(cfg, metrics, valueTypes).InstanceMetrics["default"]
+			if got == nil {
+				t.Fatalf("the instance is missing")
+			}
+			if got.Value != tt.want {
+				t.Errorf("got %v, want %v", got.Value, tt.want)
+			}
+			if len(got.DeviceMetrics) != len(tt.wantDevices) {
+				t.Fatalf("got the devices %v, want %v", got.DeviceMetrics, tt.wantDevices)
+			}
+			for dev, want := range tt.wantDevices {
+				if got.DeviceMetrics[dev] != want {
+					t.Errorf("got %v of %v, want %v", got.DeviceMetrics[dev], dev, want)
+				}
+			}
+		})
+	}
+}
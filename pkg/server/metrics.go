@@ -3,18 +3,23 @@ package server
 import (
 	"context"
 	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
 
 	"github.com/yasker/kstat/pkg/types"
 )
 
-func (s *Server) query(ctx context.Context, queryString string) (model.Vector, error) {
-	result, warnings, err := s.promClient.Query(ctx, queryString, time.Now())
+func (s *Server) query(ctx context.Context, queryString string, ts time.Time) (model.Vector, error) {
+	result, warnings, err := s.promClient.Query(ctx, queryString, ts)
 	if err != nil {
 		return nil, fmt.Errorf("Error querying Prometheus: %v", err)
 	}
@@ -33,29 +38,111 @@ func (s *Server) query(ctx context.Context, queryString string) (model.Vector, e
 	return vector, nil
 }
 
-func (s *Server) getClusterMetric(ctx context.Context, cfg *MetricConfig) (*types.ClusterMetric, error) {
-	vector, err := s.query(ctx, cfg.QueryString)
+func (s *Server) queryRange(ctx context.Context, queryString string, r promv1.Range) (model.Matrix, error) {
+	result, warnings, err := s.promClient.QueryRange(ctx, queryString, r)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying Prometheus: %v", err)
+	}
+
+	if len(warnings) > 0 {
+		logrus.Warnf("Warnings: %v", warnings)
+	}
+
+	if result.Type() != model.ValMatrix {
+		return nil, fmt.Errorf("Didn't get expected matrix output, get %v instead", result.Type())
+	}
+	matrix, ok := result.(model.Matrix)
+	if !ok {
+		return nil, fmt.Errorf("BUG: output indicated as matrix but failed to convert: %+v", result)
+	}
+	return matrix, nil
+}
+
+func (s *Server) getClusterMetric(ctx context.Context, cfg *MetricConfig, ts time.Time) (*types.ClusterMetric, error) {
+	vector, err := s.query(ctx, cfg.QueryString, ts)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to get metric for %v", cfg.Name)
 	}
+	return vectorToClusterMetric(cfg, vector), nil
+}
+
+// getClusterMetricRange returns the metric at each step of the range, with
+// the evaluation time in Unix nanoseconds as the key
+func (s *Server) getClusterMetricRange(ctx context.Context, cfg *MetricConfig, r promv1.Range) (map[int64]*types.ClusterMetric, error) {
+	matrix, err := s.queryRange(ctx, cfg.QueryString, r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get metric range for %v", cfg.Name)
+	}
+
+	vectors := map[int64]model.Vector{}
+	for _, ss := range matrix {
+		for _, p := range ss.Values {
+			ts := p.Timestamp.Time().UnixNano()
+			vectors[ts] = append(vectors[ts], &model.Sample{
+				Metric:    ss.Metric,
+				Value:     p.Value,
+				Timestamp: p.Timestamp,
+			})
+		}
+	}
 
+	result := map[int64]*types.ClusterMetric{}
+	for ts, vector := range vectors {
+		result[ts] = vectorToClusterMetric(cfg, vector)
+	}
+	return result, nil
+}
+
+func vectorToClusterMetric(cfg *MetricConfig, vector model.Vector) *types.ClusterMetric {
 	report := types.ClusterMetric{
 		InstanceMetrics: map[string]*types.InstanceMetric{},
 	}
+	instanceLabel := types.InstanceLabel
+	if cfg.InstanceLabel != "" {
+		instanceLabel = model.LabelName(cfg.InstanceLabel)
+	}
 	for _, smp := range vector {
-		inst := string(smp.Metric[types.InstanceLabel])
+		// e.g. the division by zero, which cannot be converted
+		if math.IsNaN(float64(smp.Value)) || math.IsInf(float64(smp.Value), 0) {
+			continue
+		}
+		inst := string(smp.Metric[instanceLabel])
 		dev := ""
 		if report.InstanceMetrics[inst] == nil {
 			report.InstanceMetrics[inst] = &types.InstanceMetric{}
 		}
+		m := report.InstanceMetrics[inst]
+		labels := map[string]string{}
+		for k, v := range smp.Metric {
+			if k != model.MetricNameLabel {
+				labels[string(k)] = string(v)
+			}
+		}
+		value := int64(float64(smp.Value) * cfg.Scale)
 		if cfg.DeviceLabel != "" {
 			dev = cfg.DevicePrefix + ": " + string(smp.Metric[model.LabelName(cfg.DeviceLabel)])
-			if report.InstanceMetrics[inst].DeviceMetrics == nil {
-				report.InstanceMetrics[inst].DeviceMetrics = map[string]int64{}
+			if m.DeviceMetrics == nil {
+				m.DeviceMetrics = map[string]int64{}
+				m.DeviceLabels = map[string]map[string]string{}
+			}
+			if existing, exists := m.DeviceLabels[dev]; exists {
+				m.DeviceMetrics[dev] += value
+				m.DeviceLabels[dev] = commonLabels(existing, labels)
+			} else {
+				m.DeviceMetrics[dev] = value
+				m.DeviceLabels[dev] = labels
 			}
-			report.InstanceMetrics[inst].DeviceMetrics[dev] = int64(float64(smp.Value) * cfg.Scale)
+		} else if m.Labels != nil {
+			m.Value += value
+			m.Labels = commonLabels(m.Labels, labels)
 		} else {
-			report.InstanceMetrics[inst].Value = int64(float64(smp.Value) * cfg.Scale)
+			m.Value = value
+			m.Labels = labels
+		}
+	}
+	for _, m := range report.InstanceMetrics {
+		if m.DeviceLabels != nil {
+			m.Labels = splitCommonLabels(m.DeviceLabels)
 		}
 	}
 	for _, m := range report.InstanceMetrics {
@@ -69,29 +156,181 @@ func (s *Server) getClusterMetric(ctx context.Context, cfg *MetricConfig) (*type
 			m.Total = m.Value
 		}
 	}
-	return &report, nil
+	return &report
+}
+
+// labelNames returns the names of the labels of the series in the metrics
+func labelNames(metrics map[string]*types.ClusterMetric) []string {
+	known := map[string]bool{}
+	for _, cm := range metrics {
+		for _, im := range cm.InstanceMetrics {
+			for k := range im.Labels {
+				known[k] = true
+			}
+			for _, labels := range im.DeviceLabels {
+				for k := range labels {
+					known[k] = true
+				}
+			}
+		}
+	}
+	names := []string{}
+	for k := range known {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commonLabels returns the labels with the same values in both, e.g. of the
+// series summed into one instance or device
+func commonLabels(a, b map[string]string) map[string]string {
+	common := map[string]string{}
+	for k, v := range a {
+		if bv, exists := b[k]; exists && bv == v {
+			common[k] = v
+		}
+	}
+	return common
+}
+
+// splitCommonLabels moves the labels shared by all the devices out of the
+// device labels, and returns them
+func splitCommonLabels(deviceLabels map[string]map[string]string) map[string]string {
+	var common map[string]string
+	for _, labels := range deviceLabels {
+		if common == nil {
+			common = map[string]string{}
+			for k, v := range labels {
+				common[k] = v
+			}
+			continue
+		}
+		for k, v := range common {
+			if labels[k] != v {
+				delete(common, k)
+			}
+		}
+	}
+	for _, labels := range deviceLabels {
+		for k := range common {
+			delete(labels, k)
+		}
+	}
+	return common
 }
 
 func (s *Server) testConnection() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	_, err := s.query(ctx, "up")
+	_, err := s.query(ctx, "up", time.Now())
 	return err
 }
 
-func (s *Server) getMetrics() (map[string]*types.ClusterMetric, error) {
-	metrics := map[string]*types.ClusterMetric{}
+// getMetrics evaluates all the queries at the same time, so the metrics in
+// the snapshot are consistent with each other. Only the active profiles are
+// evaluated, and they are retrieved in parallel. The ones failed have no
+// metrics in the result, and the idle ones are left out.
+func (s *Server) getMetrics(ts time.Time) (map[string]map[string]*types.ClusterMetric, error) {
+	metrics := map[string]map[string]*types.ClusterMetric{}
+	errs := []string{}
+
+	active := map[string]bool{}
+	now := time.Now()
+	s.profileMutex.Lock()
+	for profile := range s.metricConfigMap {
+		active[profile] = s.isProfileActive(profile, now)
+	}
+	s.profileMutex.Unlock()
+
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for profile, configs := range s.metricConfigMap {
+		if !active[profile] {
+			continue
+		}
+		wg.Add(1)
+		go func(profile string, configs map[string]*MetricConfig) {
+			defer wg.Done()
+			profileMetrics, err := s.getProfileMetrics(configs, ts)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("profile %v: %v", profile, err))
+				metrics[profile] = map[string]*types.ClusterMetric{}
+				return
+			}
+			metrics[profile] = profileMetrics
+		}(profile, configs)
+	}
+	wg.Wait()
 
-	for _, c := range s.metricConfigMap {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+	if len(errs) != 0 {
+		sort.Strings(errs)
+		return metrics, errors.New(strings.Join(errs, "; "))
+	}
+	return metrics, nil
+}
 
-		cm, err := s.getClusterMetric(ctx, c)
+func (s *Server) getProfileMetrics(configs map[string]*MetricConfig, ts time.Time) (map[string]*types.ClusterMetric, error) {
+	metrics := map[string]*types.ClusterMetric{}
+	for _, c := range configs {
+		if c.Join != nil {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), types.GRPCServiceTimeout)
+		cm, err := s.getClusterMetric(ctx, c, ts)
+		cancel()
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to get metric for %v", c.Name)
+			return nil, err
 		}
 		metrics[c.Name] = cm
 	}
+	addJoins(configs, metrics)
 	return metrics, nil
 }
+
+// getMetricsRange returns the snapshots of the range of the profile, with
+// the evaluation time in Unix nanoseconds as the key
+func (s *Server) getMetricsRange(ctx context.Context, profile string, r promv1.Range) (map[int64]map[string]*types.ClusterMetric, error) {
+	s.rwMutex.RLock()
+	configs := map[string]*MetricConfig{}
+	for name, c := range s.metricConfigMap[profile] {
+		configs[name] = c
+	}
+	s.rwMutex.RUnlock()
+
+	frames := map[int64]map[string]*types.ClusterMetric{}
+	for _, c := range configs {
+		if c.Join != nil {
+			continue
+		}
+		queryCtx, cancel := context.WithTimeout(ctx, types.GRPCServiceTimeout)
+		metrics, err := s.getClusterMetricRange(queryCtx, c, r)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		for ts, cm := range metrics {
+			if frames[ts] == nil {
+				frames[ts] = map[string]*types.ClusterMetric{}
+			}
+			frames[ts][c.Name] = cm
+		}
+	}
+	for _, metrics := range frames {
+		// the metrics without any series at the time are empty like in
+		// the live snapshots, instead of missing in the templates
+		for _, c := range configs {
+			if c.Join == nil && metrics[c.Name] == nil {
+				metrics[c.Name] = &types.ClusterMetric{
+					InstanceMetrics: map[string]*types.InstanceMetric{},
+				}
+			}
+		}
+		addJoins(configs, metrics)
+	}
+	return frames, nil
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	promapi "github.com/prometheus/client_golang/api"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"golang.org/x/net/context"
+
+	"github.com/yasker/kstat/pkg/filter"
+	"github.com/yasker/kstat/pkg/types"
+)
+
+// newTestServer returns the server of the profile querying the fake
+// Prometheus, which returns the result of the query in the results, the
+// matrix for the range queries and the vector for the others
+func newTestServer(t *testing.T, configs []*MetricConfig, results map[string]string) *Server {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, exists := results[r.FormValue("query")]
+		if !exists {
+			result = "[]"
+		}
+		resultType := "vector"
+		if strings.HasSuffix(r.URL.Path, "/query_range") {
+			resultType = "matrix"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"success","data":{"resultType":"%v","result":%v}}`, resultType, result)
+	}))
+	t.Cleanup(prom.Close)
+
+	client, err := promapi.NewClient(promapi.Config{Address: prom.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("", prom.URL, "")
+	s.promClient = promv1.NewAPI(client)
+	s.metricConfigMap = map[string]map[string]*MetricConfig{types.DefaultProfile: {}}
+	for _, c := range configs {
+		s.metricConfigMap[types.DefaultProfile][c.Name] = c
+	}
+	return s
+}
+
+func TestGetMetricsRangeEmptyMetrics(t *testing.T) {
+	configs := []*MetricConfig{
+		{Name: "pod_cpu", ValueType: types.ValueTypeCount, QueryString: "cpu", Scale: 1},
+		{Name: "pod_cpu_requests", ValueType: types.ValueTypeCount, QueryString: "requests", Scale: 1},
+		{Name: "pod_cpu_requested", ValueType: types.ValueTypePercent, Scale: 100,
+			Join: &JoinConfig{Numerator: "pod_cpu", Denominator: "pod_cpu_requests"}},
+	}
+	s := newTestServer(t, configs, map[string]string{
+		"cpu":      `[{"metric":{"instance":"node-1"},"values":[[100,"1"],[110,"2"]]}]`,
+		"requests": `[{"metric":{"instance":"node-1"},"values":[[100,"4"]]}]`,
+	})
+	if err := validateJoins(s.metricConfigMap[types.DefaultProfile]); err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := s.getMetricsRange(context.Background(), types.DefaultProfile, promv1.Range{
+		Start: time.Unix(100, 0),
+		End:   time.Unix(110, 0),
+		Step:  10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 {
+		t.Fatalf("got %v frames, want 2", len(frames))
+	}
+	for ts, metrics := range frames {
+		for _, c := range configs {
+			if metrics[c.Name] == nil || metrics[c.Name].InstanceMetrics == nil {
+				t.Fatalf("metric %v is missing in the frame at %v", c.Name, ts)
+			}
+		}
+	}
+	full := frames[time.Unix(100, 0).UnixNano()]
+	if got := full["pod_cpu_requested"].InstanceMetrics["node-1"]; got == nil || got.Value != 25 {
+		t.Errorf("got the joined metric %v, want 25", got)
+	}
+	partial := frames[time.Unix(110, 0).UnixNano()]
+	if n := len(partial["pod_cpu_requests"].InstanceMetrics) + len(partial["pod_cpu_requested"].InstanceMetrics); n != 0 {
+		t.Errorf("got the instances of the metrics without data")
+	}
+}
+
+func TestGetMetricsActiveProfiles(t *testing.T) {
+	s := newTestServer(t, []*MetricConfig{
+		{Name: "cpu_user", ValueType: types.ValueTypeCPU, QueryString: "cpu", Scale: 1},
+	}, map[string]string{
+		"cpu":     `[{"metric":{"instance":"node-1"},"value":[100,"1"]}]`,
+		"pod_cpu": `[{"metric":{"instance":"node-1"},"value":[100,"2"]}]`,
+	})
+	s.metricConfigMap[types.PodProfile] = map[string]*MetricConfig{
+		"pod_cpu": {Name: "pod_cpu", ValueType: types.ValueTypeCPU, QueryString: "pod_cpu", Scale: 1},
+	}
+	poll := func() {
+		metrics, err := s.getMetrics(time.Unix(100, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.refreshMetrics(metrics, time.Unix(100, 0))
+	}
+
+	poll()
+	if s.metrics[types.DefaultProfile]["cpu_user"] == nil {
+		t.Fatalf("the default profile isn't evaluated")
+	}
+	if _, evaluated := s.metrics[types.PodProfile]; evaluated {
+		t.Fatalf("the idle profile is evaluated")
+	}
+
+	resp, updated, err := s.getFilteredMetrics(types.PodProfile, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil {
+		t.Fatalf("got the metrics %v of the idle profile, want nil", resp)
+	}
+	select {
+	case <-s.profileRequested:
+	default:
+		t.Fatalf("the poll loop isn't woken up for the requested profile")
+	}
+	poll()
+	select {
+	case <-updated:
+	default:
+		t.Fatalf("the channel isn't closed after the metrics are updated")
+	}
+	resp, _, err = s.getFilteredMetrics(types.PodProfile, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.ClusterMetrics["pod_cpu"].InstanceMetrics["node-1"].Value != 2 {
+		t.Fatalf("got the metrics %v of the requested profile, want pod_cpu of node-1", resp)
+	}
+
+	s.profileRequestedAt[types.PodProfile] = time.Now().Add(-types.ProfileIdleTimeout)
+	poll()
+	if _, evaluated := s.metrics[types.PodProfile]; evaluated {
+		t.Errorf("the profile is still evaluated after it's idle")
+	}
+}
+
+func TestGetFilteredMetricsUnknownLabel(t *testing.T) {
+	s := newTestServer(t, []*MetricConfig{
+		{Name: "pod_cpu", ValueType: types.ValueTypeCPU, DeviceLabel: "namespace_pod", DevicePrefix: "pod", QueryString: "pod_cpu", Scale: 1},
+	}, map[string]string{
+		"pod_cpu": `[{"metric":{"instance":"node-1","namespace":"default","pod":"nginx","namespace_pod":"default/nginx"},"value":[100,"1"]}]`,
+	})
+	metrics, err := s.getMetrics(time.Unix(100, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.refreshMetrics(metrics, time.Unix(100, 0))
+
+	tests := []struct {
+		selector string
+		wantErr  bool
+	}{
+		{"namespace=default", false},
+		{"instance=node-1,pod=~nginx.*", false},
+		{"app=nginx", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			f, err := filter.NewFilter("", "", "", tt.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, _, err := s.getFilteredMetrics(types.DefaultProfile, f, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got the error %v, want the error %v", err, tt.wantErr)
+			}
+			if err == nil && len(resp.ClusterMetrics["pod_cpu"].InstanceMetrics) != 1 {
+				t.Errorf("got the metrics %v, want node-1", resp.ClusterMetrics)
+			}
+		})
+	}
+}
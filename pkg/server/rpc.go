@@ -1,19 +1,30 @@
 package server
 
 import (
-	"github.com/sirupsen/logrus"
+	"sort"
+	"time"
+
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+
+	"github.com/yasker/kstat/pkg/filter"
 	pb "github.com/yasker/kstat/pkg/pb/v1"
 	"github.com/yasker/kstat/pkg/types"
 )
 
 func NewGRPCServer(s *Server) *grpc.Server {
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             types.GRPCKeepaliveMinTime,
+			PermitWithoutStream: true,
+		}),
+	)
 
 	pb.RegisterMetricsServiceServer(grpcServer, s)
 
@@ -23,18 +34,154 @@ func NewGRPCServer(s *Server) *grpc.Server {
 }
 
 func (s *Server) Watch(req *pb.WatchRequest, srv pb.MetricsService_WatchServer) error {
-	logrus.Errorf("DEBUGGG: Get Watch")
-	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+	f, err := filter.FromPB(req.Filter)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	for {
+		resp, updated, err := s.getFilteredMetrics(req.Profile, f, req.WithLabels)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		// nothing to send until the idle profile is evaluated
+		if resp != nil {
+			if err := srv.Send(&pb.WatchResponse{Metrics: resp}); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-updated:
+		case <-srv.Context().Done():
+			return nil
+		}
+	}
 }
 
 func (s *Server) GetMetrics(ctx context.Context, req *pb.GetMetricsRequest) (*pb.GetMetricsResponse, error) {
+	f, err := filter.FromPB(req.Filter)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	for {
+		resp, updated, err := s.getFilteredMetrics(req.Profile, f, req.WithLabels)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		if resp != nil {
+			return resp, nil
+		}
+		// the profile was idle, wait for it to be evaluated
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+func (s *Server) QueryRange(req *pb.QueryRangeRequest, srv pb.MetricsService_QueryRangeServer) error {
+	f, err := filter.FromPB(req.Filter)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	step := time.Duration(req.Step)
+	if step <= 0 {
+		return status.Errorf(codes.InvalidArgument, "invalid step %v", step)
+	}
+	if req.End < req.Start {
+		return status.Errorf(codes.InvalidArgument, "the end of the range is before the start")
+	}
+	if points := (req.End - req.Start) / req.Step; points > types.MaxQueryRangePoints {
+		return status.Errorf(codes.InvalidArgument, "too many points %v in the range, the maximum is %v", points, types.MaxQueryRangePoints)
+	}
+	s.rwMutex.RLock()
+	profile, err := s.profile(req.Profile)
+	if err == nil {
+		err = f.Validate(s.metricNames(profile))
+	}
+	s.rwMutex.RUnlock()
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	// align the evaluation times to the step, like the poll interval for
+	// the live metrics
+	r := promv1.Range{
+		Start: time.Unix(0, req.Start).Truncate(step),
+		End:   time.Unix(0, req.End),
+		Step:  step,
+	}
+	frames, err := s.getMetricsRange(srv.Context(), profile, r)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "%v", err)
+	}
+
+	timestamps := []int64{}
+	for ts := range frames {
+		timestamps = append(timestamps, ts)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	labels := map[string]bool{}
+	for _, metrics := range frames {
+		for _, name := range labelNames(metrics) {
+			labels[name] = true
+		}
+	}
+	names := []string{}
+	for name := range labels {
+		names = append(names, name)
+	}
+	if err := f.ValidateLabels(names); err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	s.rwMutex.RLock()
+	valueTypes := s.valueTypes(profile)
+	s.rwMutex.RUnlock()
+
+	for _, ts := range timestamps {
+		resp := MetricsToPB(f.Apply(frames[ts], valueTypes), req.WithLabels)
+		resp.Timestamp = ts
+		if err := srv.Send(&pb.QueryRangeResponse{Metrics: resp}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getFilteredMetrics returns the current metrics of the profile, with the
+// channel which will be closed when the metrics are updated. The metrics are
+// nil if the profile was idle and hasn't been evaluated yet.
+func (s *Server) getFilteredMetrics(profile string, f *filter.Filter, withLabels bool) (*pb.GetMetricsResponse, <-chan struct{}, error) {
 	s.rwMutex.RLock()
 	defer s.rwMutex.RUnlock()
 
-	return MetricsToPB(s.metrics), nil
+	profile, err := s.profile(profile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := f.Validate(s.metricNames(profile)); err != nil {
+		return nil, nil, err
+	}
+	s.requestProfile(profile)
+	if _, evaluated := s.metrics[profile]; !evaluated {
+		return nil, s.metricsUpdated, nil
+	}
+	if err := f.ValidateLabels(labelNames(s.metrics[profile])); err != nil {
+		return nil, nil, err
+	}
+	resp := MetricsToPB(f.Apply(s.metrics[profile], s.valueTypes(profile)), withLabels)
+	resp.Timestamp = s.metricsUpdateAt.UnixNano()
+	return resp, s.metricsUpdated, nil
 }
 
-func MetricsToPB(metrics map[string]*types.ClusterMetric) *pb.GetMetricsResponse {
+// MetricsToPB converts the metrics for the response, the labels are only
+// included if asked since they are much larger than the values
+func MetricsToPB(metrics map[string]*types.ClusterMetric, withLabels bool) *pb.GetMetricsResponse {
 	resp := &pb.GetMetricsResponse{}
 	resp.ClusterMetrics = map[string]*pb.ClusterMetric{}
 	for k, v := range metrics {
@@ -51,6 +198,15 @@ func MetricsToPB(metrics map[string]*types.ClusterMetric) *pb.GetMetricsResponse
 			for kd, kv := range vi.DeviceMetrics {
 				im.DeviceMetrics[kd] = kv
 			}
+			if withLabels {
+				im.Labels = vi.Labels
+				if vi.DeviceLabels != nil {
+					im.DeviceLabels = map[string]*pb.Labels{}
+					for kd, labels := range vi.DeviceLabels {
+						im.DeviceLabels[kd] = &pb.Labels{Labels: labels}
+					}
+				}
+			}
 			cm.InstanceMetrics[ki] = im
 		}
 		resp.ClusterMetrics[k] = cm
@@ -1,28 +1,40 @@
 package server
 
 import (
+	"fmt"
 	"net"
-	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
-	"gopkg.in/yaml.v2"
 
 	promapi "github.com/prometheus/client_golang/api"
 	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 
+	"github.com/yasker/kstat/pkg/config"
 	"github.com/yasker/kstat/pkg/types"
 )
 
 type MetricConfig struct {
 	Name         string  `yaml:"name"`
+	ValueType    string  `yaml:"value_type"`
 	DeviceLabel  string  `yaml:"device_label"`
 	DevicePrefix string  `yaml:"device_prefix"`
 	QueryString  string  `yaml:"query_string"`
 	Scale        float64 `yaml:"scale"`
+	// Windows evaluates the query in each of the windows, the first window
+	// is stored as the metric and the others as <name>_<window>
+	Windows []string `yaml:"windows"`
+	// InstanceLabel groups the series by the label instead of the instance,
+	// e.g. namespace. The series of the same instance and device are summed.
+	InstanceLabel string `yaml:"instance_label"`
+	// Join computes the metric from the other metrics of the profile instead
+	// of querying Prometheus
+	Join *JoinConfig `yaml:"join"`
 }
 
 type Server struct {
@@ -30,11 +42,26 @@ type Server struct {
 	PrometheusServer string
 	ConfigFile       string
 
-	rwMutex         *sync.RWMutex
-	metricConfigMap map[string]*MetricConfig
+	rwMutex *sync.RWMutex
+	// metricConfigMap has the metrics of every profile, by the profile and
+	// the name of the metric
+	metricConfigMap map[string]map[string]*MetricConfig
 	promClient      promv1.API
 	shutdownWG      sync.WaitGroup
-	metrics         map[string]*types.ClusterMetric
+	// metrics are the latest metrics of every profile
+	metrics map[string]map[string]*types.ClusterMetric
+	// metricsUpdateAt is the evaluation time of the metrics
+	metricsUpdateAt time.Time
+	// metricsUpdated will be closed and replaced when metrics are updated
+	metricsUpdated chan struct{}
+
+	profileMutex sync.Mutex
+	// profileRequestedAt is the time of the last request of every profile,
+	// only the default profile and the requested ones are evaluated
+	profileRequestedAt map[string]time.Time
+	// profileRequested wakes up the poll loop to evaluate the profile which
+	// was idle
+	profileRequested chan struct{}
 
 	grpcServer *grpc.Server
 }
@@ -49,7 +76,11 @@ func NewServer(listenAddr, promServer, cfgFile string) *Server {
 		PrometheusServer: promServer,
 		ConfigFile:       cfgFile,
 
-		rwMutex: &sync.RWMutex{},
+		rwMutex:        &sync.RWMutex{},
+		metricsUpdated: make(chan struct{}),
+
+		profileRequestedAt: map[string]time.Time{},
+		profileRequested:   make(chan struct{}, 1),
 	}
 }
 
@@ -86,6 +117,12 @@ func (s *Server) Start() error {
 		return errors.Wrapf(err, "cannot connecting to %s", s.PrometheusServer)
 	}
 
+	// load the profiles before serving, so the requests of them are valid
+	if err := s.reloadMetricConfigMap(); err != nil {
+		logrus.Errorf("failed to load the configuration files: %v", err)
+	}
+	ConfigCheckedAt = time.Now()
+
 	s.grpcServer = NewGRPCServer(s)
 	s.startGRPCServer()
 
@@ -97,45 +134,174 @@ func (s *Server) Start() error {
 			ConfigCheckedAt = time.Now()
 		}
 
-		metrics, err := s.getMetrics()
+		// align the evaluation time to the poll interval
+		evalAt := time.Now().Truncate(types.PollInterval)
+		metrics, err := s.getMetrics(evalAt)
 		if err != nil {
 			logrus.Errorf("failed to complete metrics retrieval: %v", err)
 		}
-		s.refreshMetrics(metrics)
+		s.refreshMetrics(metrics, evalAt)
 
-		time.Sleep(types.PollInterval)
+		select {
+		case <-time.After(time.Until(evalAt.Add(types.PollInterval))):
+		case <-s.profileRequested:
+		}
 	}
-	return nil
 }
 
-func (s *Server) reloadMetricConfigMap() error {
-	var err error
+// requestProfile marks the profile as requested, and wakes up the poll loop
+// if the profile was idle
+func (s *Server) requestProfile(profile string) {
+	s.profileMutex.Lock()
+	defer s.profileMutex.Unlock()
 
-	f, err := os.Open(s.ConfigFile)
-	if err != nil {
-		return errors.Wrapf(err, "cannot open the metrics config file %v", s.ConfigFile)
+	now := time.Now()
+	idle := !s.isProfileActive(profile, now)
+	s.profileRequestedAt[profile] = now
+	if idle {
+		select {
+		case s.profileRequested <- struct{}{}:
+		default:
+		}
 	}
-	defer f.Close()
+}
 
-	metricConfigs := []*MetricConfig{}
+// isProfileActive returns true if the profile is the default one or was
+// requested recently, caller must hold the profile lock
+func (s *Server) isProfileActive(profile string, now time.Time) bool {
+	if profile == types.DefaultProfile {
+		return true
+	}
+	requestedAt, exists := s.profileRequestedAt[profile]
+	return exists && now.Sub(requestedAt) < types.ProfileIdleTimeout
+}
 
-	if err := yaml.NewDecoder(f).Decode(&metricConfigs); err != nil {
-		return errors.Wrapf(err, "cannot decode the metrics config file %v", s.ConfigFile)
+// reloadMetricConfigMap loads the metrics config of all the profiles. The
+// profile keeps the previous config if failed to load.
+func (s *Server) reloadMetricConfigMap() error {
+	metricConfigMap := map[string]map[string]*MetricConfig{}
+	errs := []string{}
+	for _, profile := range config.Profiles() {
+		configs, err := s.loadProfile(profile)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("profile %v: %v", profile, err))
+			// only this goroutine changes the config
+			configs = s.metricConfigMap[profile]
+		}
+		if configs != nil {
+			metricConfigMap[profile] = configs
+		}
 	}
 
 	s.rwMutex.Lock()
-	defer s.rwMutex.Unlock()
+	s.metricConfigMap = metricConfigMap
+	s.rwMutex.Unlock()
 
-	s.metricConfigMap = map[string]*MetricConfig{}
-	for _, m := range metricConfigs {
-		s.metricConfigMap[m.Name] = m
+	if len(errs) != 0 {
+		return errors.New(strings.Join(errs, "; "))
 	}
-
 	return nil
 }
 
-func (s *Server) refreshMetrics(metrics map[string]*types.ClusterMetric) {
+// loadProfile returns the metrics config of the profile, the file of the
+// default profile can be specified
+func (s *Server) loadProfile(profile string) (map[string]*MetricConfig, error) {
+	file := ""
+	if profile == types.DefaultProfile {
+		file = s.ConfigFile
+	}
+	data, sources, err := config.Load(config.ProfileFile(profile, config.MetricsConfigFile), file)
+	if err != nil {
+		return nil, err
+	}
+	source := strings.Join(sources, ", ")
+
+	metricsConfig, err := decodeMetricsConfig(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot decode the metrics config from %v", source)
+	}
+	vars, err := templateVars(metricsConfig.Vars)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid variables in the metrics config from %v", source)
+	}
+	if err := defaultValueTypes(profile, metricsConfig.Metrics); err != nil {
+		return nil, errors.Wrapf(err, "invalid metrics config from %v", source)
+	}
+	for _, m := range metricsConfig.Metrics {
+		if err := expandQuery(m, vars); err != nil {
+			return nil, errors.Wrapf(err, "invalid config for metric %v", m.Name)
+		}
+	}
+
+	metricConfigMap := map[string]*MetricConfig{}
+	for _, m := range metricsConfig.Metrics {
+		metricConfigMap[m.Name] = m
+	}
+	if err := validateJoins(metricConfigMap); err != nil {
+		return nil, errors.Wrapf(err, "invalid metrics config from %v", source)
+	}
+
+	metricConfigMap = map[string]*MetricConfig{}
+	for _, m := range metricsConfig.Metrics {
+		windowConfigs, err := expandWindows(m)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid config for metric %v", m.Name)
+		}
+		for _, wm := range windowConfigs {
+			metricConfigMap[wm.Name] = wm
+		}
+	}
+	return metricConfigMap, nil
+}
+
+func (s *Server) refreshMetrics(metrics map[string]map[string]*types.ClusterMetric, evalAt time.Time) {
 	s.rwMutex.Lock()
 	defer s.rwMutex.Unlock()
 	s.metrics = metrics
+	s.metricsUpdateAt = evalAt
+
+	close(s.metricsUpdated)
+	s.metricsUpdated = make(chan struct{})
+}
+
+// valueTypes returns the value types of the metrics of the profile, caller
+// must hold the lock
+func (s *Server) valueTypes(profile string) map[string]string {
+	valueTypes := map[string]string{}
+	for name, cfg := range s.metricConfigMap[profile] {
+		valueTypes[name] = cfg.ValueType
+	}
+	return valueTypes
+}
+
+// metricNames returns the names of the metrics of the profile, caller must
+// hold the lock
+func (s *Server) metricNames(profile string) []string {
+	names := []string{}
+	for name := range s.metricConfigMap[profile] {
+		names = append(names, name)
+	}
+	return names
+}
+
+// profile returns the profile of the request, which is the default profile if
+// not specified, caller must hold the lock
+func (s *Server) profile(profile string) (string, error) {
+	if profile == "" {
+		profile = types.DefaultProfile
+	}
+	if _, exists := s.metricConfigMap[profile]; !exists {
+		return "", fmt.Errorf("unknown profile %v, must be one of %v", profile, strings.Join(s.profiles(), ","))
+	}
+	return profile, nil
+}
+
+// profiles returns the names of the loaded profiles, caller must hold the lock
+func (s *Server) profiles() []string {
+	profiles := []string{}
+	for p := range s.metricConfigMap {
+		profiles = append(profiles, p)
+	}
+	sort.Strings(profiles)
+	return profiles
 }
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/yasker/kstat/pkg/config"
+	"github.com/yasker/kstat/pkg/types"
+)
+
+// metricFormat is the part of the metric format config used by the server
+type metricFormat struct {
+	Name      string `yaml:"name"`
+	ValueType string `yaml:"value_type"`
+}
+
+// defaultValueTypes sets the value types missing in the metrics config, e.g.
+// of the configs before value_type, to the ones in the metric format of the
+// profile, which the client summarizes the metrics by as well
+func defaultValueTypes(profile string, metrics []*MetricConfig) error {
+	var formatValueTypes map[string]string
+	for _, m := range metrics {
+		if m.ValueType == "" {
+			if formatValueTypes == nil {
+				var err error
+				if formatValueTypes, err = loadFormatValueTypes(profile); err != nil {
+					return err
+				}
+			}
+			m.ValueType = formatValueTypes[m.Name]
+		}
+		if m.ValueType == "" {
+			return fmt.Errorf("no value_type for metric %v, in neither the metrics config nor the metrics format", m.Name)
+		}
+		if !types.IsValueType(m.ValueType) {
+			return fmt.Errorf("invalid value_type %v for metric %v", m.ValueType, m.Name)
+		}
+	}
+	return nil
+}
+
+func loadFormatValueTypes(profile string) (map[string]string, error) {
+	data, _, err := config.Load(config.ProfileFile(profile, config.MetricsFormatFile), "")
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot load the metrics format for the value types")
+	}
+	formats := []*metricFormat{}
+	if err := yaml.Unmarshal(data, &formats); err != nil {
+		return nil, errors.Wrap(err, "cannot decode the metrics format for the value types")
+	}
+	valueTypes := map[string]string{}
+	for _, f := range formats {
+		valueTypes[f.Name] = f.ValueType
+	}
+	return valueTypes, nil
+}
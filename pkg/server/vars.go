@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v2"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+const (
+	VarRateInterval   = "RateInterval"
+	VarScrapeInterval = "ScrapeInterval"
+	VarJob            = "Job"
+	VarExcludeDevices = "ExcludeDevices"
+
+	DefaultJob = "node-exporter"
+)
+
+// MetricsConfig is the metrics config file with the variables for the
+// query_string templates. The file can also be only the list of the metrics.
+type MetricsConfig struct {
+	Vars    map[string]string `yaml:"vars"`
+	Metrics []*MetricConfig   `yaml:"metrics"`
+}
+
+func decodeMetricsConfig(data []byte) (*MetricsConfig, error) {
+	cfg := &MetricsConfig{}
+	if err := yaml.Unmarshal(data, &cfg.Metrics); err == nil {
+		return cfg, nil
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// templateVars returns the variables for the query_string templates, the
+// user defined ones override the defaults
+func templateVars(vars map[string]string) (map[string]string, error) {
+	result := map[string]string{
+		VarScrapeInterval: model.Duration(types.SampleInterval).String(),
+		VarJob:            DefaultJob,
+		VarExcludeDevices: "",
+	}
+	for k, v := range vars {
+		result[k] = v
+	}
+	result[VarExcludeDevices] = excludeDevicesRegex(result[VarExcludeDevices])
+	if _, exists := vars[VarRateInterval]; !exists {
+		scrapeInterval, err := model.ParseDuration(result[VarScrapeInterval])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %v", VarScrapeInterval)
+		}
+		result[VarRateInterval] = rateInterval(time.Duration(scrapeInterval)).String()
+	}
+	return result, nil
+}
+
+// excludeDevicesRegex returns the regex of the comma separated device names
+// for the string in the query, e.g. `device!~"{{.ExcludeDevices}}"`. The names
+// are quoted so e.g. "sda.1" only matches itself, except * which matches any
+// characters, e.g. "veth*".
+func excludeDevicesRegex(names string) string {
+	patterns := []string{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		patterns = append(patterns, strings.Replace(regexp.QuoteMeta(name), `\*`, ".*", -1))
+	}
+	// the backslashes are escaped in the string of PromQL
+	return strings.Replace(strings.Join(patterns, "|"), `\`, `\\`, -1)
+}
+
+// rateInterval covers at least two scrapes for rate() to work, and two polls
+// to not miss anything between the polls
+func rateInterval(scrapeInterval time.Duration) model.Duration {
+	interval := 2 * types.PollInterval
+	if 2*scrapeInterval > interval {
+		interval = 2 * scrapeInterval
+	}
+	return model.Duration(interval)
+}
+
+// expandQuery executes the query_string of the metric as the template
+func expandQuery(cfg *MetricConfig, vars map[string]string) error {
+	tmpl, err := template.New(cfg.Name).Option("missingkey=error").Parse(cfg.QueryString)
+	if err != nil {
+		return errors.Wrap(err, "cannot parse the query_string")
+	}
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, vars); err != nil {
+		return errors.Wrap(err, "cannot execute the query_string")
+	}
+	cfg.QueryString = buf.String()
+	return nil
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestExcludeDevicesRegex(t *testing.T) {
+	tests := []struct {
+		names    string
+		want     string
+		excluded []string
+		included []string
+	}{
+		{"", "", nil, []string{"sda"}},
+		{"lo, veth*", "lo|veth.*", []string{"lo", "veth1a2b"}, []string{"eth0", "lo0"}},
+		{"sda.1", `sda\\.1`, []string{"sda.1"}, []string{"sdax1"}},
+		{"/dev/mapper/x+y", `/dev/mapper/x\\+y`, []string{"/dev/mapper/x+y"}, []string{"/dev/mapper/xxy"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.names, func(t *testing.T) {
+			got := excludeDevicesRegex(tt.names)
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+			// unescape the string of PromQL, which anchors the regex
+			re := regexp.MustCompile("^(?:" + strings.Replace(got, `\\`, `\`, -1) + ")$")
+			for _, dev := range tt.excluded {
+				if !re.MatchString(dev) {
+					t.Errorf("%v is not excluded", dev)
+				}
+			}
+			for _, dev := range tt.included {
+				if re.MatchString(dev) {
+					t.Errorf("%v is excluded", dev)
+				}
+			}
+		})
+	}
+}
+
+func TestExpandQueryExcludeDevices(t *testing.T) {
+	vars, err := templateVars(map[string]string{VarExcludeDevices: "sda.1,loop*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &MetricConfig{Name: "disk_read", QueryString: `rate(node_disk_read_bytes_total{device!~"{{.ExcludeDevices}}"}[{{.RateInterval}}])`}
+	if err := expandQuery(cfg, vars); err != nil {
+		t.Fatal(err)
+	}
+	want := `rate(node_disk_read_bytes_total{device!~"sda\\.1|loop.*"}[10s])`
+	if cfg.QueryString != want {
+		t.Errorf("got %v, want %v", cfg.QueryString, want)
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/yasker/kstat/pkg/types"
+)
+
+// rangeSelectorRegex matches the range of the range vector selectors, e.g.
+// [10s], but not the subqueries
+var rangeSelectorRegex = regexp.MustCompile(`\[[0-9]+[smhdwy][0-9smhdwy]*\]`)
+
+// expandWindows returns the metric config for each window of the metric, or
+// the metric config itself if there is no window
+func expandWindows(cfg *MetricConfig) ([]*MetricConfig, error) {
+	if len(cfg.Windows) == 0 {
+		return []*MetricConfig{cfg}, nil
+	}
+
+	configs := []*MetricConfig{}
+	for i, w := range cfg.Windows {
+		if _, err := model.ParseDuration(w); err != nil {
+			return nil, fmt.Errorf("invalid window %v: %v", w, err)
+		}
+		wcfg := *cfg
+		wcfg.Windows = nil
+		wcfg.QueryString = windowQuery(cfg.QueryString, w)
+		if i != 0 {
+			wcfg.Name = types.WindowMetricName(cfg.Name, w)
+		}
+		configs = append(configs, &wcfg)
+	}
+	return configs, nil
+}
+
+// windowQuery replaces the ranges in the query with the window. The query
+// without a range, e.g. for a gauge, is averaged over the window instead.
+func windowQuery(query, window string) string {
+	if rangeSelectorRegex.MatchString(query) {
+		return rangeSelectorRegex.ReplaceAllString(query, "["+window+"]")
+	}
+	return fmt.Sprintf("avg_over_time((%s)[%s:])", query, window)
+}
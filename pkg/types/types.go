@@ -1,6 +1,8 @@
 package types
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/prometheus/common/model"
@@ -10,14 +12,39 @@ var (
 	ConfigCheckInterval = 30 * time.Second
 	PollInterval        = 5 * time.Second
 	GRPCServiceTimeout  = 10 * time.Second
-)
 
-const (
-	SampleInterval = "10s"
+	GRPCKeepaliveTime     = 30 * time.Second
+	GRPCKeepaliveTimeout  = 10 * time.Second
+	GRPCKeepaliveMinTime  = 10 * time.Second
+	GRPCReconnectMaxDelay = 30 * time.Second
+
+	// KubeRequestTimeout is the timeout of the requests to the Kubernetes
+	// apiserver, except the port forward
+	KubeRequestTimeout = 30 * time.Second
+
+	// MaxQueryRangePoints is the maximum number of snapshots of a range,
+	// same as the limit of Prometheus
+	MaxQueryRangePoints = int64(11000)
+
+	// SampleInterval is the default scrape interval of the metrics in
+	// Prometheus
+	SampleInterval = 5 * time.Second
+
+	// ProfileIdleTimeout is how long the server keeps evaluating the profile
+	// other than the default one after the last request of it
+	ProfileIdleTimeout = time.Minute
 )
 
 const (
 	InstanceLabel = model.LabelName("instance")
+
+	// DefaultProfile is the profile of the metrics of the nodes, which is
+	// used if no profile is specified
+	DefaultProfile = "node"
+	// PodProfile is the profile of the metrics of the pods on the nodes
+	PodProfile = "pod"
+	// NamespaceProfile is the profile of the usage of the namespaces
+	NamespaceProfile = "namespace"
 )
 
 // ClusterMetric use the instance name as the key
@@ -33,12 +60,85 @@ type InstanceMetric struct {
 
 	// Value stores the metric value if there is no associated device
 	Value int64
+
+	// Labels are shared by all the series of the instance
+	Labels map[string]string
+	// DeviceLabels are the labels of each device besides the shared ones
+	DeviceLabels map[string]map[string]string
+}
+
+// WindowMetricName returns the name of the metric evaluated in the window
+// besides the first one of the metric
+func WindowMetricName(name, window string) string {
+	return name + "_" + window
+}
+
+// Summary returns the value represents the whole instance for the value type
+func (m *InstanceMetric) Summary(valueType string) int64 {
+	if valueType == ValueTypeCPU || valueType == ValueTypePercent || valueType == ValueTypeDuration {
+		return m.Average
+	}
+	return m.Total
 }
 
 const (
 	ValueTypeCPU  = "cpu"
 	ValueTypeSize = "size"
+	// ValueTypePercent is a percentage other than the CPU usage, e.g. the
+	// used space of a filesystem
+	ValueTypePercent = "percent"
+	// ValueTypeCount is a number of things, e.g. the IOPS or the packets per
+	// second
+	ValueTypeCount = "count"
+	// ValueTypeDuration is a duration in microseconds, e.g. the latency
+	ValueTypeDuration = "duration"
 
-	ValueTypeCPUFormat  = "%5s"
-	ValueTypeSizeFormat = "%8s"
+	ValueTypeCPUFormat      = "%5s"
+	ValueTypeSizeFormat     = "%8s"
+	ValueTypePercentFormat  = "%5s"
+	ValueTypeCountFormat    = "%8s"
+	ValueTypeDurationFormat = "%8s"
 )
+
+// IsValueType returns true if the value type is one of the known ones
+func IsValueType(valueType string) bool {
+	switch valueType {
+	case ValueTypeCPU, ValueTypeSize, ValueTypePercent, ValueTypeCount, ValueTypeDuration:
+		return true
+	}
+	return false
+}
+
+// FormatCount returns the number with the SI suffix, e.g. 1.5k
+func FormatCount(v int64) string {
+	return formatScaled(v, 1000, []string{"", "k", "M", "G", "T"})
+}
+
+// FormatDuration returns the duration in microseconds with the unit, e.g.
+// 1.5ms
+func FormatDuration(v int64) string {
+	return formatScaled(v, 1000, []string{"us", "ms", "s"})
+}
+
+func formatScaled(v int64, base float64, units []string) string {
+	sign := ""
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	value := float64(v)
+	i := 0
+	for value >= base && i < len(units)-1 {
+		value /= base
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%v%d%v", sign, v, units[0])
+	}
+	// keep the decimal only for the small numbers to fit the width
+	if value >= 100 {
+		return fmt.Sprintf("%v%.0f%v", sign, value, units[i])
+	}
+	s := strings.TrimSuffix(fmt.Sprintf("%.1f", value), ".0")
+	return sign + s + units[i]
+}